@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
+	"runtime"
 
 	bl "github.com/diegorezm/DBlockchain/internals/blockchain"
 	"github.com/diegorezm/DBlockchain/internals/handlers"
@@ -19,6 +22,15 @@ func main() {
 	r.Use(middleware.Logger)
 
 	port := flag.Int("port", 3000, "Port to listen on (default 3000)")
+	dataDir := flag.String("data", "", "Directory where the chain is stored (default data/node-<port>)")
+	minerAddress := flag.String("miner", "", "Address that receives the rewards of mined blocks when no wallet is set")
+	minerWorkers := flag.Int("miners", runtime.NumCPU(), "Goroutines used to mine blocks")
+	mempoolPolicy := bl.DefaultMempoolPolicy()
+	flag.IntVar(&mempoolPolicy.MaxCount, "mempool-count", mempoolPolicy.MaxCount, "Transactions kept in the mempool at most")
+	flag.IntVar(&mempoolPolicy.MaxBytes, "mempool-bytes", mempoolPolicy.MaxBytes, "Total size of the transactions kept in the mempool at most")
+	flag.DurationVar(&mempoolPolicy.TTL, "mempool-ttl", mempoolPolicy.TTL, "Pending transactions not mined after this long are dropped")
+	minRelayFee := flag.Int64("min-relay-fee", int64(mempoolPolicy.MinRelayFee), "Base units per byte a transaction must pay to enter the mempool")
+	adminToken := flag.String("admin-token", "", "Bearer token required by the admin API (the admin API is disabled when empty)")
 	flag.Parse()
 
 	if *port == 4040 {
@@ -35,16 +47,34 @@ func main() {
 		panic(err)
 	}
 
-	blockchain := bl.NewBlockchain(fullAddr)
-	registerHandlers(r, blockchain)
+	if *dataDir == "" {
+		*dataDir = filepath.Join("data", fmt.Sprintf("node-%d", *port))
+	}
+
+	blockchain, err := bl.OpenBlockchain(fullAddr, *dataDir)
+	if errors.Is(err, bl.ErrLegacyStore) {
+		log.Fatalf("%v", err)
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer blockchain.Close()
+	blockchain.MinerAddress = *minerAddress
+	blockchain.MinerWorkers = *minerWorkers
+	mempoolPolicy.MinRelayFee = bl.Amount(*minRelayFee)
+	blockchain.Mempool.SetPolicy(mempoolPolicy)
+
+	registerHandlers(r, blockchain, *adminToken)
 
 	fmt.Printf("Client listening on port %s\n", addr)
 	log.Fatalf("%v", http.ListenAndServe(addr, r))
 }
 
-func registerHandlers(r *chi.Mux, blockchain *bl.Blockchain) {
+func registerHandlers(r *chi.Mux, blockchain *bl.Blockchain, adminToken string) {
 	blockchainHandler := handlers.NewBlockchainClientHandler(blockchain)
 	walletHandler := handlers.NewWalletHandler(blockchain)
+	mempoolHandler := handlers.NewMempoolHandler(blockchain, adminToken)
+	psbtHandler := handlers.NewPSBTHandler(blockchain)
 	frontendHandler := handlers.NewFrontendHandler(blockchain)
 
 	// PAGES
@@ -62,6 +92,8 @@ func registerHandlers(r *chi.Mux, blockchain *bl.Blockchain) {
 	r.Route("/api", func(r chi.Router) {
 		blockchainHandler.Register(r)
 		walletHandler.Register(r)
+		mempoolHandler.Register(r)
+		psbtHandler.Register(r)
 	})
 
 	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,144 @@
+"""
+Reimplementation of the canonical encoding used for block hashes, transaction ids and sighashes
+(internals/blockchain/encoding.go), so the chain can be checked without Go.
+
+Run it to check it against the published test vectors:
+
+    python canonical.py [path/to/canonical_vectors.json]
+"""
+import hashlib
+import json
+import os
+import struct
+import sys
+
+VECTORS = os.path.join(
+    os.path.dirname(__file__), "..", "internals", "blockchain", "testdata", "canonical_vectors.json"
+)
+
+MERKLE_LEAF_PREFIX = b"\x00"
+MERKLE_INNER_PREFIX = b"\x01"
+EMPTY_MERKLE_ROOT = "0" * 64
+
+
+def u32(value):
+    return struct.pack(">I", value)
+
+
+def u64(value):
+    return struct.pack(">Q", value)
+
+
+def i64(value):
+    return struct.pack(">q", value)
+
+
+def string(value):
+    raw = value.encode("utf-8")
+    return u32(len(raw)) + raw
+
+
+def blob(hex_value):
+    raw = bytes.fromhex(hex_value)
+    return u32(len(raw)) + raw
+
+
+def encode_block_header(header):
+    return (
+        u32(header["version"])
+        + u64(header["index"])
+        + i64(header["timestamp"])
+        + string(header["merkle_root"])
+        + string(header["prev_hash"])
+        + u64(header["nonce"])
+        + u32(header["difficulty"])
+    )
+
+
+def encode_transaction(tx):
+    out = u32(tx["version"]) + u32(len(tx["tx_ins"]))
+    for tx_in in tx["tx_ins"]:
+        out += string(tx_in["tx_out_id"]) + i64(tx_in["tx_out_index"])
+        if tx["version"] >= 3:
+            # Version 3 commits to the sequence of every input.
+            out += u32(tx_in.get("sequence", 0))
+    out += u32(len(tx["tx_outs"]))
+    for tx_out in tx["tx_outs"]:
+        out += string(tx_out["address"]) + i64(tx_out["amount"])
+        if tx["version"] >= 5:
+            # Version 5 commits to the locking script of every output.
+            out += blob(tx_out.get("script", ""))
+    if tx["version"] >= 4:
+        out += u32(tx.get("lock_time", 0))
+    return out
+
+
+def encode_sighash(tx, index, spent_address, spent_amount, spent_script):
+    out = encode_transaction(tx) + u32(index) + string(spent_address) + i64(spent_amount)
+    if tx["version"] >= 5:
+        out += blob(spent_script)
+    return out
+
+
+def sha256_hex(data):
+    return hashlib.sha256(data).hexdigest()
+
+
+def merkle_root(tx_ids):
+    if not tx_ids:
+        return EMPTY_MERKLE_ROOT
+
+    level = [hashlib.sha256(MERKLE_LEAF_PREFIX + i.encode("utf-8")).digest() for i in tx_ids]
+    while len(level) > 1:
+        pairs = []
+        for i in range(0, len(level), 2):
+            if i + 1 == len(level):
+                # An odd node out moves up as is.
+                pairs.append(level[i])
+            else:
+                pairs.append(hashlib.sha256(MERKLE_INNER_PREFIX + level[i] + level[i + 1]).digest())
+        level = pairs
+    return level[0].hex()
+
+
+def check(path):
+    with open(path, encoding="utf-8") as f:
+        vectors = json.load(f)
+
+    failures = 0
+
+    def expect(name, got, want):
+        nonlocal failures
+        if got != want:
+            failures += 1
+            print(f"[x] {name}: got {got}, want {want}")
+
+    for header in vectors["block_headers"]:
+        encoded = encode_block_header(header)
+        expect(f"header {header['name']} encoding", encoded.hex(), header["encoding"])
+        expect(f"header {header['name']} hash", sha256_hex(encoded), header["hash"])
+
+    for tx in vectors["transactions"]:
+        encoded = encode_transaction(tx)
+        expect(f"transaction {tx['name']} encoding", encoded.hex(), tx["encoding"])
+        expect(f"transaction {tx['name']} id", sha256_hex(encoded), tx["id"])
+
+    transactions = {tx["name"]: tx for tx in vectors["transactions"]}
+    for vector in vectors["sighashes"]:
+        tx = transactions[vector["transaction"]]
+        encoded = encode_sighash(
+            tx, vector["input"], vector["spent_address"], vector["spent_amount"], vector.get("spent_script", "")
+        )
+        expect(f"sighash of {tx['name']} input {vector['input']}", sha256_hex(encoded), vector["sighash"])
+
+    for vector in vectors["merkle_roots"]:
+        expect(f"merkle root of {vector['tx_ids']}", merkle_root(vector["tx_ids"]), vector["root"])
+
+    return failures
+
+
+if __name__ == "__main__":
+    failures = check(sys.argv[1] if len(sys.argv) > 1 else VECTORS)
+    if failures:
+        sys.exit(1)
+    print("All canonical encoding vectors match.")
@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/diegorezm/DBlockchain/internals/utils"
+)
+
+// Addresses are Base58Check encoded, see utils.EncodeBase58Check, so a mistyped address is
+// rejected instead of paying nobody. The version byte tells what the payload is.
+const (
+	AddressVersionPubKeyHash byte = 0x00 // The SHA-256 of the public key, see PubKeyHash
+	AddressVersionScript     byte = 0x05 // The SHA-256 of a multisig or hash-time-locked script, see PayToScriptHash
+)
+
+// PubKeyHashAddress returns the address of the outputs the key hashing to pubKeyHash can spend.
+func PubKeyHashAddress(pubKeyHash []byte) string {
+	return utils.EncodeBase58Check(AddressVersionPubKeyHash, pubKeyHash)
+}
+
+// ScriptAddress returns the address of the outputs locked by script. It only holds the hash of
+// the script, so paying to it does not need to know the keys behind the script, and the
+// spender reveals the script instead.
+func ScriptAddress(script Script) string {
+	sum := sha256.Sum256(script)
+	return utils.EncodeBase58Check(AddressVersionScript, sum[:])
+}
+
+// NewAddress returns the address of pubKey.
+func NewAddress(pubKey *ecdsa.PublicKey) (string, error) {
+	raw, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+	return PubKeyHashAddress(PubKeyHash(raw)), nil
+}
+
+// AddressOf returns the address of a base64 encoded public key, see utils.EncodeKeyPair.
+func AddressOf(publicKey string) (string, error) {
+	pubKey, err := utils.DecodePublicKey(strings.TrimSpace(publicKey))
+	if err != nil {
+		return "", fmt.Errorf("%q is not a public key: %w", publicKey, err)
+	}
+	return NewAddress(pubKey)
+}
+
+// ValidateAddress reports why address can't be paid, if it can't.
+func ValidateAddress(address string) error {
+	_, err := addressScript(address)
+	return err
+}
+
+// addressScript returns the locking script of the outputs paying address: a
+// pay-to-public-key-hash script for the address of a key, a pay-to-script-hash script for a script address.
+// A raw public key, which legacy outputs were paid to, is still taken as the address of its key.
+func addressScript(address string) (Script, error) {
+	if pubKey, err := decodePublicKey(address); err == nil {
+		return PayToPubKeyHash(PubKeyHash(pubKey)), nil
+	}
+
+	version, payload, err := utils.DecodeBase58Check(strings.TrimSpace(address))
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid address: %w", address, err)
+	}
+	switch version {
+	case AddressVersionPubKeyHash:
+		if len(payload) != sha256.Size {
+			return nil, fmt.Errorf("%q holds a public key hash of %d bytes instead of %d", address, len(payload), sha256.Size)
+		}
+		return PayToPubKeyHash(payload), nil
+	case AddressVersionScript:
+		if len(payload) != sha256.Size {
+			return nil, fmt.Errorf("%q holds a script hash of %d bytes instead of %d", address, len(payload), sha256.Size)
+		}
+		return PayToScriptHash(payload), nil
+	default:
+		return nil, fmt.Errorf("%q has the unknown address version %d", address, version)
+	}
+}
+
+// addressPubKeyHash returns the public key hash of the address of a key.
+func addressPubKeyHash(address string) ([]byte, error) {
+	script, err := addressScript(address)
+	if err != nil {
+		return nil, err
+	}
+	pubKeyHash, ok := script.PubKeyHash()
+	if !ok {
+		return nil, fmt.Errorf("%q is not the address of a key", address)
+	}
+	return pubKeyHash, nil
+}
+
+// decodePublicKey returns the PKIX encoding of a base64 encoded public key.
+func decodePublicKey(publicKey string) ([]byte, error) {
+	pubKey, err := utils.DecodePublicKey(strings.TrimSpace(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a public key: %w", publicKey, err)
+	}
+	return x509.MarshalPKIXPublicKey(pubKey)
+}
@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/diegorezm/DBlockchain/internals/utils"
+)
+
+func TestAddress_LegacyKeysAndAddressesFindTheSameOutputs(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, publicKey := newTestKey(t)
+	address, err := NewAddress(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromKey, err := AddressOf(publicKey); err != nil || fromKey != address {
+		t.Fatalf("AddressOf() = %q, %v, want %q", fromKey, err, address)
+	}
+
+	// A legacy output pays the raw key, the coinbase pays the address.
+	legacy := &Transaction{Version: CurrentTxVersion, Id: "legacy-tx", TxOuts: []TxOut{{Address: publicKey, Amount: Coin}}}
+	appendTestBlock(t, bc, NewBlock(BlockInsert{Index: 1, PrevHash: bc.GetLastBlock().Hash, Transactions: []Transaction{*legacy}}))
+	mineBlocks(t, bc, 1, address)
+
+	for _, a := range []string{address, publicKey} {
+		if n := len(bc.GetUTXPoolByAddress(a)); n != 2 {
+			t.Errorf("GetUTXPoolByAddress(%.12s...) found %d outputs, want 2", a, n)
+		}
+	}
+
+	_, other := newTestKey(t)
+	otherAddress, _ := AddressOf(other)
+	input, err := bc.BuildPayment(Payment{From: address, To: otherAddress, Amount: Coin + Coin/2, Fee: testFee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := mustSign(t, bc, input, priv)
+	if err := bc.AppendTransaction(tx); err != nil {
+		t.Fatalf("AppendTransaction() spending the legacy and the new output = %v", err)
+	}
+}
+
+func TestAddress_MistypedAddressesAreRejected(t *testing.T) {
+	_, publicKey := newTestKey(t)
+	address, _ := AddressOf(publicKey)
+	if !strings.HasPrefix(address, "1") {
+		t.Errorf("the address %q should start with 1, the encoding of its version", address)
+	}
+
+	// Swap two characters, the most common typo.
+	if address[5] == address[6] {
+		t.Skip("the address has twice the same character where the typo was planned")
+	}
+	typo := address[:5] + string(address[6]) + string(address[5]) + address[7:]
+	if err := ValidateAddress(typo); !errors.Is(err, utils.ErrChecksumMismatch) {
+		t.Errorf("ValidateAddress(%q) = %v, want ErrChecksumMismatch", typo, err)
+	}
+
+	bc := NewBlockchain("")
+	if _, err := bc.BuildPayment(Payment{From: address, To: typo, Amount: Coin}); err == nil {
+		t.Error("BuildPayment() to a mistyped address should fail")
+	}
+	if _, err := bc.BuildPayment(Payment{From: typo, To: address, Amount: Coin}); errors.Is(err, ErrInsufficientFunds) || err == nil {
+		t.Errorf("BuildPayment() from a mistyped address = %v, want the address rejected", err)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+	}{
+		{"unknown version", utils.EncodeBase58Check(0x42, PubKeyHash([]byte("key")))},
+		{"short public key hash", utils.EncodeBase58Check(AddressVersionPubKeyHash, []byte("short"))},
+		{"short script hash", utils.EncodeBase58Check(AddressVersionScript, []byte("short"))},
+		{"not base58", "0OIl"},
+	}
+	for _, tt := range tests {
+		if err := ValidateAddress(tt.address); err == nil {
+			t.Errorf("ValidateAddress() of %s should fail", tt.name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of dcoins counted in base units, the smallest amount that can be sent.
+type Amount int64
+
+const (
+	// Base units in one dcoin.
+	Coin Amount = 100_000_000
+	// Digits after the decimal point of an amount written in dcoins.
+	AmountDecimals = 8
+	// No single amount can be larger than this, so sums of a few of them never overflow.
+	MaxMoney Amount = 100_000_000 * Coin
+)
+
+var (
+	ErrAmountOverflow = errors.New("amount overflows")
+	ErrInvalidAmount  = errors.New("invalid amount")
+)
+
+// AddAmounts sums the amounts, failing instead of wrapping around.
+func AddAmounts(amounts ...Amount) (Amount, error) {
+	total := Amount(0)
+	for _, a := range amounts {
+		sum := total + a
+		if (a > 0 && sum < total) || (a < 0 && sum > total) {
+			return 0, ErrAmountOverflow
+		}
+		total = sum
+	}
+	return total, nil
+}
+
+// SubAmounts returns a - b, failing instead of wrapping around.
+func SubAmounts(a, b Amount) (Amount, error) {
+	diff := a - b
+	if (b > 0 && diff > a) || (b < 0 && diff < a) {
+		return 0, ErrAmountOverflow
+	}
+	return diff, nil
+}
+
+// MulAmount returns a * n, failing instead of wrapping around.
+func MulAmount(a Amount, n uint64) (Amount, error) {
+	if a == 0 || n == 0 {
+		return 0, nil
+	}
+	abs := uint64(a)
+	if a < 0 {
+		abs = uint64(-a)
+	}
+	if n > math.MaxInt64/abs {
+		return 0, ErrAmountOverflow
+	}
+	return a * Amount(n), nil
+}
+
+// String writes the amount in dcoins without trailing zeros, e.g. "12.5".
+func (a Amount) String() string {
+	sign := ""
+	units := uint64(a)
+	if a < 0 {
+		sign = "-"
+		units = uint64(-a)
+	}
+
+	whole := units / uint64(Coin)
+	fraction := units % uint64(Coin)
+	if fraction == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	digits := strings.TrimRight(fmt.Sprintf("%0*d", AmountDecimals, fraction), "0")
+	return fmt.Sprintf("%s%d.%s", sign, whole, digits)
+}
+
+// ParseAmount reads an amount written in dcoins, like "12.5" or "0.00000001".
+// It never goes through floating point, and rejects amounts finer than one base unit.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if len(fraction) > AmountDecimals {
+		if strings.TrimRight(fraction[AmountDecimals:], "0") != "" {
+			return 0, fmt.Errorf("%w: %q has more than %d decimals", ErrInvalidAmount, s, AmountDecimals)
+		}
+		fraction = fraction[:AmountDecimals]
+	}
+	fraction += strings.Repeat("0", AmountDecimals-len(fraction))
+	if whole == "" {
+		whole = "0"
+	}
+
+	// Only plain digits, strconv would also accept signs and underscores.
+	for _, c := range whole + fraction {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+		}
+	}
+
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil || Amount(units) > MaxMoney {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+	if negative {
+		return -Amount(units), nil
+	}
+	return Amount(units), nil
+}
+
+// MarshalJSON writes the amount as a JSON number in dcoins.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number or string in dcoins. The digits are parsed as written,
+// so an amount never goes through a float.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := string(bytes.Trim(data, `"`))
+	if strings.ContainsAny(text, "eE") {
+		// Exponents come from clients printing large or tiny amounts as floats.
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, text)
+		}
+		text = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	parsed, err := ParseAmount(text)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
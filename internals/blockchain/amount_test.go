@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAmount_ParseAndFormat(t *testing.T) {
+	tests := []struct {
+		text string
+		want Amount
+		back string
+	}{
+		{"0", 0, "0"},
+		{"12.5", 1250 * Coin / 100, "12.5"},
+		{"0.1", Coin / 10, "0.1"},
+		{"0.00000001", 1, "0.00000001"},
+		{".5", Coin / 2, "0.5"},
+		{"3.10000000", 310 * Coin / 100, "3.1"},
+		{"-2", -2 * Coin, "-2"},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.text)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseAmount(%q) = %d, %v, want %d", tt.text, got, err, tt.want)
+			continue
+		}
+		if got.String() != tt.back {
+			t.Errorf("Amount(%d).String() = %q, want %q", got, got.String(), tt.back)
+		}
+	}
+
+	for _, text := range []string{"", ".", "abc", "1.000000001", "1e3", "1_000", "100000001"} {
+		if _, err := ParseAmount(text); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("ParseAmount(%q) = %v, want %v", text, err, ErrInvalidAmount)
+		}
+	}
+}
+
+func TestAmount_CheckedArithmetic(t *testing.T) {
+	if _, err := AddAmounts(math.MaxInt64, 1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("AddAmounts overflow = %v, want %v", err, ErrAmountOverflow)
+	}
+	if _, err := SubAmounts(math.MinInt64, 1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("SubAmounts overflow = %v, want %v", err, ErrAmountOverflow)
+	}
+	if _, err := MulAmount(MaxMoney, 1_000); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MulAmount overflow = %v, want %v", err, ErrAmountOverflow)
+	}
+
+	// 0.1 + 0.2 is exactly 0.3 in base units.
+	sum, err := AddAmounts(Coin/10, 2*Coin/10)
+	if err != nil || sum != 3*Coin/10 {
+		t.Errorf("AddAmounts(0.1, 0.2) = %s, %v, want 0.3", sum, err)
+	}
+}
+
+func TestAmount_DecodesFloatJSON(t *testing.T) {
+	// Written when amounts were floats.
+	raw := `[{"address": "bob-address", "amount": 12.5}, {"address": "alice", "amount": 0.1}, {"address": "carol", "amount": 1e-7}]`
+
+	var txOuts []TxOut
+	if err := json.Unmarshal([]byte(raw), &txOuts); err != nil {
+		t.Fatalf("Failed to decode float amounts: %v", err)
+	}
+	if txOuts[0].Amount != 1250*Coin/100 || txOuts[1].Amount != Coin/10 || txOuts[2].Amount != 10 {
+		t.Fatalf("decoded %+v", txOuts)
+	}
+
+	encoded, err := json.Marshal(txOuts[0])
+	if err != nil || string(encoded) != `{"address":"bob-address","amount":12.5}` {
+		t.Errorf("json.Marshal(TxOut) = %s, %v", encoded, err)
+	}
+}
@@ -9,13 +9,21 @@ type BlockInsert struct {
 // A block in the chain
 type Block struct {
 	BlockInsert `json:"block_insert"`
-	Hash        string `json:"hash"`      // The current hash
-	Nonce       uint64 `json:"nonce"`     // The cryptographic challenge
-	Timestamp   int64  `json:"timestamp"` // The time the block was added to the chain
+	Version     uint32 `json:"version"`     // How the header is encoded for hashing
+	Hash        string `json:"hash"`        // The current hash
+	MerkleRoot  string `json:"merkle_root"` // Root of the Merkle tree over the transaction ids
+	Nonce       uint64 `json:"nonce"`       // The cryptographic challenge
+	Difficulty  uint32 `json:"difficulty"`  // Leading zero bits the hash must have
+	Timestamp   int64  `json:"timestamp"`   // The time the block was added to the chain
+	ChainWork   string `json:"chain_work"`  // Hex encoded work of the whole chain up to this block
 }
 
+// The version of the header encoding, see encoding.go. A new version can add header fields.
+const CurrentBlockVersion uint32 = 1
+
 func NewBlock(data BlockInsert) *Block {
 	return &Block{
 		BlockInsert: data,
+		Version:     CurrentBlockVersion,
 	}
 }
@@ -0,0 +1,216 @@
+package blockchain
+
+import (
+	"container/heap"
+	"slices"
+)
+
+// BlockTemplate is the content of the next block before it is mined.
+type BlockTemplate struct {
+	Height       uint64        `json:"height"`
+	PrevHash     string        `json:"prev_hash"`
+	Difficulty   uint32        `json:"difficulty"`
+	Transactions []Transaction `json:"transactions"` // The coinbase comes first
+	MerkleRoot   string        `json:"merkle_root"`
+	Fees         Amount        `json:"fees"`
+	Size         int           `json:"size"`
+}
+
+// NewBlockTemplate picks the mempool transactions paying the highest fee rate that fit in
+// a block, counting the fees of their pending ancestors, and puts in front of them a coinbase paying the subsidy plus their fees to minerAddress.
+func (b *Blockchain) NewBlockTemplate(minerAddress string) (*BlockTemplate, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.newBlockTemplate(minerAddress)
+}
+
+// newBlockTemplate is NewBlockTemplate. b.mu must be held.
+func (b *Blockchain) newBlockTemplate(minerAddress string) (*BlockTemplate, error) {
+	lastBlock := b.lastBlock()
+	height := lastBlock.Index + 1
+
+	b.Mempool.Expire()
+	entries := b.Mempool.Entries()
+	byId := make(map[string]MempoolEntry, len(entries))
+	for _, entry := range entries {
+		byId[entry.Tx.Id] = entry
+	}
+	order := make(map[string]int, len(entries))
+	children := make(map[string][]string)
+	for i, entry := range entries {
+		order[entry.Tx.Id] = i
+		for _, txIn := range entry.Tx.TxIns {
+			if _, pending := byId[txIn.TxOutId]; pending {
+				children[txIn.TxOutId] = append(children[txIn.TxOutId], entry.Tx.Id)
+			}
+		}
+	}
+
+	// Leave room for the coinbase, sized with the amount that takes the most digits.
+	placeholder, err := NewCoinbaseTransaction(height, minerAddress, MaxMoney-1)
+	if err != nil {
+		return nil, err
+	}
+	size := placeholder.Size()
+
+	view := newBlockView(b.utxos, height)
+	mtp := medianTimePast(b.Chain)
+	selected := make([]Transaction, 0)
+	included := make(map[string]bool)
+	skipped := make(map[string]bool)
+	fees := Amount(0)
+
+	// Take the package paying the highest fee rate, a transaction with the ancestors that aren't
+	// in the block yet, until nothing fits. Parents always end up ahead of their children, and a
+	// child paying a high fee pulls in its parents. Ties keep the arrival order.
+	// Every transaction has a package in the queue, which is only updated for the descendants
+	// of the packages taken. The older packages left behind in the queue are skipped.
+	queue := make(packageQueue, 0, len(entries))
+	versions := make(map[string]int, len(entries))
+	push := func(txId string) {
+		versions[txId]++
+		pkg := ancestorPackage(byId, txId, included)
+		heap.Push(&queue, packageCandidate{pkg: pkg, order: order[txId], version: versions[txId]})
+	}
+	for _, entry := range entries {
+		push(entry.Tx.Id)
+	}
+
+	for queue.Len() > 0 {
+		candidate := heap.Pop(&queue).(packageCandidate)
+		best := candidate.pkg
+		tip := best.Entries[len(best.Entries)-1].Tx.Id
+		if candidate.version != versions[tip] || included[tip] || skipped[tip] {
+			continue
+		}
+		if slices.ContainsFunc(best.Entries, func(e MempoolEntry) bool { return skipped[e.Tx.Id] }) {
+			skipped[tip] = true
+			continue
+		}
+		if size+best.Size > b.Params.MaxBlockSize {
+			skipped[tip] = true
+			continue
+		}
+
+		// Check the package on its own view, so a failure leaves the block untouched.
+		pkgView := newBlockView(view, height)
+		pkgFees, valid := fees, true
+		for _, entry := range best.Entries {
+			fee, err := checkTransaction(&entry.Tx, pkgView)
+			if err == nil {
+				// Transactions held back until their locks expire wait for a later block.
+				err = checkLocks(&entry.Tx, pkgView, height, mtp)
+			}
+			if err == nil {
+				pkgFees, err = AddAmounts(pkgFees, fee)
+			}
+			if err != nil {
+				skipped[entry.Tx.Id], skipped[tip] = true, true
+				valid = false
+				break
+			}
+			pkgView.apply(&entry.Tx)
+		}
+		if !valid {
+			continue
+		}
+
+		fees = pkgFees
+		size += best.Size
+		for _, entry := range best.Entries {
+			view.apply(&entry.Tx)
+			selected = append(selected, entry.Tx)
+			included[entry.Tx.Id] = true
+		}
+		// The descendants of the package no longer need it, their packages shrink.
+		for _, txId := range pendingDescendants(children, best.Entries, included) {
+			if !skipped[txId] {
+				push(txId)
+			}
+		}
+	}
+
+	reward, err := AddAmounts(b.Params.BlockSubsidy(height), fees)
+	if err != nil {
+		return nil, err
+	}
+	coinbase, err := NewCoinbaseTransaction(height, minerAddress, reward)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := append([]Transaction{*coinbase}, selected...)
+	return &BlockTemplate{
+		Height:       height,
+		PrevHash:     lastBlock.Hash,
+		Difficulty:   b.Params.nextDifficulty(b.Chain),
+		Transactions: transactions,
+		MerkleRoot:   blockMerkleRoot(transactions),
+		Fees:         fees,
+		Size:         size - placeholder.Size() + coinbase.Size(),
+	}, nil
+}
+
+// packageCandidate is the package of a transaction waiting in a packageQueue. Only the latest
+// version pushed for a transaction is current.
+type packageCandidate struct {
+	pkg     TxPackage
+	order   int // Arrival order of the transaction in the mempool
+	version int
+}
+
+// packageQueue is a heap of packages, the highest fee rate first and the earliest arrival on ties.
+type packageQueue []packageCandidate
+
+func (q packageQueue) Len() int { return len(q) }
+
+func (q packageQueue) Less(i, j int) bool {
+	if a, b := q[i].pkg.FeeRate(), q[j].pkg.FeeRate(); a != b {
+		return a > b
+	}
+	return q[i].order < q[j].order
+}
+
+func (q packageQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *packageQueue) Push(x any) { *q = append(*q, x.(packageCandidate)) }
+
+func (q *packageQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// pendingDescendants returns the transactions spending the outputs of entries, directly or not,
+// that aren't included yet. children lists the pending transactions spending each transaction.
+func pendingDescendants(children map[string][]string, entries []MempoolEntry, included map[string]bool) []string {
+	descendants := make([]string, 0)
+	visited := make(map[string]bool)
+	queue := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		queue = append(queue, entry.Tx.Id)
+	}
+	for len(queue) > 0 {
+		txId := queue[0]
+		queue = queue[1:]
+		for _, child := range children[txId] {
+			if visited[child] || included[child] {
+				continue
+			}
+			visited[child] = true
+			descendants = append(descendants, child)
+			queue = append(queue, child)
+		}
+	}
+	return descendants
+}
+
+// blockSize is the serialized size of every transaction in the block.
+func blockSize(block *Block) int {
+	size := 0
+	for i := range block.Transactions {
+		size += block.Transactions[i].Size()
+	}
+	return size
+}
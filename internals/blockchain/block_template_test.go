@@ -0,0 +1,181 @@
+package blockchain
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/diegorezm/DBlockchain/internals/utils"
+)
+
+func TestBlockTemplate_PrefersHigherFeeRate(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+
+	priv, err := utils.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate keypair: %v", err)
+	}
+	keypair, err := utils.EncodeKeyPair(priv)
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+
+	// Two coinbases to spend independently.
+	var funding []Transaction
+	for range 2 {
+		if err, _ := bc.AppendBlockFor(keypair.PublicKey); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+		funding = append(funding, bc.GetLastBlock().Transactions[0])
+	}
+
+	pay := func(coinbase Transaction, fee Amount) *Transaction {
+		tx, err := bc.SignTransaction(TransactionInput{
+			TxIns:  []TxIn{{TxOutId: coinbase.Id, TxOutIndex: 0}},
+			TxOuts: []TxOut{{Address: "bob-address", Amount: coinbase.TxOuts[0].Amount - fee}},
+		}, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AppendTransaction(tx); err != nil {
+			t.Fatalf("Failed to append tx: %v", err)
+		}
+		return tx
+	}
+	cheap := pay(funding[0], 1*Coin)
+	generous := pay(funding[1], 3*Coin)
+
+	entries := bc.Mempool.Entries()
+	if entries[0].Fee != 1*Coin || entries[1].Fee != 3*Coin {
+		t.Fatalf("fees = %v, %v, want 1 and 3", entries[0].Fee, entries[1].Fee)
+	}
+
+	// Only room for the coinbase and one payment.
+	coinbase, _ := NewCoinbaseTransaction(3, keypair.PublicKey, MaxMoney-1)
+	bc.Params.MaxBlockSize = coinbase.Size() + generous.Size()
+
+	template, err := bc.NewBlockTemplate(keypair.PublicKey)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate() = %v", err)
+	}
+	if len(template.Transactions) != 2 || template.Transactions[1].Id != generous.Id {
+		t.Fatalf("template should hold the coinbase and the generous payment, got %v", template.Transactions)
+	}
+	if template.Fees != 3*Coin {
+		t.Errorf("template.Fees = %v, want 3", template.Fees)
+	}
+
+	if err, _ := bc.AppendBlockFor(keypair.PublicKey); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	reward := bc.GetLastBlock().Transactions[0].TxOuts[0].Amount
+	if want := bc.Params.BlockSubsidy(3) + 3*Coin; reward != want {
+		t.Errorf("coinbase pays %v, want %v", reward, want)
+	}
+
+	// The cheap payment waits for the next block.
+	if entries := bc.Mempool.Entries(); len(entries) != 1 || entries[0].Tx.Id != cheap.Id {
+		t.Errorf("mempool should only hold the cheap payment, got %v", entries)
+	}
+}
+
+func TestBlockTemplate_ChildPaysForParent(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+
+	var funding []Transaction
+	for range 2 {
+		if err, _ := bc.AppendBlockFor(address); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+		funding = append(funding, bc.GetLastBlock().Transactions[0])
+	}
+
+	send := func(txIn TxIn, value Amount, fee Amount) *Transaction {
+		tx, err := bc.SignTransaction(TransactionInput{
+			TxIns:  []TxIn{txIn},
+			TxOuts: []TxOut{{Address: "bob-address", Amount: Coin}, {Address: address, Amount: value - Coin - fee}},
+		}, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AppendTransaction(tx); err != nil {
+			t.Fatalf("Failed to append tx: %v", err)
+		}
+		return tx
+	}
+
+	// The parent barely pays anything, the child spends its unconfirmed change with a big fee.
+	reward := funding[0].TxOuts[0].Amount
+	parent := send(TxIn{TxOutId: funding[0].Id, TxOutIndex: 0}, reward, Coin/1000)
+	child := send(TxIn{TxOutId: parent.Id, TxOutIndex: 1}, parent.TxOuts[1].Amount, 2*Coin)
+	other := send(TxIn{TxOutId: funding[1].Id, TxOutIndex: 0}, reward, Coin/2)
+
+	if spendable := bc.SpendableOutputs(address); len(spendable) != 2 {
+		t.Errorf("SpendableOutputs() = %v, want the change of the child and of the other payment", spendable)
+	}
+
+	// Room for the parent and the child, not for the other payment on top.
+	coinbase, _ := NewCoinbaseTransaction(3, address, MaxMoney-1)
+	bc.Params.MaxBlockSize = coinbase.Size() + parent.Size() + child.Size() + other.Size()/2
+
+	template, err := bc.NewBlockTemplate(address)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate() = %v", err)
+	}
+	if len(template.Transactions) != 3 || template.Transactions[1].Id != parent.Id || template.Transactions[2].Id != child.Id {
+		t.Fatalf("template holds %v, want the parent then the child", transactionIds(template.Transactions))
+	}
+
+	if err, _ := bc.AppendBlockFor(address); err != nil {
+		t.Fatalf("Failed to mine the chained transactions: %v", err)
+	}
+	if entries := bc.Mempool.Entries(); len(entries) != 1 || entries[0].Tx.Id != other.Id {
+		t.Errorf("mempool holds %v, want only the other payment", entries)
+	}
+}
+
+func TestBlockTemplate_UpdatesThePackagesOfDescendants(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+
+	var funding []Transaction
+	for range 2 {
+		if err, _ := bc.AppendBlockFor(address); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+		funding = append(funding, bc.GetLastBlock().Transactions[0])
+	}
+
+	send := func(txIn TxIn, txOuts ...TxOut) *Transaction {
+		tx, err := bc.SignTransaction(TransactionInput{TxIns: []TxIn{txIn}, TxOuts: txOuts}, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AppendTransaction(tx); err != nil {
+			t.Fatalf("Failed to append tx: %v", err)
+		}
+		return tx
+	}
+
+	// The parent barely pays anything and has two children. Once the first child pulls it in,
+	// the second one pays more on its own than the unrelated payment.
+	reward := funding[0].TxOuts[0].Amount
+	half := reward / 2
+	parent := send(TxIn{TxOutId: funding[0].Id, TxOutIndex: 0},
+		TxOut{Address: address, Amount: half}, TxOut{Address: address, Amount: reward - half - Coin/1000})
+	first := send(TxIn{TxOutId: parent.Id, TxOutIndex: 0}, TxOut{Address: "bob-address", Amount: half - 2*Coin})
+	second := send(TxIn{TxOutId: parent.Id, TxOutIndex: 1}, TxOut{Address: "bob-address", Amount: parent.TxOuts[1].Amount - Coin/2})
+	other := send(TxIn{TxOutId: funding[1].Id, TxOutIndex: 0}, TxOut{Address: "bob-address", Amount: reward - Coin/3})
+
+	template, err := bc.NewBlockTemplate(address)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate() = %v", err)
+	}
+	want := []string{parent.Id, first.Id, second.Id, other.Id}
+	if got := transactionIds(template.Transactions[1:]); !slices.Equal(got, want) {
+		t.Errorf("template holds %v, want %v", got, want)
+	}
+}
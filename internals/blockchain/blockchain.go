@@ -1,189 +1,375 @@
 package blockchain
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"encoding/gob"
-	"encoding/hex"
+	"context"
+	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"runtime"
+	"slices"
+	"sync"
 	"time"
 
-	"github.com/diegorezm/DBlockchain/internals/utils"
 	webutils "github.com/diegorezm/DBlockchain/internals/web_utils"
 )
 
 type Blockchain struct {
-	Chain               []Block       `json:"chain"`
-	TransactionsMempool []Transaction `json:"transactions_mempool"`
-	Difficulty          uint32        `json:"difficulty"`
-	ServerUrl           string        `json:"server_url"`
-	CurrentNode         string        `json:"current_node"`
+	Chain        []Block         `json:"chain"`
+	Mempool      *Mempool        `json:"-"`
+	Params       ConsensusParams `json:"params"`
+	ServerUrl    string          `json:"server_url"`
+	CurrentNode  string          `json:"current_node"`
+	MinerAddress string          `json:"miner_address"` // Where the rewards of blocks mined by this node go by default
+	MinerWorkers int             `json:"miner_workers"` // Goroutines used to mine a block
+
+	store *BlockStore // nil when the chain only lives in memory
+	utxos *UTXOSet
+	tree  *BlockTree // Every known block, including side chains and orphans
+
+	mu         sync.RWMutex  // Guards the chain, the UTXO set and the block tree, changes to the tip take the write lock
+	tipChanged chan struct{} // Closed and replaced every time the tip of the chain changes
+
+	reorgs           []ReorgEvent // The most recent reorgs, oldest first
+	reorgSubscribers []chan ReorgEvent
 }
 
 func NewBlockchain(currentNode string) *Blockchain {
 	chain := make([]Block, 1)
 	chain[0] = *generateGenesis()
 
+	utxos, err := buildUTXOSet(chain)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to index the genesis block: %v\n", err))
+	}
+
 	return &Blockchain{
-		Chain:               chain,
-		Difficulty:          4,
-		ServerUrl:           "http://localhost:4040",
-		CurrentNode:         currentNode,
-		TransactionsMempool: make([]Transaction, 0),
+		Chain:        chain,
+		Mempool:      NewMempool(),
+		Params:       DefaultConsensusParams(),
+		ServerUrl:    "http://localhost:4040",
+		CurrentNode:  currentNode,
+		MinerWorkers: runtime.NumCPU(),
+		utxos:        utxos,
+		tree:         newBlockTree(chain),
+		tipChanged:   make(chan struct{}),
 	}
 }
 
+// OpenBlockchain loads the chain kept in dataDir, creating it with a fresh genesis block
+// if the directory is empty. Every block appended afterwards is persisted there.
+func OpenBlockchain(currentNode, dataDir string) (*Blockchain, error) {
+	store, err := OpenBlockStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := store.LoadBlocks()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	b := NewBlockchain(currentNode)
+	b.store = store
+
+	if len(blocks) == 0 {
+		if err := store.Append(&b.Chain[0]); err != nil {
+			store.Close()
+			return nil, err
+		}
+		return b, nil
+	}
+
+	utxos, err := b.validateChain(blocks)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("store: the chain stored in %s is not valid: %w", dataDir, err)
+	}
+
+	b.Chain = blocks
+	b.utxos = utxos
+	b.tree = newBlockTree(blocks)
+	return b, nil
+}
+
+// Close releases the underlying block store, if there is one.
+func (b *Blockchain) Close() error {
+	if b.store == nil {
+		return nil
+	}
+	return b.store.Close()
+}
+
+// AppendBlock mines a new block paying the reward to the node's MinerAddress.
 func (b *Blockchain) AppendBlock() (error, int) {
-	lastBlock := b.GetLastBlock()
+	return b.AppendBlockFor(b.MinerAddress)
+}
+
+// AppendBlockFor mines a new block whose coinbase pays the subsidy and the fees to minerAddress.
+func (b *Blockchain) AppendBlockFor(minerAddress string) (error, int) {
+	return b.AppendBlockContext(context.Background(), minerAddress)
+}
+
+// AppendBlockContext mines a new block for minerAddress using MinerWorkers goroutines.
+// Mining stops with ErrMiningCanceled when ctx is done, and with ErrStaleBlock when another
+// block becomes the tip of the chain before a solution is found.
+func (b *Blockchain) AppendBlockContext(ctx context.Context, minerAddress string) (error, int) {
+	// Building the template only reads the chain, queries go on meanwhile.
+	b.mu.RLock()
+	lastBlock := b.lastBlock()
+	template, err := b.newBlockTemplate(minerAddress)
+	tipChanged := b.tipChanged
+	b.mu.RUnlock()
+
 	if lastBlock == nil {
 		return fmt.Errorf("Something went wrong while getting the last block."), 0
 	}
+	if err != nil {
+		return err, 0
+	}
 
 	newBlockInsert := BlockInsert{
-		PrevHash: lastBlock.Hash,
-		Index:    lastBlock.Index + 1,
-		// TODO: Maybe i should add a way for the user to choose the transactions he wants to add
-		Transactions: b.TransactionsMempool,
+		PrevHash:     template.PrevHash,
+		Index:        template.Height,
+		Transactions: template.Transactions,
 	}
 
 	blockToMine := NewBlock(newBlockInsert)
+	blockToMine.MerkleRoot = template.MerkleRoot
 	blockToMine.Timestamp = time.Now().Unix()
+	blockToMine.Difficulty = template.Difficulty
+	blockToMine.ChainWork = accumulateWork(lastBlock, template.Difficulty)
+
+	// The block is worthless once it no longer extends the tip, so stop mining it.
+	miningCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-tipChanged:
+			cancel(ErrStaleBlock)
+		case <-miningCtx.Done():
+		}
+	}()
 
-	newBlock, nonceCount := b.mine(blockToMine)
+	newBlock, nonceCount, err := mineBlock(miningCtx, blockToMine, b.MinerWorkers)
+	if err != nil {
+		if cause := context.Cause(miningCtx); errors.Is(cause, ErrStaleBlock) {
+			return ErrStaleBlock, nonceCount
+		}
+		return err, nonceCount
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.lastBlock().Hash != template.PrevHash {
+		return ErrStaleBlock, nonceCount
+	}
 
-	err := isBlockPairValid(lastBlock, newBlock)
+	err = b.validateBlock(b.Chain, newBlock, b.utxos, time.Now())
 
 	if err != nil {
 		return err, 0
 	}
 
+	if err := b.utxos.ConnectBlock(newBlock); err != nil {
+		return err, 0
+	}
+
+	if b.store != nil {
+		if err := b.store.Append(newBlock); err != nil {
+			b.utxos.DisconnectBlock(newBlock)
+			return err, 0
+		}
+	}
+
 	b.Chain = append(b.Chain, *newBlock)
-	b.TransactionsMempool = make([]Transaction, 0)
+	b.tree.add(*newBlock)
+	b.Mempool.Remove(transactionIds(newBlock.Transactions)...)
+	b.signalTipChange()
 	return nil, nonceCount
 }
 
+// signalTipChange wakes up everyone waiting on the current tip. b.mu must be held.
+func (b *Blockchain) signalTipChange() {
+	close(b.tipChanged)
+	b.tipChanged = make(chan struct{})
+}
+
+// GetLastBlock returns a copy of the tip of the chain.
 func (b *Blockchain) GetLastBlock() *Block {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	block := *b.lastBlock()
+	return &block
+}
+
+// lastBlock returns the tip of the chain. b.mu must be held.
+func (b *Blockchain) lastBlock() *Block {
 	return &b.Chain[len(b.Chain)-1]
 }
 
+// GetChain returns a copy of the active chain.
 func (b *Blockchain) GetChain() []Block {
-	return b.Chain
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return slices.Clone(b.Chain)
 }
 
+// AppendTransaction puts a valid transaction in the mempool. Transactions whose locks haven't
+// expired yet are held there too, block templates leave them out until they can be mined,
+// or until they expire from the mempool.
 func (b *Blockchain) AppendTransaction(tx *Transaction) error {
-	if err := b.ValidateTransaction(tx); err != nil {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.appendTransaction(tx)
+}
+
+// appendTransaction is AppendTransaction. b.mu must be held.
+func (b *Blockchain) appendTransaction(tx *Transaction) error {
+	// A transaction that is not final yet is held back in the mempool until it is.
+	if err := b.validateTransaction(tx); err != nil && !errors.Is(err, ErrNonFinal) {
 		return err
 	}
 
-	b.TransactionsMempool = append(b.TransactionsMempool, *tx)
-	return nil
+	fee, err := checkTransaction(tx, b.pendingView())
+	if err != nil {
+		return err
+	}
+	return b.Mempool.Add(*tx, fee)
 }
 
 // Get all unspent Transactions
 func (bc *Blockchain) GetUTXOPool() []UTXO {
-	utxos := make(map[string]UTXO)
-
-	for _, block := range bc.Chain {
-		for _, tx := range block.Transactions {
-			for i, txOut := range tx.TxOuts {
-				key := fmt.Sprintf("%s_%d", tx.Id, i)
-				utxos[key] = UTXO{
-					TxId:   tx.Id,
-					Index:  int64(i),
-					Output: txOut,
-				}
-			}
-
-			for _, txIn := range tx.TxIns {
-				key := fmt.Sprintf("%s_%d", txIn.TxOutId, txIn.TxOutIndex)
-				delete(utxos, key)
-			}
-		}
-	}
-
-	result := make([]UTXO, 0, len(utxos))
-
-	for _, u := range utxos {
-		result = append(result, u)
-	}
-
-	return result
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.utxos.All()
 }
 
 // Get unspent transactions by address
 func (b *Blockchain) GetUTXPoolByAddress(address string) []UTXO {
-	utxos := b.GetUTXOPool()
-	result := make([]UTXO, 0)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.utxos.ByAddress(address)
+}
 
-	fmt.Printf("utxos: %v\n", utxos)
+// MultisigOutputs returns the confirmed multisig outputs the key of address is one of the keys of.
+// Outputs paying a multisig address only hold the hash of the script, they are found by that address.
+func (b *Blockchain) MultisigOutputs(address string) []UTXO {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.utxos.ByCosigner(address)
+}
+
+// SpendableOutputs returns what address can spend right now: its confirmed outputs that no
+// pending transaction spends, followed by the unconfirmed outputs paying it, change included.
+func (b *Blockchain) SpendableOutputs(address string) []UTXO {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.spendableOutputs(address)
+}
 
-	for _, u := range utxos {
-		if strings.TrimSpace(u.Output.Address) == strings.TrimSpace(address) {
-			result = append(result, u)
+// spendableOutputs is SpendableOutputs. b.mu must be held.
+func (b *Blockchain) spendableOutputs(address string) []UTXO {
+	spendable := make([]UTXO, 0)
+	for _, utxo := range b.utxos.ByAddress(address) {
+		if _, pending := b.Mempool.SpentBy(OutPoint{TxId: utxo.TxId, Index: utxo.Index}); !pending {
+			spendable = append(spendable, utxo)
 		}
 	}
-
-	return result
+	return append(spendable, b.Mempool.UnconfirmedOutputs(address)...)
 }
 
-func (b *Blockchain) ValidateTransaction(tx *Transaction) error {
-	utxos := b.GetUTXOPool()
-
-	totalInput := float64(0)
-	totalOutput := float64(0)
+// pendingView is the UTXO set as it will be once the mempool is mined. b.mu must be held
+// for as long as the view is used.
+func (b *Blockchain) pendingView() utxoView {
+	return mempoolView{base: b.utxos, mempool: b.Mempool, height: b.lastBlock().Index + 1}
+}
 
-	for _, txIn := range tx.TxIns {
-		// 1. Find matching UTXO
-		utxoKey := fmt.Sprintf("%s_%d", txIn.TxOutId, txIn.TxOutIndex)
+// CheckUTXOConsistency compares the UTXO index with a full rescan of the chain.
+func (b *Blockchain) CheckUTXOConsistency() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.utxos.CheckConsistency(b.Chain)
+}
 
-		var utxo *UTXO
-		for _, u := range utxos {
-			if u.TxId == txIn.TxOutId && u.Index == txIn.TxOutIndex {
-				utxo = &u
-				break
-			}
-		}
-		if utxo == nil {
-			return fmt.Errorf("invalid TxIn: no matching UTXO for %s", utxoKey)
-		}
+// SignTransaction creates the transaction and signs each input with the matching key,
+// looking up the outputs the inputs spend in the UTXO set and the mempool. See NewSignedTransaction.
+func (b *Blockchain) SignTransaction(input TransactionInput, privKeys ...*ecdsa.PrivateKey) (*Transaction, error) {
+	spent, err := b.spentOutputs(input.TxIns)
+	if err != nil {
+		return nil, err
+	}
+	return NewSignedTransaction(input, spent, privKeys...)
+}
 
-		// 2. Verify the signature
-		pubKey, err := utils.DecodePublicKey(utxo.Output.Address)
-		if err != nil {
-			return fmt.Errorf("invalid public key for address %s", utxo.Output.Address)
+// spentOutputs looks up the outputs the inputs spend in the UTXO set and the mempool.
+func (b *Blockchain) spentOutputs(txIns []TxIn) ([]TxOut, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	view := b.pendingView()
+	spent := make([]TxOut, len(txIns))
+	for i, txIn := range txIns {
+		utxo, ok := view.Get(OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex})
+		if !ok {
+			return nil, fmt.Errorf("no unspent output %s_%d for input %d", txIn.TxOutId, txIn.TxOutIndex, i)
 		}
+		spent[i] = utxo.Output
+	}
+	return spent, nil
+}
 
-		if !VerifyTransactionSignature(tx.Id, txIn.Signature, pubKey) {
-			return fmt.Errorf("invalid signature for input %s", utxoKey)
-		}
+// ValidateTransaction checks a transaction against the confirmed UTXO set and the pending
+// transactions in the mempool. It may spend the outputs of pending transactions, and spend the
+// same outputs as pending transactions it is allowed to replace. A transaction that can't be
+// mined in the next block because of its locks, but is valid otherwise, fails with ErrNonFinal.
+func (b *Blockchain) ValidateTransaction(tx *Transaction) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.validateTransaction(tx)
+}
 
-		totalInput += utxo.Output.Amount
+// validateTransaction is ValidateTransaction. b.mu must be held.
+func (b *Blockchain) validateTransaction(tx *Transaction) error {
+	// Legacy transactions are only accepted in the blocks that already hold them.
+	if tx.Version < MinTxVersion {
+		return fmt.Errorf("transaction version %d is no longer relayed, use version %d", tx.Version, CurrentTxVersion)
 	}
-
-	// 3. Validate outputs
-	for _, txOut := range tx.TxOuts {
-		totalOutput += txOut.Amount
+	if tx.IsSystem {
+		return errors.New("system transactions are only allowed as the coinbase of a block")
 	}
 
-	// 4. Inputs must be ≥ outputs
-	if !tx.IsSystem && totalInput < totalOutput {
-		return fmt.Errorf("input (%.2f) < output (%.2f)", totalInput, totalOutput)
+	fee, err := checkTransaction(tx, b.pendingView())
+	if err != nil {
+		return err
+	}
+	if _, err := b.Mempool.CheckReplacement(tx, fee); err != nil {
+		return err
 	}
-	return nil
+	return b.checkFinal(tx)
+}
+
+// TransactionFee returns the fee the transaction pays, the sum of the outputs it spends
+// minus the sum of its outputs. The spent outputs may be confirmed or pending.
+func (b *Blockchain) TransactionFee(tx *Transaction) (Amount, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return checkTransaction(tx, b.pendingView())
 }
 
+// ReplaceChain asks every connected node for its chain and reorganizes to the valid one with
+// the most work.
 func (b *Blockchain) ReplaceChain() (bool, error) {
-	replacementChain := []Block{}
-	maxChainLen := len(b.Chain)
 	nodes, err := getConnectedNodes(b.ServerUrl)
 
 	if err != nil {
 		return false, err
 	}
 
+	candidates := make([][]Block, 0, len(nodes))
 	for _, address := range nodes {
 		chain, err := getBlockchainFromNode(address)
 
@@ -192,18 +378,51 @@ func (b *Blockchain) ReplaceChain() (bool, error) {
 		}
 
 		//fmt.Printf("Node: %s. Chain: %v\n", address, chain)
+		candidates = append(candidates, chain)
+	}
 
-		if len(chain) > maxChainLen {
-			maxChainLen = len(chain)
-			replacementChain = chain
-		}
+	replacementChain, _ := b.selectBestChain(candidates)
+	if replacementChain == nil {
+		return false, nil
 	}
 
-	if len(replacementChain) > 0 && IsChainValid(replacementChain) {
-		b.Chain = replacementChain
-		return true, nil
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// We may have mined a block while the candidates were being checked.
+	if !isBetterTip(&replacementChain[len(replacementChain)-1], b.lastBlock()) {
+		return false, nil
+	}
+	if err := b.reorganize(replacementChain); err != nil {
+		return false, err
 	}
-	return false, nil
+	return true, nil
+}
+
+// selectBestChain returns the valid candidate with more work than the current chain and
+// every other candidate, along with its UTXO set. It returns nil if none is better.
+func (b *Blockchain) selectBestChain(candidates [][]Block) ([]Block, *UTXOSet) {
+	var best []Block
+	var bestUTXOs *UTXOSet
+	bestTip := b.GetLastBlock()
+
+	for _, chain := range candidates {
+		if len(chain) == 0 || !isBetterTip(&chain[len(chain)-1], bestTip) {
+			continue
+		}
+
+		// The claimed chain work is only trusted once the whole chain checks out.
+		utxos, err := b.validateChain(chain)
+		if err != nil {
+			fmt.Printf("Ignoring candidate chain: %v\n", err)
+			continue
+		}
+
+		bestTip = &chain[len(chain)-1]
+		best = chain
+		bestUTXOs = utxos
+	}
+	return best, bestUTXOs
 }
 
 func getConnectedNodes(serverUrl string) ([]string, error) {
@@ -255,68 +474,11 @@ func getBlockchainFromNode(address string) ([]Block, error) {
 	return response.Data, nil
 }
 
-// This function mines the chain untils it finds a valid block, when this block is found
+// mine mines the block on every core until it finds a valid block, when this block is found
 // the mining stops and the valid block is returned.
 func (b *Blockchain) mine(blockToMine *Block) (*Block, int) {
-	var nonce uint64 = 0
-	nonceCount := 0
-
-	for {
-		nonceCount++
-		blockToMine.Nonce = nonce
-		computedHash := hashBlock(blockToMine)
-
-		if strings.HasPrefix(computedHash, strings.Repeat("0", int(b.Difficulty))) {
-			blockToMine.Hash = computedHash
-			break
-		} else {
-			nonce++
-		}
-		if nonce > 1_000_000_000 {
-			panic("Mining failed to find a block within 1 billion attempts (difficulty too high or logic error)\n")
-		}
-	}
-	return blockToMine, nonceCount
-}
-
-func IsChainValid(chain []Block) bool {
-	prevBlock := chain[0]
-	for i := 1; i < len(chain)-1; i++ {
-		currentBlock := chain[i]
-		err := isBlockPairValid(&prevBlock, &currentBlock)
-		if err != nil {
-			fmt.Print(err)
-			return false
-		}
-		prevBlock = currentBlock
-	}
-	return true
-}
-
-func isBlockPairValid(prevBlock, nextBlock *Block) error {
-	nextBlockHash := hashBlock(nextBlock)
-	if nextBlockHash != nextBlock.Hash {
-		return fmt.Errorf(
-			"ERROR: Block hash mismatch for Block #%d. Stored hash: %s, Re-computed hash: %s. Block details: %v\n",
-			nextBlock.Index, nextBlock.Hash, nextBlockHash, nextBlock,
-		)
-	}
-
-	if prevBlock.Hash != nextBlock.PrevHash {
-		return fmt.Errorf(
-			"ERROR: Previous hash mismatch for Block #%d. Expected PrevHash (from prev block #%d): %s, Got PrevHash (in current block): %s\n",
-			nextBlock.Index, prevBlock.Index, prevBlock.Hash, nextBlock.PrevHash,
-		)
-	}
-
-	if prevBlock.Index != nextBlock.Index-1 {
-		return fmt.Errorf(
-			"ERROR: Block index sequence mismatch. Previous block #%d, Next block #%d. Expected Next block to be #%d\n",
-			prevBlock.Index, nextBlock.Index, prevBlock.Index+1,
-		)
-	}
-
-	return nil
+	block, nonceCount, _ := mineBlock(context.Background(), blockToMine, b.MinerWorkers)
+	return block, nonceCount
 }
 
 func generateGenesis() *Block {
@@ -325,37 +487,15 @@ func generateGenesis() *Block {
 		Index:    0,
 	}
 	block := NewBlock(newBlockInsert)
+	block.MerkleRoot = blockMerkleRoot(block.Transactions)
 	hash := hashBlock(block)
 	block.Hash = hash
+	block.ChainWork = blockWork(block.Difficulty).Text(16)
 	return block
 }
 
-type blockHeader struct {
-	Index        uint64
-	Timestamp    int64
-	Transactions []Transaction
-	PrevHash     string
-	Nonce        uint64
-}
-
+// hashBlock hashes the canonical encoding of the block header. The transactions are only
+// committed to through the Merkle root, so a header can be checked without them.
 func hashBlock(b *Block) string {
-	header := blockHeader{
-		Index:        b.Index,
-		Timestamp:    b.Timestamp,
-		Transactions: b.Transactions,
-		PrevHash:     b.PrevHash,
-		Nonce:        b.Nonce,
-	}
-
-	var buf bytes.Buffer
-
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(header)
-
-	if err != nil {
-		panic(fmt.Sprintf("Failed to gob encode block header for hashing: %v\n", err))
-	}
-	sum := sha256.Sum256(buf.Bytes())
-	hashSlice := sum[:]
-	return hex.EncodeToString(hashSlice)
+	return sha256Hex(encodeBlockHeader(b))
 }
@@ -1,11 +1,27 @@
 package blockchain
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/diegorezm/DBlockchain/internals/utils"
 )
 
+// appendTestBlock adds a block to the chain without mining it.
+// testFee is enough to pay the minimum relay fee of the default mempool policy.
+const testFee = Coin / 100
+
+func appendTestBlock(t *testing.T, bc *Blockchain, block *Block) {
+	t.Helper()
+	// The blocks mined on top of it inherit its difficulty.
+	block.Difficulty = bc.Params.nextDifficulty(bc.Chain)
+	if err := bc.utxos.ConnectBlock(block); err != nil {
+		t.Fatalf("Failed to connect test block: %v", err)
+	}
+	bc.Chain = append(bc.Chain, *block)
+}
+
 func TestBlockchain_Genesis(t *testing.T) {
 	blockchain := NewBlockchain("")
 	if len(blockchain.Chain) == 0 {
@@ -44,7 +60,7 @@ func TestBlockchain_Transaction(t *testing.T) {
 	fundingTx := &Transaction{
 		Id:     "funding-tx-1",
 		TxIns:  []TxIn{}, // coinbase or genesis, no input
-		TxOuts: []TxOut{{Address: keypair.PublicKey, Amount: 5.0}},
+		TxOuts: []TxOut{{Address: keypair.PublicKey, Amount: 5 * Coin}},
 	}
 	block := NewBlock(BlockInsert{
 		Index:    1,
@@ -52,7 +68,7 @@ func TestBlockchain_Transaction(t *testing.T) {
 	})
 	block.Transactions = []Transaction{*fundingTx}
 	block.Hash = "mockedhash"
-	blockchain.Chain = append(blockchain.Chain, *block)
+	appendTestBlock(t, blockchain, block)
 
 	// 4. Build transaction input using UTXO from fundingTx
 	txInput := TransactionInput{
@@ -62,13 +78,13 @@ func TestBlockchain_Transaction(t *testing.T) {
 			Signature:  "", // to be signed
 		}},
 		TxOuts: []TxOut{
-			{Address: "bob-address", Amount: 3.0},
-			{Address: keypair.PublicKey, Amount: 2.0},
+			{Address: "bob-address", Amount: 3 * Coin},
+			{Address: keypair.PublicKey, Amount: 2*Coin - testFee},
 		},
 	}
 
 	// 5. Sign and create transaction
-	newTX, err := NewSignedTransaction(txInput, priv)
+	newTX, err := blockchain.SignTransaction(txInput, priv)
 	if err != nil {
 		t.Fatalf("Failed to create signed transaction: %v", err)
 	}
@@ -122,7 +138,7 @@ func TestBlockchain_DoubleSpendingFails(t *testing.T) {
 	fundTx := &Transaction{
 		Id:     "funding-tx-1",
 		TxIns:  []TxIn{},
-		TxOuts: []TxOut{{Address: keypair.PublicKey, Amount: 5.0}},
+		TxOuts: []TxOut{{Address: keypair.PublicKey, Amount: 5 * Coin}},
 	}
 	block := NewBlock(BlockInsert{
 		Index:    1,
@@ -130,7 +146,7 @@ func TestBlockchain_DoubleSpendingFails(t *testing.T) {
 	})
 	block.Transactions = []Transaction{*fundTx}
 	block.Hash = "hash1"
-	blockchain.Chain = append(blockchain.Chain, *block)
+	appendTestBlock(t, blockchain, block)
 
 	// ⛏️ First transaction spends the UTXO
 	txInput1 := TransactionInput{
@@ -140,10 +156,10 @@ func TestBlockchain_DoubleSpendingFails(t *testing.T) {
 			Signature:  "",
 		}},
 		TxOuts: []TxOut{
-			{Address: "bob-address", Amount: 5.0},
+			{Address: "bob-address", Amount: 5*Coin - testFee},
 		},
 	}
-	tx1, err := NewSignedTransaction(txInput1, priv)
+	tx1, err := blockchain.SignTransaction(txInput1, priv)
 	if err != nil {
 		t.Fatalf("Failed to sign tx1: %v", err)
 	}
@@ -161,10 +177,10 @@ func TestBlockchain_DoubleSpendingFails(t *testing.T) {
 			Signature:  "",
 		}},
 		TxOuts: []TxOut{
-			{Address: "charlie-address", Amount: 5.0},
+			{Address: "charlie-address", Amount: 5 * Coin},
 		},
 	}
-	tx2, err := NewSignedTransaction(txInput2, priv)
+	tx2, err := NewSignedTransaction(txInput2, fundTx.TxOuts, priv) // The output is gone from the UTXO set
 	if err != nil {
 		t.Fatalf("Failed to sign tx2: %v", err)
 	}
@@ -176,3 +192,178 @@ func TestBlockchain_DoubleSpendingFails(t *testing.T) {
 		t.Logf("✅ Double-spending correctly failed: %v", err)
 	}
 }
+
+func TestBlockchain_UTXOIndexMatchesRescan(t *testing.T) {
+	blockchain := NewBlockchain("")
+
+	priv, err := utils.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate keypair: %v", err)
+	}
+	keypair, err := utils.EncodeKeyPair(priv)
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+
+	fundTx := &Transaction{
+		Id:     "funding-tx-1",
+		TxOuts: []TxOut{{Address: keypair.PublicKey, Amount: 5 * Coin}},
+	}
+	block := NewBlock(BlockInsert{
+		Index:        1,
+		PrevHash:     blockchain.GetLastBlock().Hash,
+		Transactions: []Transaction{*fundTx},
+	})
+	block.Hash = "hash1"
+	appendTestBlock(t, blockchain, block)
+
+	tx, err := blockchain.SignTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: "funding-tx-1", TxOutIndex: 0}},
+		TxOuts: []TxOut{{Address: "bob-address", Amount: 4 * Coin}, {Address: keypair.PublicKey, Amount: 1*Coin - testFee}},
+	}, priv)
+	if err != nil {
+		t.Fatalf("Failed to sign tx: %v", err)
+	}
+	if err := blockchain.AppendTransaction(tx); err != nil {
+		t.Fatalf("Failed to append tx: %v", err)
+	}
+	if err, _ := blockchain.AppendBlock(); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+
+	if err := blockchain.CheckUTXOConsistency(); err != nil {
+		t.Fatalf("Index out of sync after connect: %v", err)
+	}
+	if got := blockchain.GetUTXPoolByAddress(" bob-address\n"); len(got) != 1 || got[0].Output.Amount != 4*Coin {
+		t.Errorf("GetUTXPoolByAddress(bob) = %v, want one output of 4", got)
+	}
+	if got := blockchain.GetUTXPoolByAddress(keypair.PublicKey); len(got) != 1 || got[0].TxId != tx.Id {
+		t.Errorf("GetUTXPoolByAddress(sender) = %v, want the change output", got)
+	}
+
+	last := blockchain.GetLastBlock()
+	if err := blockchain.utxos.DisconnectBlock(last); err != nil {
+		t.Fatalf("Failed to disconnect block: %v", err)
+	}
+	blockchain.Chain = blockchain.Chain[:len(blockchain.Chain)-1]
+
+	if err := blockchain.CheckUTXOConsistency(); err != nil {
+		t.Fatalf("Index out of sync after disconnect: %v", err)
+	}
+	if _, ok := blockchain.utxos.Get(OutPoint{TxId: "funding-tx-1", Index: 0}); !ok {
+		t.Error("Disconnecting the block should restore the spent output")
+	}
+}
+
+func TestBlockchain_MempoolDoubleSpendFails(t *testing.T) {
+	blockchain := NewBlockchain("")
+
+	priv, err := utils.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate keypair: %v", err)
+	}
+	keypair, err := utils.EncodeKeyPair(priv)
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+
+	block := NewBlock(BlockInsert{
+		Index:    1,
+		PrevHash: blockchain.GetLastBlock().Hash,
+		Transactions: []Transaction{{
+			Id:     "funding-tx-1",
+			TxOuts: []TxOut{{Address: keypair.PublicKey, Amount: 5 * Coin}},
+		}},
+	})
+	block.Hash = "hash1"
+	appendTestBlock(t, blockchain, block)
+
+	tx1, err := blockchain.SignTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: "funding-tx-1", TxOutIndex: 0}},
+		TxOuts: []TxOut{{Address: "bob-address", Amount: 5*Coin - testFee}},
+	}, priv)
+	if err != nil {
+		t.Fatalf("Failed to sign tx1: %v", err)
+	}
+	tx2, err := blockchain.SignTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: "funding-tx-1", TxOutIndex: 0}},
+		TxOuts: []TxOut{{Address: "charlie-address", Amount: 5*Coin - testFee}},
+	}, priv)
+	if err != nil {
+		t.Fatalf("Failed to sign tx2: %v", err)
+	}
+
+	if err := blockchain.AppendTransaction(tx1); err != nil {
+		t.Fatalf("tx1 should be accepted: %v", err)
+	}
+	if err := blockchain.AppendTransaction(tx2); err == nil {
+		t.Fatal("tx2 spends the same output as a pending transaction and should be rejected")
+	}
+
+	// Even if the mempool was bypassed, the block must not contain both spends.
+	conflicting := NewBlock(BlockInsert{
+		Index:        2,
+		PrevHash:     blockchain.GetLastBlock().Hash,
+		Transactions: []Transaction{*tx1, *tx2},
+	})
+	conflicting.MerkleRoot = blockMerkleRoot(conflicting.Transactions)
+	conflicting.Timestamp = time.Now().Unix()
+	conflicting.Difficulty = blockchain.NextDifficulty()
+	conflicting.ChainWork = accumulateWork(blockchain.GetLastBlock(), conflicting.Difficulty)
+	conflicting, _ = blockchain.mine(conflicting)
+	err = blockchain.validateBlock(blockchain.Chain, conflicting, blockchain.utxos, time.Now())
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Rule != RuleDoubleSpend {
+		t.Fatalf("A block spending the same output twice should break the %s rule, got %v", RuleDoubleSpend, err)
+	}
+
+	if err, _ := blockchain.AppendBlock(); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	if got := len(blockchain.GetLastBlock().Transactions); got != 2 {
+		t.Errorf("mined block has %d transactions, want 2 (coinbase and tx1)", got)
+	}
+	if blockchain.Mempool.Len() != 0 {
+		t.Errorf("Mempool.Len() = %d, want 0", blockchain.Mempool.Len())
+	}
+}
+
+// Run with -race: the readers must not see the chain and the UTXO set change under them.
+func TestBlockchain_ConcurrentMiningAndQueries(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	_, minerAddress := newTestKey(t)
+
+	done := make(chan error)
+	go func() {
+		for range 5 {
+			if err, _ := bc.AppendBlockFor(minerAddress); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Failed to mine block: %v", err)
+			}
+			if n := len(bc.GetChain()); n != 6 {
+				t.Errorf("the chain has %d blocks, want 6", n)
+			}
+			return
+		default:
+		}
+
+		chain := bc.GetChain()
+		if tip := bc.GetLastBlock(); tip.Index+1 < uint64(len(chain)) {
+			t.Fatalf("the tip #%d is behind the chain of %d blocks", tip.Index, len(chain))
+		}
+		bc.GetUTXPoolByAddress(minerAddress)
+		bc.SpendableOutputs(minerAddress)
+		bc.ValidateChain(chain)
+	}
+}
@@ -0,0 +1,348 @@
+package blockchain
+
+import (
+	"errors"
+	"time"
+)
+
+// Where a block received by ProcessBlock ended up.
+type BlockStatus string
+
+const (
+	BlockActive    BlockStatus = "active"     // On the active chain
+	BlockSideChain BlockStatus = "side-chain" // On a valid branch with less work than the active chain
+	BlockOrphan    BlockStatus = "orphan"     // Waiting for its parent to arrive
+)
+
+// How many blocks with an unknown parent are kept, the oldest ones are dropped first.
+const maxOrphans = 100
+
+var (
+	ErrDuplicateBlock = errors.New("the block is already known")
+	ErrInvalidBlock   = errors.New("the block or one of its ancestors is invalid")
+)
+
+type blockNode struct {
+	block    Block
+	parent   *blockNode
+	children []*blockNode
+}
+
+// BlockTree holds every block we know of, keyed by hash, whatever branch it is on,
+// plus the blocks whose parent we haven't seen yet.
+type BlockTree struct {
+	nodes   map[string]*blockNode
+	invalid map[string]bool // Blocks that broke a consensus rule, and their descendants
+
+	orphans         map[string]Block
+	orphanOrder     []string            // Arrival order, oldest first
+	orphansByParent map[string][]string // Parent hash -> hashes of the orphans waiting for it
+}
+
+func newBlockTree(chain []Block) *BlockTree {
+	t := &BlockTree{
+		nodes:           make(map[string]*blockNode),
+		invalid:         make(map[string]bool),
+		orphans:         make(map[string]Block),
+		orphansByParent: make(map[string][]string),
+	}
+	for _, block := range chain {
+		t.add(block)
+	}
+	return t
+}
+
+// add puts a block whose parent is in the tree, or the genesis block, in the tree.
+func (t *BlockTree) add(block Block) *blockNode {
+	if node, ok := t.nodes[block.Hash]; ok {
+		return node
+	}
+
+	node := &blockNode{block: block}
+	if parent, ok := t.nodes[block.PrevHash]; ok && block.Index > 0 {
+		node.parent = parent
+		parent.children = append(parent.children, node)
+	}
+	t.nodes[block.Hash] = node
+	return node
+}
+
+func (t *BlockTree) get(hash string) (*blockNode, bool) {
+	node, ok := t.nodes[hash]
+	return node, ok
+}
+
+func (t *BlockTree) has(hash string) bool {
+	_, inTree := t.nodes[hash]
+	_, isOrphan := t.orphans[hash]
+	return inTree || isOrphan
+}
+
+// branch returns the blocks from the genesis block up to node.
+func (t *BlockTree) branch(node *blockNode) []Block {
+	chain := make([]Block, node.block.Index+1)
+	for n := node; n != nil; n = n.parent {
+		chain[n.block.Index] = n.block
+	}
+	return chain
+}
+
+// tips returns the blocks no other block builds on.
+func (t *BlockTree) tips() []*blockNode {
+	tips := make([]*blockNode, 0)
+	for _, node := range t.nodes {
+		if len(node.children) == 0 {
+			tips = append(tips, node)
+		}
+	}
+	return tips
+}
+
+// invalidate removes the block, everything built on it and the orphans waiting for them,
+// and remembers not to accept them again.
+func (t *BlockTree) invalidate(hash string) {
+	if node, ok := t.nodes[hash]; ok {
+		node.detach()
+	}
+
+	stack := []string{hash}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		t.invalid[h] = true
+		if node, ok := t.nodes[h]; ok {
+			delete(t.nodes, h)
+			for _, child := range node.children {
+				stack = append(stack, child.block.Hash)
+			}
+		}
+		for _, orphan := range t.takeOrphans(h) {
+			stack = append(stack, orphan.Hash)
+		}
+	}
+}
+
+// drop removes a block whose copy turned out to be corrupted without remembering its hash, so an
+// intact copy is still accepted. The blocks built on it go back to the orphan pool to wait for it.
+func (t *BlockTree) drop(hash string) {
+	node, ok := t.nodes[hash]
+	if !ok {
+		return
+	}
+	node.detach()
+	delete(t.nodes, hash)
+
+	stack := node.children
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		delete(t.nodes, n.block.Hash)
+		t.addOrphan(n.block)
+		stack = append(stack, n.children...)
+	}
+}
+
+// detach removes the node from the children of its parent.
+func (n *blockNode) detach() {
+	if n.parent == nil {
+		return
+	}
+	siblings := n.parent.children
+	for i, child := range siblings {
+		if child == n {
+			n.parent.children = append(siblings[:i:i], siblings[i+1:]...)
+			break
+		}
+	}
+}
+
+func (t *BlockTree) addOrphan(block Block) {
+	t.orphans[block.Hash] = block
+	t.orphanOrder = append(t.orphanOrder, block.Hash)
+	t.orphansByParent[block.PrevHash] = append(t.orphansByParent[block.PrevHash], block.Hash)
+
+	for len(t.orphans) > maxOrphans {
+		oldest := t.orphanOrder[0]
+		t.orphanOrder = t.orphanOrder[1:]
+		t.removeOrphan(oldest)
+	}
+}
+
+func (t *BlockTree) removeOrphan(hash string) {
+	orphan, ok := t.orphans[hash]
+	if !ok {
+		return
+	}
+	delete(t.orphans, hash)
+
+	waiting := t.orphansByParent[orphan.PrevHash]
+	for i, h := range waiting {
+		if h == hash {
+			waiting = append(waiting[:i:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(waiting) == 0 {
+		delete(t.orphansByParent, orphan.PrevHash)
+	} else {
+		t.orphansByParent[orphan.PrevHash] = waiting
+	}
+}
+
+// takeOrphans removes the orphans waiting for parentHash from the pool and returns them.
+func (t *BlockTree) takeOrphans(parentHash string) []Block {
+	hashes := t.orphansByParent[parentHash]
+	blocks := make([]Block, 0, len(hashes))
+	for _, hash := range hashes {
+		blocks = append(blocks, t.orphans[hash])
+		delete(t.orphans, hash)
+	}
+	delete(t.orphansByParent, parentHash)
+
+	kept := t.orphanOrder[:0]
+	for _, hash := range t.orphanOrder {
+		if _, ok := t.orphans[hash]; ok {
+			kept = append(kept, hash)
+		}
+	}
+	t.orphanOrder = kept
+	return blocks
+}
+
+// ProcessBlock accepts a single block from a peer. A block with an unknown parent waits in
+// the orphan pool, otherwise it goes in the block tree, and the node switches to its branch
+// if that branch now has the most work. Orphans waiting for the block are processed with it.
+func (b *Blockchain) ProcessBlock(block Block) (BlockStatus, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tree.has(block.Hash) {
+		return "", ErrDuplicateBlock
+	}
+	if b.tree.invalid[block.Hash] || b.tree.invalid[block.PrevHash] {
+		return "", ErrInvalidBlock
+	}
+	if err := checkBlockSanity(&block, b.Params); err != nil {
+		return "", err
+	}
+
+	parent, ok := b.tree.get(block.PrevHash)
+	if !ok {
+		b.tree.addOrphan(block)
+		return BlockOrphan, nil
+	}
+	if err := b.connectToTree(parent, block); err != nil {
+		return "", err
+	}
+
+	// Adopt the orphans this block was missing for, and the ones waiting for them.
+	queue := []string{block.Hash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		for _, orphan := range b.tree.takeOrphans(hash) {
+			parent, ok := b.tree.get(hash)
+			if !ok {
+				break // The parent turned out to be invalid
+			}
+			if err := b.connectToTree(parent, orphan); err != nil {
+				continue // Already out of the pool, the orphan is dropped
+			}
+			queue = append(queue, orphan.Hash)
+		}
+	}
+
+	if b.isActive(block.Hash) {
+		return BlockActive, nil
+	}
+	if _, ok := b.tree.get(block.Hash); !ok {
+		return "", ErrInvalidBlock
+	}
+	return BlockSideChain, nil
+}
+
+// connectToTree checks the header of block against its branch and adds it to the tree.
+// If its branch has more work than the active chain, the node reorganizes to it.
+// b.mu must be held.
+func (b *Blockchain) connectToTree(parent *blockNode, block Block) error {
+	branch := b.tree.branch(parent)
+	if err := b.validateHeader(branch, &block, time.Now()); err != nil {
+		// A block too far in the future may become valid later, and a corrupted copy says nothing
+		// about the block, they are only dropped.
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) && validationErr.Rule != RuleTimestamp && !validationErr.corruptible() {
+			b.tree.invalidate(block.Hash)
+		}
+		return err
+	}
+	b.tree.add(block)
+
+	if !isBetterTip(&block, b.lastBlock()) {
+		return nil
+	}
+
+	// The transactions are only checked now, against the UTXO set of the branch.
+	if err := b.reorganize(append(branch, block)); err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			if validationErr.corruptible() {
+				b.tree.drop(validationErr.BlockHash)
+			} else {
+				b.tree.invalidate(validationErr.BlockHash)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// isActive reports whether the block with this hash is on the active chain. b.mu must be held.
+func (b *Blockchain) isActive(hash string) bool {
+	node, ok := b.tree.get(hash)
+	if !ok || node.block.Index >= uint64(len(b.Chain)) {
+		return false
+	}
+	return b.Chain[node.block.Index].Hash == hash
+}
+
+// ChainTip is the last block of a branch of the block tree.
+type ChainTip struct {
+	Hash         string      `json:"hash"`
+	Height       uint64      `json:"height"`
+	ChainWork    string      `json:"chain_work"`
+	BranchLength int         `json:"branch_length"` // Blocks since the branch left the active chain
+	Status       BlockStatus `json:"status"`
+}
+
+// ChainTips returns the tip of the active chain and of every side chain we know of.
+func (b *Blockchain) ChainTips() []ChainTip {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	tips := make([]ChainTip, 0)
+	for _, node := range b.tree.tips() {
+		tip := ChainTip{
+			Hash:      node.block.Hash,
+			Height:    node.block.Index,
+			ChainWork: node.block.ChainWork,
+			Status:    BlockSideChain,
+		}
+		for n := node; n != nil && !b.isActive(n.block.Hash); n = n.parent {
+			tip.BranchLength++
+		}
+		if tip.BranchLength == 0 {
+			tip.Status = BlockActive
+		}
+		tips = append(tips, tip)
+	}
+	return tips
+}
+
+// OrphanCount returns how many blocks are waiting for their parent.
+func (b *Blockchain) OrphanCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.tree.orphans)
+}
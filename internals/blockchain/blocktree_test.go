@@ -0,0 +1,233 @@
+package blockchain
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// newTestPeers returns two nodes with the same genesis block and consensus params.
+func newTestPeers() (*Blockchain, *Blockchain) {
+	node := NewBlockchain("")
+	node.Params.InitialDifficulty = 8
+	peer := NewBlockchain("")
+	peer.Params = node.Params
+	return node, peer
+}
+
+func mineBlocks(t *testing.T, bc *Blockchain, n int, address string) {
+	t.Helper()
+	for range n {
+		if err, _ := bc.AppendBlockFor(address); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+	}
+}
+
+func TestProcessBlock_ConnectsOrphansOnceTheParentArrives(t *testing.T) {
+	node, peer := newTestPeers()
+	_, minerAddress := newTestKey(t)
+	mineBlocks(t, peer, 3, minerAddress)
+
+	for _, block := range []Block{peer.Chain[3], peer.Chain[2]} {
+		status, err := node.ProcessBlock(block)
+		if err != nil || status != BlockOrphan {
+			t.Fatalf("ProcessBlock(#%d) = %q, %v, want an orphan", block.Index, status, err)
+		}
+	}
+	if _, err := node.ProcessBlock(peer.Chain[3]); !errors.Is(err, ErrDuplicateBlock) {
+		t.Errorf("ProcessBlock() of a known orphan = %v, want ErrDuplicateBlock", err)
+	}
+
+	status, err := node.ProcessBlock(peer.Chain[1])
+	if err != nil || status != BlockActive {
+		t.Fatalf("ProcessBlock(#1) = %q, %v, want active", status, err)
+	}
+	if node.GetLastBlock().Hash != peer.GetLastBlock().Hash {
+		t.Errorf("the tip is #%d, want the orphans connected up to #3", node.GetLastBlock().Index)
+	}
+	if n := node.OrphanCount(); n != 0 {
+		t.Errorf("%d orphans left, want 0", n)
+	}
+}
+
+func TestProcessBlock_SwitchesToASideChainWithMoreWork(t *testing.T) {
+	node, peer := newTestPeers()
+	_, nodeAddress := newTestKey(t)
+	_, peerAddress := newTestKey(t)
+	mineBlocks(t, node, 2, nodeAddress)
+	mineBlocks(t, peer, 3, peerAddress)
+	ourTip := node.GetLastBlock().Hash
+
+	status, err := node.ProcessBlock(peer.Chain[1])
+	if err != nil || status != BlockSideChain {
+		t.Fatalf("ProcessBlock(#1) = %q, %v, want a side chain", status, err)
+	}
+	if node.GetLastBlock().Hash != ourTip {
+		t.Fatal("a side chain with less work should not become active")
+	}
+
+	for _, block := range peer.Chain[2:] {
+		if _, err := node.ProcessBlock(block); err != nil {
+			t.Fatalf("ProcessBlock(#%d) = %v", block.Index, err)
+		}
+	}
+	if node.GetLastBlock().Hash != peer.GetLastBlock().Hash {
+		t.Fatal("the node should follow the branch with the most work")
+	}
+	if err := node.CheckUTXOConsistency(); err != nil {
+		t.Errorf("UTXO set out of sync after the switch: %v", err)
+	}
+
+	tips := node.ChainTips()
+	if len(tips) != 2 {
+		t.Fatalf("ChainTips() = %+v, want the active tip and our old tip", tips)
+	}
+	for _, tip := range tips {
+		switch tip.Hash {
+		case peer.GetLastBlock().Hash:
+			if tip.Status != BlockActive || tip.BranchLength != 0 {
+				t.Errorf("active tip = %+v", tip)
+			}
+		case ourTip:
+			if tip.Status != BlockSideChain || tip.BranchLength != 2 {
+				t.Errorf("old tip = %+v, want a side chain of 2 blocks", tip)
+			}
+		default:
+			t.Errorf("unexpected tip %+v", tip)
+		}
+	}
+}
+
+func TestProcessBlock_RejectsOrphansBelowTheDifficultyFloor(t *testing.T) {
+	node, peer := newTestPeers()
+	_, minerAddress := newTestKey(t)
+	mineBlocks(t, peer, 3, minerAddress)
+
+	// No retarget happened by block #3, it can't be easier to mine than the first blocks.
+	cheap := peer.Chain[3]
+	cheap.Difficulty = 1
+	peer.mine(&cheap)
+
+	var validationErr *ValidationError
+	if _, err := node.ProcessBlock(cheap); !errors.As(err, &validationErr) || validationErr.Rule != RuleDifficulty {
+		t.Fatalf("ProcessBlock() of a cheap orphan = %v, want the difficulty rule broken", err)
+	}
+	if n := node.OrphanCount(); n != 0 {
+		t.Errorf("%d orphans kept, want 0", n)
+	}
+}
+
+func TestProcessBlock_RejectsInvalidBlocksAndTheirChildren(t *testing.T) {
+	node, peer := newTestPeers()
+	_, minerAddress := newTestKey(t)
+	mineBlocks(t, peer, 2, minerAddress)
+
+	// Claim more than the block reward, the header stays valid.
+	bad := peer.Chain[1]
+	coinbase, err := NewCoinbaseTransaction(1, minerAddress, bad.Transactions[0].TxOuts[0].Amount+Coin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.Transactions = []Transaction{*coinbase}
+	bad.MerkleRoot = blockMerkleRoot(bad.Transactions)
+	peer.mine(&bad)
+
+	child := peer.Chain[2]
+	child.PrevHash = bad.Hash
+	peer.mine(&child)
+
+	if _, err := node.ProcessBlock(child); err != nil {
+		t.Fatalf("ProcessBlock(child) = %v, want it kept as an orphan", err)
+	}
+	var validationErr *ValidationError
+	if _, err := node.ProcessBlock(bad); !errors.As(err, &validationErr) {
+		t.Fatalf("ProcessBlock(bad) = %v, want a validation error", err)
+	}
+	if node.GetLastBlock().Index != 0 {
+		t.Errorf("the tip moved to #%d", node.GetLastBlock().Index)
+	}
+	if _, err := node.ProcessBlock(bad); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("ProcessBlock(bad) again = %v, want ErrInvalidBlock", err)
+	}
+	if n := node.OrphanCount(); n != 0 {
+		t.Errorf("%d orphans left, want the child of the invalid block dropped", n)
+	}
+}
+
+func TestProcessBlock_AcceptsTheIntactCopyOfATamperedBlock(t *testing.T) {
+	node, peer := newTestPeers()
+	priv, minerAddress := newTestKey(t)
+	_, otherAddress := newTestKey(t)
+	mineBlocks(t, peer, 1, minerAddress)
+
+	input, err := peer.BuildPayment(Payment{From: minerAddress, To: otherAddress, Amount: Coin, Fee: testFee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A signature of the same key over another payment.
+	other := input
+	other.TxOuts = []TxOut{{Address: otherAddress, Amount: 2 * Coin}}
+	forged := mustSign(t, peer, other, priv)
+	if err := peer.AppendTransaction(mustSign(t, peer, input, priv)); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, peer, 1, minerAddress)
+	if _, err := node.ProcessBlock(peer.Chain[1]); err != nil {
+		t.Fatalf("ProcessBlock(#1) = %v", err)
+	}
+
+	// The unlocking scripts are not covered by the block hash, a peer can swap one without redoing the work.
+	tampered := peer.Chain[2]
+	tampered.Transactions = slices.Clone(tampered.Transactions)
+	tx := &tampered.Transactions[1]
+	tx.TxIns = slices.Clone(tx.TxIns)
+	tx.TxIns[0].Script = forged.TxIns[0].Script
+
+	var validationErr *ValidationError
+	if _, err := node.ProcessBlock(tampered); !errors.As(err, &validationErr) || validationErr.Rule != RuleSignature {
+		t.Fatalf("ProcessBlock(tampered) = %v, want the signature rule broken", err)
+	}
+	status, err := node.ProcessBlock(peer.Chain[2])
+	if err != nil || status != BlockActive {
+		t.Fatalf("ProcessBlock(intact) = %q, %v, want active", status, err)
+	}
+	if node.GetLastBlock().Hash != peer.GetLastBlock().Hash {
+		t.Errorf("the tip is #%d, want the intact block", node.GetLastBlock().Index)
+	}
+}
+
+func TestProcessBlock_IgnoresTheSystemFlagOfATamperedBlock(t *testing.T) {
+	node, peer := newTestPeers()
+	priv, minerAddress := newTestKey(t)
+	_, otherAddress := newTestKey(t)
+	mineBlocks(t, peer, 1, minerAddress)
+
+	input, err := peer.BuildPayment(Payment{From: minerAddress, To: otherAddress, Amount: Coin, Fee: testFee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.AppendTransaction(mustSign(t, peer, input, priv)); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, peer, 1, minerAddress)
+	if _, err := node.ProcessBlock(peer.Chain[1]); err != nil {
+		t.Fatalf("ProcessBlock(#1) = %v", err)
+	}
+
+	// The block hash does not cover the system flag, a peer can flip it without redoing the work.
+	tampered := peer.Chain[2]
+	tampered.Transactions = slices.Clone(tampered.Transactions)
+	tampered.Transactions[0].IsSystem = false
+	tampered.Transactions[1].IsSystem = true
+
+	if _, err := node.ProcessBlock(tampered); err != nil {
+		t.Fatalf("ProcessBlock(tampered) = %v, want the flag ignored", err)
+	}
+	if _, err := node.ProcessBlock(peer.Chain[2]); !errors.Is(err, ErrDuplicateBlock) {
+		t.Fatalf("ProcessBlock(intact) = %v, want ErrDuplicateBlock", err)
+	}
+	if node.GetLastBlock().Hash != peer.GetLastBlock().Hash {
+		t.Errorf("the tip is #%d, want block #2", node.GetLastBlock().Index)
+	}
+}
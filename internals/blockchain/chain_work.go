@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"math/big"
+)
+
+// blockWork is the expected number of hashes needed to mine a block at difficulty.
+// Every leading zero bit doubles it.
+func blockWork(difficulty uint32) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+// accumulateWork returns the chain work of a block mined at difficulty on top of parent.
+func accumulateWork(parent *Block, difficulty uint32) string {
+	work := new(big.Int).Add(parent.Work(), blockWork(difficulty))
+	return work.Text(16)
+}
+
+// Work returns the total work of the chain up to and including this block.
+// A missing or malformed value counts as no work at all.
+func (b *Block) Work() *big.Int {
+	work, ok := new(big.Int).SetString(b.ChainWork, 16)
+	if !ok {
+		return new(big.Int)
+	}
+	return work
+}
+
+// isBetterTip reports whether the chain ending at candidate should be preferred over the
+// chain ending at current. The chain with more work wins, on a tie the lower tip hash wins
+// so every node makes the same choice.
+func isBetterTip(candidate, current *Block) bool {
+	switch candidate.Work().Cmp(current.Work()) {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+	return candidate.Hash < current.Hash
+}
@@ -0,0 +1,71 @@
+package blockchain
+
+import "testing"
+
+func TestSelectBestChain_PrefersMoreWork(t *testing.T) {
+	// A long chain of easy blocks.
+	easy := NewBlockchain("")
+	easy.Params.InitialDifficulty = 2
+	for range 6 {
+		if err, _ := easy.AppendBlock(); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+	}
+
+	// A shorter chain of harder blocks.
+	hard := NewBlockchain("")
+	hard.Params.InitialDifficulty = 6
+	for range 2 {
+		if err, _ := hard.AppendBlock(); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+	}
+
+	if easy.GetLastBlock().Work().Cmp(hard.GetLastBlock().Work()) >= 0 {
+		t.Fatalf("easy chain work %s should be below hard chain work %s",
+			easy.GetLastBlock().ChainWork, hard.GetLastBlock().ChainWork)
+	}
+
+	// Every node shares the same params, the one validating here accepts both difficulties.
+	node := NewBlockchain("")
+	node.Params.InitialDifficulty = 6
+	best, utxos := node.selectBestChain([][]Block{hard.Chain})
+	if best == nil || utxos == nil {
+		t.Fatal("The hard chain should replace the genesis only chain")
+	}
+
+	node.Chain = best
+	node.utxos = utxos
+	if best, _ := node.selectBestChain([][]Block{easy.Chain}); best != nil {
+		t.Error("A longer chain with less work should not be selected")
+	}
+}
+
+func TestSelectBestChain_RejectsForgedWork(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 4
+	if err, _ := bc.AppendBlock(); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+
+	forged := cloneChain(bc.Chain)
+	forged[1].ChainWork = "ffffffff"
+
+	node := NewBlockchain("")
+	node.Params.InitialDifficulty = 4
+	if best, _ := node.selectBestChain([][]Block{forged}); best != nil {
+		t.Error("A chain claiming work it did not do should be rejected")
+	}
+}
+
+func TestIsBetterTip_BreaksTiesByHash(t *testing.T) {
+	a := &Block{Hash: "00aa", ChainWork: "10"}
+	b := &Block{Hash: "00bb", ChainWork: "10"}
+
+	if !isBetterTip(a, b) || isBetterTip(b, a) {
+		t.Error("On equal work the lower hash should win")
+	}
+	if isBetterTip(a, a) {
+		t.Error("A tip is not better than itself")
+	}
+}
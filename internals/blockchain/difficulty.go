@@ -0,0 +1,99 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"math/bits"
+)
+
+const (
+	// A hash can't have more leading zero bits than it has bits.
+	maxDifficulty = 255
+	// A single retarget can make mining at most 4 times harder or easier.
+	maxRetargetStep = 2
+)
+
+// hasProofOfWork checks if the hash starts with at least difficulty zero bits.
+func hasProofOfWork(hash string, difficulty uint32) bool {
+	return leadingZeroBits(hash) >= int(difficulty)
+}
+
+func leadingZeroBits(hash string) int {
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, b := range raw {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// NextDifficulty returns the difficulty the next block of the chain has to be mined with.
+func (b *Blockchain) NextDifficulty() uint32 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.Params.nextDifficulty(b.Chain)
+}
+
+// difficultyFloor is the lowest difficulty a block at height can have on any branch: the initial
+// difficulty lowered as much as every retarget up to height allows, never below MinDifficulty.
+func (p ConsensusParams) difficultyFloor(height uint64) uint32 {
+	if height == 0 {
+		return 0
+	}
+	retargets := uint64(0)
+	if p.RetargetInterval > 0 && p.TargetBlockTime > 0 {
+		retargets = height / p.RetargetInterval
+	}
+
+	floor := int64(p.InitialDifficulty)
+	if retargets > 0 {
+		floor = max(floor-int64(min(retargets, maxDifficulty))*maxRetargetStep, int64(p.MinDifficulty))
+	}
+	return uint32(min(floor, int64(p.InitialDifficulty)))
+}
+
+// nextDifficulty computes the difficulty of the block that goes on top of chain.
+// Every RetargetInterval blocks the difficulty moves toward the one that would have produced
+// a block each TargetBlockTime seconds over the last interval, otherwise it stays the same.
+func (p ConsensusParams) nextDifficulty(chain []Block) uint32 {
+	parent := &chain[len(chain)-1]
+	if parent.Index == 0 {
+		return p.InitialDifficulty
+	}
+
+	height := parent.Index + 1
+	if p.RetargetInterval == 0 || p.TargetBlockTime <= 0 || height%p.RetargetInterval != 0 {
+		return parent.Difficulty
+	}
+
+	// The genesis block has no meaningful timestamp, so the first interval starts at block #1.
+	first := &chain[max(int(height)-int(p.RetargetInterval), 1)]
+	spacings := int64(parent.Index - first.Index)
+	if spacings <= 0 {
+		return parent.Difficulty
+	}
+
+	expected := spacings * p.TargetBlockTime
+	actual := max(parent.Timestamp-first.Timestamp, 1)
+
+	// Each extra zero bit doubles the work, so only move when blocks were at least
+	// twice too fast or too slow.
+	delta := 0
+	for delta < maxRetargetStep && actual*2 <= expected {
+		actual *= 2
+		delta++
+	}
+	for delta > -maxRetargetStep && actual >= expected*2 {
+		expected *= 2
+		delta--
+	}
+
+	next := int(parent.Difficulty) + delta
+	return uint32(min(max(next, int(p.MinDifficulty)), maxDifficulty))
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import "testing"
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		hash string
+		want int
+	}{
+		{"ff", 0},
+		{"0f", 4},
+		{"01", 7},
+		{"0001ab", 15},
+		{"0000", 16},
+		{"not hex", 0},
+	}
+	for _, tt := range tests {
+		if got := leadingZeroBits(tt.hash); got != tt.want {
+			t.Errorf("leadingZeroBits(%q) = %d, want %d", tt.hash, got, tt.want)
+		}
+	}
+}
+
+// spacedChain builds a chain whose blocks, after the genesis, are spacing seconds apart.
+func spacedChain(length int, difficulty uint32, spacing int64) []Block {
+	chain := make([]Block, length)
+	for i := 1; i < length; i++ {
+		chain[i].Index = uint64(i)
+		chain[i].Difficulty = difficulty
+		chain[i].Timestamp = 1_000 + int64(i)*spacing
+	}
+	return chain
+}
+
+func TestNextDifficulty_Retargets(t *testing.T) {
+	params := ConsensusParams{
+		InitialDifficulty: 16,
+		MinDifficulty:     4,
+		RetargetInterval:  10,
+		TargetBlockTime:   30,
+	}
+
+	tests := []struct {
+		name    string
+		length  int
+		spacing int64
+		want    uint32
+	}{
+		{"first block uses the initial difficulty", 1, 30, 16},
+		{"no retarget between intervals", 5, 1, 16},
+		{"on target keeps the difficulty", 10, 30, 16},
+		{"twice too fast adds a bit", 10, 15, 17},
+		{"much too fast is clamped", 10, 1, 18},
+		{"twice too slow removes a bit", 10, 60, 15},
+		{"much too slow is clamped", 10, 3_000, 14},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := spacedChain(tt.length, 16, tt.spacing)
+			if got := params.nextDifficulty(chain); got != tt.want {
+				t.Errorf("nextDifficulty() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	chain := spacedChain(10, 5, 3_000)
+	if got := params.nextDifficulty(chain); got != params.MinDifficulty {
+		t.Errorf("nextDifficulty() = %d, want the minimum %d", got, params.MinDifficulty)
+	}
+}
+
+func TestValidateChain_RejectsWrongDifficulty(t *testing.T) {
+	bc := validTestChain(t)
+	chain := cloneChain(bc.Chain)
+	last := &chain[len(chain)-1]
+	last.Difficulty--
+	bc.mine(last)
+
+	expectRule(t, bc.ValidateChain(chain), last.Index, RuleDifficulty)
+}
+
+func TestDifficultyFloor(t *testing.T) {
+	params := ConsensusParams{
+		InitialDifficulty: 16,
+		MinDifficulty:     4,
+		RetargetInterval:  10,
+		TargetBlockTime:   30,
+	}
+
+	tests := []struct {
+		height uint64
+		want   uint32
+	}{
+		{1, 16},
+		{9, 16},
+		{10, 14},
+		{25, 12},
+		{1_000, 4},
+	}
+	for _, tt := range tests {
+		if got := params.difficultyFloor(tt.height); got != tt.want {
+			t.Errorf("difficultyFloor(%d) = %d, want %d", tt.height, got, tt.want)
+		}
+	}
+
+	params.RetargetInterval = 0
+	if got := params.difficultyFloor(1_000); got != params.InitialDifficulty {
+		t.Errorf("difficultyFloor() without retargets = %d, want the initial difficulty", got)
+	}
+}
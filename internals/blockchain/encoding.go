@@ -0,0 +1,135 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// The canonical encoding is what block hashes and transaction ids are computed over.
+// It is simple enough to be rewritten in any language, see testdata/canonical_vectors.json.
+//
+// Every integer is big endian with a fixed width, every string is its length as a u32
+// followed by its UTF-8 bytes, bytes are their length as a u32 followed by the raw bytes,
+// and every list is its length as a u32 followed by its items.
+//
+// Block header (version 1):
+//
+//	u32 version | u64 index | i64 timestamp | str merkle_root | str prev_hash | u64 nonce | u32 difficulty
+//
+// Transaction body (version 2), signatures are left out since they sign the body:
+//
+//	u32 version | list of (str tx_out_id | i64 tx_out_index) | list of (str address | i64 amount)
+//
+// Transaction body (version 3), every input also has its sequence:
+//
+//	u32 version | list of (str tx_out_id | i64 tx_out_index | u32 sequence) | list of (str address | i64 amount)
+//
+// Transaction body (version 4), the lock time comes last:
+//
+//	u32 version | list of (str tx_out_id | i64 tx_out_index | u32 sequence) | list of (str address | i64 amount) | u32 lock_time
+//
+// Transaction body (version 5), every output also has its locking script, the unlocking
+// scripts are left out like the signatures:
+//
+//	u32 version | list of (str tx_out_id | i64 tx_out_index | u32 sequence) | list of (str address | i64 amount | bytes script) | u32 lock_time
+//
+// Signature hash, what the signature of an input signs. From version 5 on it ends with the
+// locking script of the spent output:
+//
+//	transaction body | u32 input index | str spent address | i64 spent amount [| bytes spent script]
+//
+// Amounts are in base units. The ids and hashes are the hex encoded SHA-256 of the encoding,
+// signatures are made over the raw SHA-256 of the signature hash encoding.
+
+// canonicalEncoder appends values to a buffer in the canonical encoding.
+type canonicalEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *canonicalEncoder) uint32(v uint32) {
+	e.buf.Write(binary.BigEndian.AppendUint32(nil, v))
+}
+
+func (e *canonicalEncoder) uint64(v uint64) {
+	e.buf.Write(binary.BigEndian.AppendUint64(nil, v))
+}
+
+func (e *canonicalEncoder) int64(v int64) {
+	e.uint64(uint64(v))
+}
+
+func (e *canonicalEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *canonicalEncoder) blob(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *canonicalEncoder) bytes() []byte {
+	return e.buf.Bytes()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeBlockHeader returns the canonical encoding of the header of block.
+func encodeBlockHeader(block *Block) []byte {
+	var e canonicalEncoder
+	e.uint32(block.Version)
+	e.uint64(block.Index)
+	e.int64(block.Timestamp)
+	e.string(block.MerkleRoot)
+	e.string(block.PrevHash)
+	e.uint64(block.Nonce)
+	e.uint32(block.Difficulty)
+	return e.bytes()
+}
+
+// encodeTransactionBody returns the canonical encoding of what the id of a transaction commits to.
+func encodeTransactionBody(tx *Transaction) []byte {
+	var e canonicalEncoder
+	e.uint32(tx.Version)
+
+	e.uint32(uint32(len(tx.TxIns)))
+	for _, txIn := range tx.TxIns {
+		e.string(txIn.TxOutId)
+		e.int64(txIn.TxOutIndex)
+		if tx.Version >= TxVersionSequence {
+			e.uint32(txIn.Sequence)
+		}
+	}
+
+	e.uint32(uint32(len(tx.TxOuts)))
+	for _, txOut := range tx.TxOuts {
+		e.string(txOut.Address)
+		e.int64(int64(txOut.Amount))
+		if tx.Version >= TxVersionScripts {
+			e.blob(txOut.Script)
+		}
+	}
+
+	if tx.Version >= TxVersionLockTime {
+		e.uint32(tx.LockTime)
+	}
+	return e.bytes()
+}
+
+// encodeSigHash returns the canonical encoding signed by the input at index, which spends spent.
+func encodeSigHash(tx *Transaction, index int, spent TxOut) []byte {
+	var e canonicalEncoder
+	e.buf.Write(encodeTransactionBody(tx))
+	e.uint32(uint32(index))
+	e.string(spent.Address)
+	e.int64(int64(spent.Amount))
+	if tx.Version >= TxVersionScripts {
+		e.blob(spent.Script)
+	}
+	return e.bytes()
+}
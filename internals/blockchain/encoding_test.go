@@ -0,0 +1,208 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateVectors = flag.Bool("update", false, "rewrite the canonical encoding test vectors")
+
+const vectorsFile = "testdata/canonical_vectors.json"
+
+// The vectors other implementations check themselves against, amounts are in base units.
+type canonicalVectors struct {
+	GenesisHash  string              `json:"genesis_hash"`
+	Headers      []headerVector      `json:"block_headers"`
+	Transactions []transactionVector `json:"transactions"`
+	MerkleRoots  []merkleRootVector  `json:"merkle_roots"`
+	SigHashes    []sigHashVector     `json:"sighashes"`
+}
+
+type headerVector struct {
+	Name       string `json:"name"`
+	Version    uint32 `json:"version"`
+	Index      uint64 `json:"index"`
+	Timestamp  int64  `json:"timestamp"`
+	MerkleRoot string `json:"merkle_root"`
+	PrevHash   string `json:"prev_hash"`
+	Nonce      uint64 `json:"nonce"`
+	Difficulty uint32 `json:"difficulty"`
+	Encoding   string `json:"encoding"`
+	Hash       string `json:"hash"`
+}
+
+type transactionVector struct {
+	Name     string        `json:"name"`
+	Version  uint32        `json:"version"`
+	TxIns    []txInVector  `json:"tx_ins"`
+	TxOuts   []txOutVector `json:"tx_outs"`
+	LockTime uint32        `json:"lock_time,omitempty"`
+	Encoding string        `json:"encoding"`
+	Id       string        `json:"id"`
+}
+
+type txOutVector struct {
+	Address string `json:"address"`
+	Amount  int64  `json:"amount"`
+	Script  Script `json:"script,omitempty"`
+}
+
+type txInVector struct {
+	TxOutId    string `json:"tx_out_id"`
+	TxOutIndex int64  `json:"tx_out_index"`
+	Sequence   uint32 `json:"sequence,omitempty"`
+}
+
+// The sighash of one input of a transaction from the transactions vectors.
+type sigHashVector struct {
+	Transaction  string `json:"transaction"`
+	Input        int    `json:"input"`
+	SpentAddress string `json:"spent_address"`
+	SpentAmount  int64  `json:"spent_amount"`
+	SpentScript  Script `json:"spent_script,omitempty"`
+	SigHash      string `json:"sighash"`
+}
+
+type merkleRootVector struct {
+	TxIds []string `json:"tx_ids"`
+	Root  string   `json:"root"`
+}
+
+func buildCanonicalVectors(t *testing.T) canonicalVectors {
+	vectors := canonicalVectors{GenesisHash: generateGenesis().Hash}
+
+	headers := []Block{
+		{Version: 1, MerkleRoot: emptyMerkleRoot},
+		{
+			BlockInsert: BlockInsert{Index: 42, PrevHash: "00ab" + emptyMerkleRoot[4:]},
+			Version:     1,
+			Timestamp:   1_700_000_000,
+			MerkleRoot:  MerkleRoot([]string{"a", "b", "c"}),
+			Nonce:       123_456_789,
+			Difficulty:  16,
+		},
+	}
+	for i, block := range headers {
+		vectors.Headers = append(vectors.Headers, headerVector{
+			Name:       []string{"empty", "typical"}[i],
+			Version:    block.Version,
+			Index:      block.Index,
+			Timestamp:  block.Timestamp,
+			MerkleRoot: block.MerkleRoot,
+			PrevHash:   block.PrevHash,
+			Nonce:      block.Nonce,
+			Difficulty: block.Difficulty,
+			Encoding:   hex.EncodeToString(encodeBlockHeader(&block)),
+			Hash:       hashBlock(&block),
+		})
+	}
+
+	payment := TransactionInput{
+		TxIns: []TxIn{{TxOutId: "funding-tx", TxOutIndex: 1, Signature: "not part of the id"}},
+		TxOuts: []TxOut{
+			{Address: "bob-address", Amount: 1250 * Coin / 100},
+			{Address: "ünïcode-address", Amount: 1},
+		},
+	}
+	replaceable := payment
+	replaceable.TxIns = []TxIn{{TxOutId: "funding-tx", TxOutIndex: 1, Sequence: SequenceReplaceable}}
+	timelocked := payment
+	timelocked.TxIns = []TxIn{{TxOutId: "funding-tx", TxOutIndex: 1, Sequence: 6}}
+	timelocked.LockTime = 144
+	scripted := timelocked
+	scripted.TxOuts = []TxOut{
+		{Amount: 1250 * Coin / 100, Script: PayToPubKeyHash(PubKeyHash([]byte("bob-public-key")))},
+		{Address: "legacy-address", Amount: 1},
+	}
+
+	txs := []struct {
+		name    string
+		version uint32
+		tx      TransactionInput
+	}{
+		{"coinbase", 2, TransactionInput{
+			TxIns:  []TxIn{{TxOutId: coinbaseTxOutId, TxOutIndex: 7}},
+			TxOuts: []TxOut{{Address: "miner-address", Amount: 50 * Coin}},
+		}},
+		{"payment", 2, payment},
+		{"payment-v3", 3, payment},
+		{"replaceable-payment-v3", 3, replaceable},
+		{"payment-v4", 4, payment},
+		{"timelocked-payment-v4", 4, timelocked},
+		{"payment-v5", 5, payment},
+		{"pay-to-public-key-hash-v5", 5, scripted},
+	}
+	for _, tt := range txs {
+		tx := &Transaction{Version: tt.version, TxIns: tt.tx.TxIns, TxOuts: tt.tx.TxOuts, LockTime: tt.tx.LockTime}
+		id, err := generateTransactionId(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Id = id
+		vector := transactionVector{
+			Name:     tt.name,
+			Version:  tx.Version,
+			LockTime: tx.LockTime,
+			Encoding: hex.EncodeToString(encodeTransactionBody(tx)),
+			Id:       tx.Id,
+		}
+		for _, txIn := range tx.TxIns {
+			vector.TxIns = append(vector.TxIns, txInVector{txIn.TxOutId, txIn.TxOutIndex, txIn.Sequence})
+		}
+		for _, txOut := range tx.TxOuts {
+			vector.TxOuts = append(vector.TxOuts, txOutVector{txOut.Address, int64(txOut.Amount), txOut.Script})
+		}
+		vectors.Transactions = append(vectors.Transactions, vector)
+
+		if tt.name != "coinbase" {
+			spent := TxOut{Address: "alice-address", Amount: 15 * Coin}
+			if tx.Version >= 5 {
+				spent = TxOut{Amount: 15 * Coin, Script: PayToPubKeyHash(PubKeyHash([]byte("alice-public-key")))}
+			}
+			vectors.SigHashes = append(vectors.SigHashes, sigHashVector{
+				Transaction:  tt.name,
+				Input:        0,
+				SpentAddress: spent.Address,
+				SpentAmount:  int64(spent.Amount),
+				SpentScript:  spent.Script,
+				SigHash:      hex.EncodeToString(SigHash(tx, 0, spent)),
+			})
+		}
+	}
+
+	for _, ids := range [][]string{{}, {"a"}, {"a", "b"}, {"a", "b", "c"}, {"a", "b", "c", "d", "e"}} {
+		vectors.MerkleRoots = append(vectors.MerkleRoots, merkleRootVector{TxIds: ids, Root: MerkleRoot(ids)})
+	}
+	return vectors
+}
+
+func TestCanonicalEncoding_Vectors(t *testing.T) {
+	got, err := json.MarshalIndent(buildCanonicalVectors(t), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	if *updateVectors {
+		if err := os.MkdirAll(filepath.Dir(vectorsFile), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(vectorsFile, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatalf("Failed to read the vectors, run the test with -update to create them: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("the canonical encoding no longer matches %s. Published vectors must not change, "+
+			"add a new version instead", vectorsFile)
+	}
+}
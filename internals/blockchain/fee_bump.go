@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrNotReplaceable = errors.New("the transaction did not opt in to replace-by-fee")
+
+// BumpFee replaces a pending transaction with the same payment paying newFee. The difference
+// comes out of the change going back to the sender, and privKey signs the replacement.
+// The replacement keeps the lock time and the relative locks of the original.
+func (b *Blockchain) BumpFee(txId string, newFee Amount, privKey *ecdsa.PrivateKey) (*Transaction, error) {
+	entry, ok := b.Mempool.Get(txId)
+	if !ok {
+		return nil, fmt.Errorf("transaction %s is not in the mempool", txId)
+	}
+	if !entry.Tx.SignalsReplacement() {
+		return nil, ErrNotReplaceable
+	}
+	if newFee <= entry.Fee {
+		return nil, fmt.Errorf("the new fee must be higher than the current %s", entry.Fee)
+	}
+
+	sender, err := b.sender(&entry.Tx)
+	if err != nil {
+		return nil, err
+	}
+	change := slices.IndexFunc(entry.Tx.TxOuts, func(txOut TxOut) bool {
+		return txOut.ownerKey() == sender
+	})
+	extra, err := SubAmounts(newFee, entry.Fee)
+	if err != nil {
+		return nil, err
+	}
+	if change < 0 || entry.Tx.TxOuts[change].Amount <= extra {
+		return nil, fmt.Errorf("the change of %s can't pay %s more", txId, extra)
+	}
+
+	// The sequences, relative locks included, are kept along with the inputs.
+	txIns := slices.Clone(entry.Tx.TxIns)
+	for i := range txIns {
+		txIns[i].Signature = ""
+	}
+	txOuts := slices.Clone(entry.Tx.TxOuts)
+	txOuts[change].Amount -= extra
+
+	replacement, err := b.SignTransaction(TransactionInput{TxIns: txIns, TxOuts: txOuts, LockTime: entry.Tx.LockTime}, privKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.AppendTransaction(replacement); err != nil {
+		return nil, err
+	}
+	return replacement, nil
+}
+
+// FeeBumpable returns the ids of the pending transactions of address BumpFee can replace.
+func (b *Blockchain) FeeBumpable(address string) map[string]bool {
+	bumpable := make(map[string]bool)
+	for _, entry := range b.Mempool.Entries() {
+		if !entry.Tx.SignalsReplacement() {
+			continue
+		}
+		if sender, err := b.sender(&entry.Tx); err == nil && sender == addressKey(address) {
+			bumpable[entry.Tx.Id] = true
+		}
+	}
+	return bumpable
+}
+
+// sender returns the owner, see ownerKey, of every output the transaction spends.
+func (b *Blockchain) sender(tx *Transaction) (string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	view := b.pendingView()
+	sender := ""
+	for _, txIn := range tx.TxIns {
+		utxo, ok := view.Get(OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex})
+		if !ok {
+			return "", fmt.Errorf("no unspent output %s_%d", txIn.TxOutId, txIn.TxOutIndex)
+		}
+		if sender != "" && utxo.Output.ownerKey() != sender {
+			return "", fmt.Errorf("transaction %s spends the outputs of more than one address", tx.Id)
+		}
+		sender = utxo.Output.ownerKey()
+	}
+	return sender, nil
+}
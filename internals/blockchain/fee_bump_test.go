@@ -0,0 +1,139 @@
+package blockchain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBumpFee_ReplacesTheTransactionAndItsChildren(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+	if err, _ := bc.AppendBlockFor(address); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	coinbase := bc.GetLastBlock().Transactions[0]
+	reward := coinbase.TxOuts[0].Amount
+
+	original, err := bc.SignTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: coinbase.Id, TxOutIndex: 0, Sequence: SequenceReplaceable}},
+		TxOuts: []TxOut{{Address: "bob-address", Amount: Coin}, {Address: address, Amount: reward - Coin - testFee}},
+	}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendTransaction(original); err != nil {
+		t.Fatalf("Failed to append the original: %v", err)
+	}
+	child, err := bc.SignTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: original.Id, TxOutIndex: 1}},
+		TxOuts: []TxOut{{Address: "carol-address", Amount: original.TxOuts[1].Amount - testFee}},
+	}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendTransaction(child); err != nil {
+		t.Fatalf("Failed to append the child: %v", err)
+	}
+
+	if bumpable := bc.FeeBumpable(address); !bumpable[original.Id] || bumpable[child.Id] {
+		t.Errorf("FeeBumpable() = %v, want only the original, the child did not opt in", bumpable)
+	}
+
+	// Paying more than the original alone isn't enough, the child is evicted too.
+	if _, err := bc.BumpFee(original.Id, 2*testFee, priv); !errors.Is(err, ErrReplacementRejected) {
+		t.Fatalf("BumpFee() below the fees of the original and its child = %v, want ErrReplacementRejected", err)
+	}
+
+	replacement, err := bc.BumpFee(original.Id, 3*testFee, priv)
+	if err != nil {
+		t.Fatalf("BumpFee() = %v", err)
+	}
+	entries := bc.Mempool.Entries()
+	if len(entries) != 1 || entries[0].Tx.Id != replacement.Id || entries[0].Fee != 3*testFee {
+		t.Fatalf("mempool holds %v, want only the replacement paying %s", entries, 3*testFee)
+	}
+	if !reflect.DeepEqual(replacement.TxOuts[0], original.TxOuts[0]) {
+		t.Errorf("the payment changed from %v to %v", original.TxOuts[0], replacement.TxOuts[0])
+	}
+
+	if _, err := bc.BumpFee(child.Id, 4*testFee, priv); err == nil {
+		t.Error("BumpFee() of an evicted transaction should fail")
+	}
+}
+
+func TestMempool_OnlyReplacesTransactionsThatOptedIn(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+	if err, _ := bc.AppendBlockFor(address); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	coinbase := bc.GetLastBlock().Transactions[0]
+
+	pay := func(to string, fee Amount, sequence uint32) *Transaction {
+		tx, err := bc.SignTransaction(TransactionInput{
+			TxIns:  []TxIn{{TxOutId: coinbase.Id, TxOutIndex: 0, Sequence: sequence}},
+			TxOuts: []TxOut{{Address: to, Amount: coinbase.TxOuts[0].Amount - fee}},
+		}, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	final := pay("bob-address", testFee, 0)
+	if err := bc.AppendTransaction(final); err != nil {
+		t.Fatalf("Failed to append tx: %v", err)
+	}
+	if err := bc.AppendTransaction(pay("carol-address", 10*testFee, 0)); err == nil || errors.Is(err, ErrReplacementRejected) {
+		t.Errorf("replacing a transaction that did not opt in = %v, want a conflict", err)
+	}
+	if _, err := bc.BumpFee(final.Id, 10*testFee, priv); !errors.Is(err, ErrNotReplaceable) {
+		t.Errorf("BumpFee() = %v, want ErrNotReplaceable", err)
+	}
+}
+
+func TestBumpFee_KeepsTheLocksOfTheOriginal(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+	if err, _ := bc.AppendBlockFor(address); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	coinbase := bc.GetLastBlock().Transactions[0]
+	reward := coinbase.TxOuts[0].Amount
+
+	lockTime := uint32(bc.GetLastBlock().Index + 5)
+	original, err := bc.SignTransaction(TransactionInput{
+		TxIns:    []TxIn{{TxOutId: coinbase.Id, TxOutIndex: 0, Sequence: SequenceReplaceable | 2}},
+		TxOuts:   []TxOut{{Address: "bob-address", Amount: Coin}, {Address: address, Amount: reward - Coin - testFee}},
+		LockTime: lockTime,
+	}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendTransaction(original); err != nil {
+		t.Fatalf("Failed to append the original: %v", err)
+	}
+
+	replacement, err := bc.BumpFee(original.Id, 2*testFee, priv)
+	if err != nil {
+		t.Fatalf("BumpFee() = %v", err)
+	}
+	if replacement.LockTime != lockTime {
+		t.Errorf("the replacement has lock time %d, want %d", replacement.LockTime, lockTime)
+	}
+	if got := replacement.TxIns[0].Sequence; got != original.TxIns[0].Sequence {
+		t.Errorf("the replacement has sequence %#x, want %#x", got, original.TxIns[0].Sequence)
+	}
+
+	// Bumping the fee doesn't let the payment be mined before its lock time.
+	if err, _ := bc.AppendBlockFor(address); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	if n := len(bc.GetLastBlock().Transactions); n != 1 {
+		t.Errorf("the next block holds %d transactions, want only the coinbase", n)
+	}
+}
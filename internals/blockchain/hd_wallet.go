@@ -0,0 +1,147 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+
+	"github.com/diegorezm/DBlockchain/internals/utils"
+)
+
+// The keys of an account of a hierarchical deterministic wallet are derived on two chains, as in
+// BIP44: the external one gives the addresses to receive payments, the internal one the
+// addresses change goes back to. A fresh address is used for each, so payments can't be linked.
+const (
+	ExternalChain uint32 = 0
+	InternalChain uint32 = 1
+)
+
+// HDAccountPath is the path of the account a wallet uses from its master key.
+const HDAccountPath = "m/0'"
+
+// DefaultGapLimit is how many unused addresses in a row end the scan of a chain. Wallets only
+// hand out the next unused address, so a used one should never be further away than that.
+const DefaultGapLimit = 20
+
+// MaxGapLimit bounds the number of addresses a scan derives on each chain.
+const MaxGapLimit = 1000
+
+// HDAddress is an address of an account that was paid.
+type HDAddress struct {
+	Chain   uint32 `json:"chain"`
+	Index   uint32 `json:"index"`
+	Address string `json:"address"`
+	UTXOs   []UTXO `json:"utxos"` // What it can spend right now, see SpendableOutputs
+}
+
+// HDWalletScan is what the chain and the mempool hold for an account.
+type HDWalletScan struct {
+	Addresses   []HDAddress `json:"addresses"`
+	NextReceive string      `json:"next_receive"` // The first address of the external chain after the used ones
+	NextChange  string      `json:"next_change"`  // The first address of the internal chain after the used ones
+}
+
+// UTXOs returns the outputs of every address of the account.
+func (s *HDWalletScan) UTXOs() []UTXO {
+	utxos := make([]UTXO, 0)
+	for _, address := range s.Addresses {
+		utxos = append(utxos, address.UTXOs...)
+	}
+	return utxos
+}
+
+// ScanHDWallet finds the addresses of account, an extended private or public key, the chain or
+// the mempool has paid. Each chain is scanned until gapLimit addresses in a row were never paid.
+// An address whose outputs were all spent is still used, so it is never handed out again.
+func (b *Blockchain) ScanHDWallet(account *utils.ExtendedKey, gapLimit int) (*HDWalletScan, error) {
+	if gapLimit < 1 || gapLimit > MaxGapLimit {
+		return nil, fmt.Errorf("the gap limit must be between 1 and %d", MaxGapLimit)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	scan := &HDWalletScan{Addresses: make([]HDAddress, 0)}
+	for _, chain := range []uint32{ExternalChain, InternalChain} {
+		chainKey, err := account.Child(chain)
+		if err != nil {
+			return nil, err
+		}
+
+		next := ""
+		for index, gap := uint32(0), 0; gap < gapLimit; index++ {
+			address, err := hdAddress(chainKey, index)
+			if err != nil {
+				return nil, err
+			}
+			if !b.utxos.WasPaid(address) && len(b.Mempool.UnconfirmedOutputs(address)) == 0 {
+				if gap == 0 {
+					next = address
+				}
+				gap++
+				continue
+			}
+			gap = 0
+			scan.Addresses = append(scan.Addresses, HDAddress{
+				Chain:   chain,
+				Index:   index,
+				Address: address,
+				UTXOs:   b.spendableOutputs(address),
+			})
+		}
+
+		if chain == ExternalChain {
+			scan.NextReceive = next
+		} else {
+			scan.NextChange = next
+		}
+	}
+	return scan, nil
+}
+
+// hdAddress returns the address of the key at index of a chain of an account.
+func hdAddress(chainKey *utils.ExtendedKey, index uint32) (string, error) {
+	key, err := chainKey.Child(index)
+	if err != nil {
+		return "", err
+	}
+	pubKey, err := key.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	return NewAddress(pubKey)
+}
+
+// SignHDPayment pays p from the outputs of every address of account, an extended private key,
+// and sends the change to a fresh address of its internal chain. p.From is not used.
+func (b *Blockchain) SignHDPayment(account *utils.ExtendedKey, p Payment) (*Transaction, error) {
+	if !account.IsPrivate() {
+		return nil, errors.New("signing needs the extended private key of the account")
+	}
+	scan, err := b.ScanHDWallet(account, DefaultGapLimit)
+	if err != nil {
+		return nil, err
+	}
+	input, err := fundPayment(p, scan.UTXOs(), scan.NextChange)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[OutPoint]HDAddress)
+	for _, address := range scan.Addresses {
+		for _, utxo := range address.UTXOs {
+			owners[OutPoint{TxId: utxo.TxId, Index: utxo.Index}] = address
+		}
+	}
+	keys := make([]*ecdsa.PrivateKey, len(input.TxIns))
+	for i, txIn := range input.TxIns {
+		owner := owners[OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex}]
+		key, err := account.Derive(fmt.Sprintf("%d/%d", owner.Chain, owner.Index))
+		if err != nil {
+			return nil, err
+		}
+		if keys[i], err = key.PrivateKey(); err != nil {
+			return nil, err
+		}
+	}
+	return b.SignTransaction(input, keys...)
+}
@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/diegorezm/DBlockchain/internals/utils"
+)
+
+func newTestAccount(t *testing.T) *utils.ExtendedKey {
+	t.Helper()
+	seed, err := utils.GenerateSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := utils.NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := master.Derive(HDAccountPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func testHDAddress(t *testing.T, account *utils.ExtendedKey, chain, index uint32) string {
+	t.Helper()
+	key, err := account.Derive(fmt.Sprintf("%d/%d", chain, index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _ := key.PublicKey()
+	address, err := NewAddress(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+func TestHDWallet_ScanStopsAtTheGapLimit(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	account := newTestAccount(t)
+	for _, index := range []uint32{0, 5, 5 + DefaultGapLimit + 1} {
+		mineBlocks(t, bc, 1, testHDAddress(t, account, ExternalChain, index))
+	}
+
+	// Watching the account only needs its extended public key.
+	watchOnly, err := utils.ParseExtendedKey(account.Neuter().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan, err := bc.ScanHDWallet(watchOnly, DefaultGapLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Addresses) != 2 || scan.Addresses[0].Index != 0 || scan.Addresses[1].Index != 5 {
+		t.Fatalf("the scan found %+v, want the addresses 0 and 5 of the external chain", scan.Addresses)
+	}
+	if want := testHDAddress(t, account, ExternalChain, 6); scan.NextReceive != want {
+		t.Errorf("NextReceive = %s, want the address 6 of the external chain", scan.NextReceive)
+	}
+	if want := testHDAddress(t, account, InternalChain, 0); scan.NextChange != want {
+		t.Errorf("NextChange = %s, want the first address of the internal chain", scan.NextChange)
+	}
+
+	wider, err := bc.ScanHDWallet(watchOnly, 2*DefaultGapLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wider.Addresses) != 3 {
+		t.Errorf("a scan with a wider gap limit found %d addresses, want 3", len(wider.Addresses))
+	}
+}
+
+func TestHDWallet_ChangeGoesToAFreshAddress(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	account := newTestAccount(t)
+	mineBlocks(t, bc, 1, testHDAddress(t, account, ExternalChain, 0))
+	mineBlocks(t, bc, 1, testHDAddress(t, account, ExternalChain, 1))
+	_, recipient := newTestKey(t)
+
+	if _, err := bc.SignHDPayment(account.Neuter(), Payment{To: recipient, Amount: Coin}); err == nil {
+		t.Error("SignHDPayment() with an extended public key should fail")
+	}
+
+	// More than a single block reward, so both addresses, each with its own key, pay.
+	reward := bc.GetUTXPoolByAddress(testHDAddress(t, account, ExternalChain, 0))[0].Output.Amount
+	tx, err := bc.SignHDPayment(account, Payment{To: recipient, Amount: reward + Coin, Fee: testFee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.TxIns) != 2 || len(tx.TxOuts) != 2 {
+		t.Fatalf("the payment has %d inputs and %d outputs, want 2 of each", len(tx.TxIns), len(tx.TxOuts))
+	}
+	if err := bc.AppendTransaction(tx); err != nil {
+		t.Fatalf("AppendTransaction() = %v", err)
+	}
+	change := testHDAddress(t, account, InternalChain, 0)
+	if !tx.TxOuts[1].PaysTo(change) {
+		t.Error("the change should go to the first address of the internal chain")
+	}
+
+	_, miner := newTestKey(t)
+	mineBlocks(t, bc, 1, miner)
+	scan, err := bc.ScanHDWallet(account, DefaultGapLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testHDAddress(t, account, InternalChain, 1); scan.NextChange != want {
+		t.Error("the next change should go to a fresh address once the first one is used")
+	}
+	if n := len(scan.UTXOs()); n != 1 {
+		t.Errorf("the account has %d outputs left, want the change only", n)
+	}
+	// The spent addresses are still used, they must not be handed out again.
+	if want := testHDAddress(t, account, ExternalChain, 2); scan.NextReceive != want {
+		t.Error("the next address to receive should come after the spent ones")
+	}
+	if n := len(scan.Addresses); n != 3 {
+		t.Errorf("the scan found %d addresses, want the 2 spent ones and the change", n)
+	}
+}
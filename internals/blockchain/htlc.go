@@ -0,0 +1,195 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// HTLC is a hash-time-locked contract. Its outputs can be claimed by the recipient with the
+// secret hashing to Hash, or refunded to the sender once the lock time is reached. Locking
+// coins to the same hash on two chains makes a swap between them atomic: claiming one side
+// reveals the secret that claims the other.
+type HTLC struct {
+	Hash      []byte // SHA-256 of the secret
+	Recipient []byte // Public key hash of the recipient
+	Refund    []byte // Public key hash of the sender
+	LockTime  uint32 // Block height or timestamp the sender can get the coins back from, see LockTimeThreshold
+}
+
+// NewHTLCScript returns the locking script of the contract:
+//
+//	OP_IF
+//	    OP_SHA256 <hash> OP_EQUALVERIFY OP_DUP OP_SHA256 <recipient>
+//	OP_ELSE
+//	    <lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_SHA256 <refund>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+//
+// The recipient claims it with <signature> <public key> <secret> OP_1, the sender gets a
+// refund with <signature> <public key> OP_0.
+func NewHTLCScript(h HTLC) (Script, error) {
+	if len(h.Hash) != sha256.Size || len(h.Recipient) != sha256.Size || len(h.Refund) != sha256.Size {
+		return nil, errors.New("the hash and the public key hashes must be SHA-256 hashes")
+	}
+	if h.LockTime == 0 {
+		return nil, errors.New("the lock time can't be 0")
+	}
+
+	return Script{}.
+		addOp(OpIf, OpSHA256).addData(h.Hash).addOp(OpEqualVerify, OpDup, OpSHA256).addData(h.Recipient).
+		addOp(OpElse).addNumber(int64(h.LockTime)).addOp(OpCheckLockTimeVerify, OpDrop, OpDup, OpSHA256).addData(h.Refund).
+		addOp(OpEndIf, OpEqualVerify, OpCheckSig), nil
+}
+
+// HTLC returns the contract of a script made by NewHTLCScript.
+func (s Script) HTLC() (HTLC, bool) {
+	ops, err := s.parse()
+	if err != nil || len(ops) != 17 {
+		return HTLC{}, false
+	}
+	lockTime, err := scriptNumber(ops[8].data)
+	if code := ops[8].code; code >= Op1 && code <= Op16 {
+		lockTime, err = int64(code-Op1+1), nil
+	}
+	if err != nil {
+		return HTLC{}, false
+	}
+
+	h := HTLC{Hash: ops[2].data, Recipient: ops[6].data, Refund: ops[13].data, LockTime: uint32(lockTime)}
+	// The script must be exactly the one the contract gives.
+	if script, err := NewHTLCScript(h); err != nil || !bytes.Equal(script, s) {
+		return HTLC{}, false
+	}
+	return h, true
+}
+
+// HTLCAddress returns the script address of the outputs the key of the recipient address can
+// claim with the secret hashing to hash, or the key of the refund address can get back from
+// lockTime on, and the script of the contract, which claiming or refunding it reveals.
+func HTLCAddress(hash []byte, recipient, refund string, lockTime uint32) (string, Script, error) {
+	recipientHash, err := addressPubKeyHash(recipient)
+	if err != nil {
+		return "", nil, fmt.Errorf("recipient: %w", err)
+	}
+	refundHash, err := addressPubKeyHash(refund)
+	if err != nil {
+		return "", nil, fmt.Errorf("refund: %w", err)
+	}
+	script, err := NewHTLCScript(HTLC{Hash: hash, Recipient: recipientHash, Refund: refundHash, LockTime: lockTime})
+	if err != nil {
+		return "", nil, err
+	}
+	return ScriptAddress(script), script, nil
+}
+
+// ClaimHTLC spends every output locked to the contract with the script with the secret, paying
+// them to the recipient, privKey, minus the fee. The transaction is added to the mempool.
+func (b *Blockchain) ClaimHTLC(script Script, secret []byte, privKey *ecdsa.PrivateKey, fee Amount) (*Transaction, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("claiming a contract needs its secret")
+	}
+	return b.spendHTLC(script, secret, privKey, fee)
+}
+
+// RefundHTLC spends every output locked to the contract with the script back to the sender,
+// privKey, minus the fee. It fails with ErrNonFinal before the lock time of the contract:
+// held back in the mempool, the refund would keep the recipient from claiming meanwhile.
+func (b *Blockchain) RefundHTLC(script Script, privKey *ecdsa.PrivateKey, fee Amount) (*Transaction, error) {
+	return b.spendHTLC(script, nil, privKey, fee)
+}
+
+// spendHTLC claims the contract with the script with secret, or refunds it when secret is nil.
+// Both the outputs paying the script address and the ones locked by the script itself are spent.
+func (b *Blockchain) spendHTLC(script Script, secret []byte, privKey *ecdsa.PrivateKey, fee Amount) (*Transaction, error) {
+	h, ok := script.HTLC()
+	if !ok {
+		return nil, errors.New("the script is not a hash-time-locked contract")
+	}
+	address := ScriptAddress(script)
+	pubKey, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	claim := secret != nil
+	payee, lockTime := h.Recipient, uint32(0)
+	if !claim {
+		payee, lockTime = h.Refund, h.LockTime
+	}
+	if hash := sha256.Sum256(secret); claim && !bytes.Equal(hash[:], h.Hash) {
+		return nil, errors.New("the secret does not match the hash of the contract")
+	}
+	if !bytes.Equal(PubKeyHash(pubKey), payee) {
+		return nil, errors.New("the key can't spend the contract that way")
+	}
+
+	utxos := b.SpendableOutputs(address)
+	if len(utxos) == 0 {
+		return nil, fmt.Errorf("nothing is locked to %s", address)
+	}
+	txIns := make([]TxIn, len(utxos))
+	total := Amount(0)
+	for i, utxo := range utxos {
+		txIns[i] = TxIn{TxOutId: utxo.TxId, TxOutIndex: utxo.Index}
+		// Bounded by the supply, the sum can't overflow.
+		total += utxo.Output.Amount
+	}
+	if fee < 0 || total <= fee {
+		return nil, fmt.Errorf("the contract holds %s, it can't pay a fee of %s", total, fee)
+	}
+
+	tx, err := NewTransaction(TransactionInput{
+		TxIns:    txIns,
+		TxOuts:   []TxOut{{Amount: total - fee, Script: PayToPubKeyHash(payee)}},
+		LockTime: lockTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, utxo := range utxos {
+		signature, err := ecdsa.SignASN1(rand.Reader, privKey, SigHash(tx, i, utxo.Output))
+		if err != nil {
+			return nil, err
+		}
+		unlocking := Script{}.addData(signature).addData(pubKey)
+		if claim {
+			unlocking = unlocking.addData(secret).addOp(OpTrue)
+		} else {
+			unlocking = unlocking.addOp(OpFalse)
+		}
+		if _, ok := utxo.Output.Script.ScriptHash(); ok {
+			unlocking = unlocking.addData(script)
+		}
+		tx.TxIns[i].Script = unlocking
+	}
+
+	if err := b.ValidateTransaction(tx); err != nil {
+		return nil, err
+	}
+	if err := b.AppendTransaction(tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// HTLCSecret returns the secret hashing to hash that tx reveals by claiming a contract, which
+// claims the contracts locked to the same hash on other chains.
+func HTLCSecret(tx *Transaction, hash []byte) ([]byte, bool) {
+	for _, txIn := range tx.TxIns {
+		ops, err := txIn.Script.parse()
+		if err != nil {
+			continue
+		}
+		for _, op := range ops {
+			if sum := sha256.Sum256(op.data); op.data != nil && bytes.Equal(sum[:], hash) {
+				return op.data, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,173 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// lockHTLC pays amount from address, the key of priv, to the contract at contract and mines it.
+func lockHTLC(t *testing.T, bc *Blockchain, priv *ecdsa.PrivateKey, address, contract string, amount Amount) {
+	t.Helper()
+	input, err := bc.BuildPayment(Payment{From: address, To: contract, Amount: amount, Fee: testFee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := bc.SignTransaction(input, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, bc, 1, address)
+}
+
+func balance(bc *Blockchain, address string) Amount {
+	total := Amount(0)
+	for _, utxo := range bc.GetUTXPoolByAddress(address) {
+		total += utxo.Output.Amount
+	}
+	return total
+}
+
+func TestHTLC_AtomicSwapBetweenTwoChains(t *testing.T) {
+	chainA, chainB := NewBlockchain(""), NewBlockchain("")
+	chainA.Params.InitialDifficulty, chainB.Params.InitialDifficulty = 8, 8
+	alicePriv, alice := newTestKey(t)
+	bobPriv, bob := newTestKey(t)
+	mineBlocks(t, chainA, 1, alice)
+	mineBlocks(t, chainB, 1, bob)
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(secret)
+
+	// Alice, who knows the secret, locks first and for longer, so Bob always has the time to
+	// claim once she reveals it.
+	contractA, scriptA, err := HTLCAddress(hash[:], bob, alice, uint32(chainA.GetLastBlock().Index+20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockHTLC(t, chainA, alicePriv, alice, contractA, 2*Coin)
+
+	// Bob checks what is locked to him on chain A, then locks his side with the same hash.
+	if got := balance(chainA, contractA); got != 2*Coin {
+		t.Fatalf("the contract on chain A holds %s, want %s", got, 2*Coin)
+	}
+	contractB, scriptB, err := HTLCAddress(hash[:], alice, bob, uint32(chainB.GetLastBlock().Index+10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockHTLC(t, chainB, bobPriv, bob, contractB, 3*Coin)
+
+	if _, err := chainB.RefundHTLC(scriptB, bobPriv, testFee); !errors.Is(err, ErrNonFinal) {
+		t.Errorf("RefundHTLC() before the lock time = %v, want ErrNonFinal", err)
+	}
+	if _, err := chainA.ClaimHTLC(scriptA, []byte("guess"), bobPriv, testFee); err == nil {
+		t.Error("ClaimHTLC() with the wrong secret should fail")
+	}
+	if _, err := chainB.ClaimHTLC(scriptB, secret, bobPriv, testFee); err == nil {
+		t.Error("ClaimHTLC() by the sender should fail")
+	}
+
+	// Alice claims on chain B, which reveals the secret to Bob.
+	if _, err := chainB.ClaimHTLC(scriptB, secret, alicePriv, testFee); err != nil {
+		t.Fatalf("ClaimHTLC() on chain B = %v", err)
+	}
+	mineBlocks(t, chainB, 1, bob)
+	claim := chainB.GetLastBlock().Transactions[1]
+	revealed, ok := HTLCSecret(&claim, hash[:])
+	if !ok || !bytes.Equal(revealed, secret) {
+		t.Fatal("the claim on chain B should reveal the secret")
+	}
+
+	if _, err := chainA.ClaimHTLC(scriptA, revealed, bobPriv, testFee); err != nil {
+		t.Fatalf("ClaimHTLC() on chain A = %v", err)
+	}
+	mineBlocks(t, chainA, 1, alice)
+
+	if got := balance(chainA, contractA) + balance(chainB, contractB); got != 0 {
+		t.Errorf("the contracts still hold %s", got)
+	}
+	if got, want := balance(chainA, bob), 2*Coin-testFee; got != want {
+		t.Errorf("Bob got %s on chain A, want %s", got, want)
+	}
+	if got, want := balance(chainB, alice), 3*Coin-testFee; got != want {
+		t.Errorf("Alice got %s on chain B, want %s", got, want)
+	}
+	for _, bc := range []*Blockchain{chainA, chainB} {
+		if err := bc.CheckUTXOConsistency(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestHTLC_RefundAfterTheLockTime(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	senderPriv, sender := newTestKey(t)
+	_, recipient := newTestKey(t)
+	mineBlocks(t, bc, 1, sender)
+
+	hash := sha256.Sum256([]byte("secret"))
+	lockTime := uint32(bc.GetLastBlock().Index + 4)
+	contract, script, err := HTLCAddress(hash[:], recipient, sender, lockTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockHTLC(t, bc, senderPriv, sender, contract, Coin)
+
+	for uint32(bc.GetLastBlock().Index+1) < lockTime {
+		if _, err := bc.RefundHTLC(script, senderPriv, testFee); !errors.Is(err, ErrNonFinal) {
+			t.Fatalf("RefundHTLC() at block #%d = %v, want ErrNonFinal", bc.GetLastBlock().Index+1, err)
+		}
+		mineBlocks(t, bc, 1, recipient)
+	}
+	refund, err := bc.RefundHTLC(script, senderPriv, testFee)
+	if err != nil {
+		t.Fatalf("RefundHTLC() at the lock time = %v", err)
+	}
+	if refund.LockTime != lockTime {
+		t.Errorf("the refund has lock time %d, want %d", refund.LockTime, lockTime)
+	}
+	mineBlocks(t, bc, 1, recipient)
+	if got := balance(bc, contract); got != 0 {
+		t.Errorf("the contract still holds %s", got)
+	}
+}
+
+func TestScript_CheckLockTimeVerify(t *testing.T) {
+	locking := func(lockTime int64) Script {
+		return Script{}.addNumber(lockTime).addOp(OpCheckLockTimeVerify)
+	}
+	tests := []struct {
+		name     string
+		version  uint32
+		lockTime uint32
+		locking  Script
+		valid    bool
+	}{
+		{"height reached", CurrentTxVersion, 100, locking(100), true},
+		{"height not reached", CurrentTxVersion, 99, locking(100), false},
+		{"timestamp reached", CurrentTxVersion, 1_700_000_000, locking(1_600_000_000), true},
+		{"timestamp against a height", CurrentTxVersion, 1_700_000_000, locking(100), false},
+		{"no lock time", CurrentTxVersion, 0, locking(1), false},
+		{"version without lock time", 3, 0, locking(0), false},
+		{"empty stack", CurrentTxVersion, 100, Script{}.addOp(OpCheckLockTimeVerify), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &Transaction{Version: tt.version, LockTime: tt.lockTime, TxIns: []TxIn{{}}}
+			err := VerifyScript(tx, 0, TxOut{Amount: Coin, Script: tt.locking})
+			if (err == nil) != tt.valid {
+				t.Errorf("VerifyScript(%s) with lock time %d = %v, want valid %v", tt.locking, tt.lockTime, err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Lock times below this are block heights, the ones from it on are Unix timestamps.
+const LockTimeThreshold uint32 = 500_000_000
+
+// The low bits of the sequence of an input of a version 4 transaction are its relative lock:
+// how many blocks must be mined on top of the one holding the spent output before it can be spent.
+const SequenceLockMask uint32 = 0x0000ffff
+
+var ErrNonFinal = errors.New("the transaction is locked")
+
+// checkLocks checks the lock time and the relative locks of tx for a block at height, whose
+// previous blocks have a median time past of mtp. Timestamps are compared with the median time
+// past rather than the timestamp of the block, which miners pick.
+func checkLocks(tx *Transaction, view utxoView, height uint64, mtp int64) error {
+	if tx.Version < TxVersionLockTime {
+		return nil
+	}
+
+	switch {
+	case tx.LockTime == 0:
+	case tx.LockTime < LockTimeThreshold && uint64(tx.LockTime) > height:
+		return fmt.Errorf("%w until block #%d", ErrNonFinal, tx.LockTime)
+	case tx.LockTime >= LockTimeThreshold && int64(tx.LockTime) > mtp:
+		return fmt.Errorf("%w until %s", ErrNonFinal, time.Unix(int64(tx.LockTime), 0).UTC().Format(time.RFC3339))
+	}
+
+	for i, txIn := range tx.TxIns {
+		lock := uint64(txIn.Sequence & SequenceLockMask)
+		if lock == 0 {
+			continue
+		}
+		utxo, ok := view.Get(OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex})
+		if !ok {
+			return fmt.Errorf("invalid TxIn: no matching UTXO for %s_%d", txIn.TxOutId, txIn.TxOutIndex)
+		}
+		if height < utxo.Height+lock {
+			return fmt.Errorf("%w: input %d spends an output of block #%d, it can only be mined from block #%d",
+				ErrNonFinal, i, utxo.Height, utxo.Height+lock)
+		}
+	}
+	return nil
+}
+
+// checkFinal checks the locks of tx against the next block of the active chain. b.mu must be held.
+func (b *Blockchain) checkFinal(tx *Transaction) error {
+	return checkLocks(tx, b.pendingView(), b.lastBlock().Index+1, medianTimePast(b.Chain))
+}
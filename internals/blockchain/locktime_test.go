@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newLockedPayment spends the coinbase of the last block of bc with the given locks.
+func newLockedPayment(t *testing.T, bc *Blockchain, priv *ecdsa.PrivateKey, lockTime, sequence uint32) *Transaction {
+	t.Helper()
+	coinbase := bc.GetLastBlock().Transactions[0]
+	tx, err := bc.SignTransaction(TransactionInput{
+		TxIns:    []TxIn{{TxOutId: coinbase.Id, TxOutIndex: 0, Sequence: sequence}},
+		TxOuts:   []TxOut{{Address: "bob-address", Amount: coinbase.TxOuts[0].Amount - testFee}},
+		LockTime: lockTime,
+	}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func mined(bc *Blockchain, tx *Transaction) bool {
+	return slices.Contains(transactionIds(bc.GetLastBlock().Transactions), tx.Id)
+}
+
+func TestLocks_MempoolHoldsLockedTransactionsBack(t *testing.T) {
+	tests := []struct {
+		name     string
+		lockTime func(tip uint64) uint32
+		sequence uint32
+	}{
+		{"lock time height", func(tip uint64) uint32 { return uint32(tip + 3) }, 0},
+		{"relative lock", func(uint64) uint32 { return 0 }, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := NewBlockchain("")
+			bc.Params.InitialDifficulty = 8
+			priv, address := newTestKey(t)
+			mineBlocks(t, bc, 1, address)
+
+			// Both can be mined from block #4 on.
+			tx := newLockedPayment(t, bc, priv, tt.lockTime(bc.GetLastBlock().Index), tt.sequence)
+			if err := bc.ValidateTransaction(tx); !errors.Is(err, ErrNonFinal) {
+				t.Fatalf("ValidateTransaction() = %v, want ErrNonFinal", err)
+			}
+			if err := bc.AppendTransaction(tx); err != nil {
+				t.Fatalf("AppendTransaction() = %v, want the transaction held back", err)
+			}
+
+			for range 2 {
+				mineBlocks(t, bc, 1, address)
+				if mined(bc, tx) {
+					t.Fatalf("the locked transaction was mined in block #%d", bc.GetLastBlock().Index)
+				}
+			}
+			mineBlocks(t, bc, 1, address)
+			if !mined(bc, tx) {
+				t.Errorf("the transaction should be mined in block #%d once its lock expired", bc.GetLastBlock().Index)
+			}
+		})
+	}
+}
+
+func TestLocks_TimestampLockTimeUsesTheMedianTimePast(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+	mineBlocks(t, bc, 1, address)
+
+	mtp := medianTimePast(bc.Chain)
+	if err := bc.checkFinal(newLockedPayment(t, bc, priv, uint32(mtp+3600), 0)); !errors.Is(err, ErrNonFinal) {
+		t.Errorf("checkFinal() of a payment locked for an hour = %v, want ErrNonFinal", err)
+	}
+	if err := bc.checkFinal(newLockedPayment(t, bc, priv, uint32(mtp), 0)); err != nil {
+		t.Errorf("checkFinal() of a payment locked until the median time past = %v", err)
+	}
+}
+
+func TestLocks_BlockWithALockedTransactionIsInvalid(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+	mineBlocks(t, bc, 1, address)
+	locked := newLockedPayment(t, bc, priv, 0, 5)
+
+	template, err := bc.NewBlockTemplate(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := NewBlock(BlockInsert{
+		Index:        template.Height,
+		PrevHash:     template.PrevHash,
+		Transactions: append(template.Transactions, *locked),
+	})
+	block.MerkleRoot = blockMerkleRoot(block.Transactions)
+	block.Timestamp = time.Now().Unix()
+	block.Difficulty = template.Difficulty
+	block.ChainWork = accumulateWork(bc.GetLastBlock(), block.Difficulty)
+	block, _ = bc.mine(block)
+
+	err = bc.validateBlock(bc.Chain, block, bc.utxos, time.Now())
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Rule != RuleLockTime {
+		t.Fatalf("validateBlock() = %v, want the %s rule broken", err, RuleLockTime)
+	}
+}
@@ -0,0 +1,567 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MempoolEntry is a pending transaction together with what it pays to be mined.
+type MempoolEntry struct {
+	Tx      Transaction `json:"tx"`
+	Fee     Amount      `json:"fee"`
+	Size    int         `json:"size"`     // Serialized size of the transaction in bytes
+	AddedAt int64       `json:"added_at"` // Unix time the transaction entered the pool
+}
+
+// FeeRate is the fee paid per byte of the transaction.
+func (e *MempoolEntry) FeeRate() float64 {
+	return feeRate(e.Fee, e.Size)
+}
+
+// feeRate is in base units per byte. It is only used to order transactions, so a float is fine.
+func feeRate(fee Amount, size int) float64 {
+	if size <= 0 {
+		return 0
+	}
+	return float64(fee) / float64(size)
+}
+
+var (
+	ErrMempoolFull = errors.New("the mempool is full and the transaction pays less than the ones in it")
+	ErrFeeTooLow   = errors.New("the transaction pays less than the minimum relay fee")
+	ErrTxTooLarge  = errors.New("the transaction is larger than the mempool")
+)
+
+// MempoolPolicy bounds what a node keeps in its mempool. Unlike the consensus params,
+// every node is free to pick its own.
+type MempoolPolicy struct {
+	MaxCount    int           `json:"max_count"`     // Transactions kept at most
+	MaxBytes    int           `json:"max_bytes"`     // Total size of the transactions kept at most
+	TTL         time.Duration `json:"ttl"`           // Transactions not mined after this long are dropped
+	MinRelayFee Amount        `json:"min_relay_fee"` // Base units per byte a transaction must pay to get in
+}
+
+func DefaultMempoolPolicy() MempoolPolicy {
+	return MempoolPolicy{
+		MaxCount:    5_000,
+		MaxBytes:    5_000_000,
+		TTL:         72 * time.Hour,
+		MinRelayFee: 1,
+	}
+}
+
+// MempoolInfo sums up the state of the mempool.
+type MempoolInfo struct {
+	Count  int           `json:"count"`
+	Bytes  int           `json:"bytes"`
+	Fees   Amount        `json:"fees"`
+	Policy MempoolPolicy `json:"policy"`
+}
+
+// Mempool holds the transactions waiting to be mined.
+// Besides the transactions it keeps track of the outputs they spend, so two pending
+// transactions can never spend the same output.
+// When it is full, the transactions paying the lowest fee rate are evicted first.
+type Mempool struct {
+	entries []MempoolEntry
+	spends  map[OutPoint]string // outpoint -> id of the pending transaction spending it
+	bytes   int                 // Total size of the entries
+	policy  MempoolPolicy
+	now     func() time.Time
+	mu      sync.RWMutex
+}
+
+func NewMempool() *Mempool {
+	return NewMempoolWithPolicy(DefaultMempoolPolicy())
+}
+
+func NewMempoolWithPolicy(policy MempoolPolicy) *Mempool {
+	return &Mempool{
+		entries: make([]MempoolEntry, 0),
+		spends:  make(map[OutPoint]string),
+		policy:  policy,
+		now:     time.Now,
+	}
+}
+
+func (m *Mempool) Policy() MempoolPolicy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.policy
+}
+
+// SetPolicy changes the policy. Entries over the new limits are evicted right away.
+func (m *Mempool) SetPolicy(policy MempoolPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.policy = policy
+	m.expire()
+	for len(m.entries) > 0 && m.overLimits(0, 0) {
+		m.removeAt(m.find(m.evictionCandidate(nil, nil)))
+	}
+}
+
+// Add puts the transaction in the pool, unless it spends an output that another pending
+// transaction already spends and can't replace it, see replacementSet. The fee must be the one
+// computed while validating the transaction. If the pool is full, transactions paying a lower
+// fee rate are evicted to make room.
+func (m *Mempool) Add(tx Transaction, fee Amount) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+
+	if m.find(tx.Id) >= 0 {
+		return fmt.Errorf("transaction %s is already in the mempool", tx.Id)
+	}
+
+	entry := MempoolEntry{Tx: tx, Fee: fee, Size: tx.Size(), AddedAt: m.now().Unix()}
+	replaced, err := m.replacementSet(&entry)
+	if err != nil {
+		return err
+	}
+
+	minFee, err := MulAmount(m.policy.MinRelayFee, uint64(entry.Size))
+	if err != nil || fee < minFee {
+		return fmt.Errorf("%w: it pays %s for %d bytes, at least %s is needed", ErrFeeTooLow, fee, entry.Size, minFee)
+	}
+	if m.policy.MaxBytes > 0 && entry.Size > m.policy.MaxBytes {
+		return ErrTxTooLarge
+	}
+
+	// The replaced transactions make room first. Then only evict when the new transaction pays
+	// a better rate than everything it pushes out, and never one of its own parents.
+	parents := make(map[string]bool)
+	for _, txIn := range tx.TxIns {
+		parents[txIn.TxOutId] = true
+	}
+	gone := make(map[string]bool)
+	bytes := entry.Size
+	for _, id := range replaced {
+		gone[id] = true
+		bytes -= m.entries[m.find(id)].Size
+	}
+	for m.overLimits(1-len(gone), bytes) {
+		victim := m.evictionCandidate(gone, parents)
+		if victim == "" {
+			return ErrMempoolFull
+		}
+		i := m.find(victim)
+		if m.entries[i].FeeRate() >= entry.FeeRate() {
+			return ErrMempoolFull
+		}
+		gone[victim] = true
+		bytes -= m.entries[i].Size
+	}
+
+	for id := range gone {
+		m.removeAt(m.find(id))
+	}
+
+	for _, txIn := range tx.TxIns {
+		m.spends[OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex}] = tx.Id
+	}
+	m.entries = append(m.entries, entry)
+	m.bytes += entry.Size
+	return nil
+}
+
+// How many pending transactions a single replacement may evict, conflicts and descendants included.
+const maxReplacementEvictions = 100
+
+var ErrReplacementRejected = errors.New("replacement rejected")
+
+// replacementSet returns the ids of the pending transactions entry would replace: the ones spending
+// the same outputs, and their descendants. It fails unless all the conflicting transactions opted
+// in to replace-by-fee and entry pays for replacing them, which stops anyone from relaying
+// the same transaction over and over for free:
+//
+//   - its fee rate is higher than the one of every transaction it conflicts with,
+//   - its fee is higher than the fees of everything it evicts,
+//   - the difference pays the minimum relay fee for its own size,
+//   - it doesn't evict more than maxReplacementEvictions transactions,
+//   - it doesn't spend the outputs of a transaction it evicts.
+//
+// m.mu must be held.
+func (m *Mempool) replacementSet(entry *MempoolEntry) ([]string, error) {
+	conflicts := make([]string, 0)
+	for _, txIn := range entry.Tx.TxIns {
+		op := OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex}
+		spender, ok := m.spends[op]
+		if !ok || spender == entry.Tx.Id || slices.Contains(conflicts, spender) {
+			continue
+		}
+		original := m.entries[m.find(spender)]
+		if !original.Tx.SignalsReplacement() {
+			return nil, fmt.Errorf("output %s is already spent by pending transaction %s", op, spender)
+		}
+		if entry.FeeRate() <= original.FeeRate() {
+			return nil, fmt.Errorf("%w: the fee rate must be higher than the %.3f/B of %s", ErrReplacementRejected, original.FeeRate(), spender)
+		}
+		conflicts = append(conflicts, spender)
+	}
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+
+	replaced := append(conflicts, m.descendants(conflicts...)...)
+	if len(replaced) > maxReplacementEvictions {
+		return nil, fmt.Errorf("%w: it would evict %d transactions, at most %d can be", ErrReplacementRejected, len(replaced), maxReplacementEvictions)
+	}
+
+	replacedFees := Amount(0)
+	for _, id := range replaced {
+		for _, txIn := range entry.Tx.TxIns {
+			if txIn.TxOutId == id {
+				return nil, fmt.Errorf("%w: it spends an output of %s, which it replaces", ErrReplacementRejected, id)
+			}
+		}
+		// Every fee was checked when the entry was added, the sum is bounded by the supply.
+		replacedFees += m.entries[m.find(id)].Fee
+	}
+
+	relayFee, err := MulAmount(m.policy.MinRelayFee, uint64(entry.Size))
+	if err != nil {
+		return nil, err
+	}
+	if minFee := max(replacedFees+relayFee, replacedFees+1); entry.Fee < minFee {
+		return nil, fmt.Errorf("%w: it pays %s, but at least %s is needed to replace %d transactions paying %s",
+			ErrReplacementRejected, entry.Fee, minFee, len(replaced), replacedFees)
+	}
+	return replaced, nil
+}
+
+// CheckReplacement returns the ids of the pending transactions tx would replace if it was added
+// with this fee, or why it can't be added. See replacementSet.
+func (m *Mempool) CheckReplacement(tx *Transaction, fee Amount) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.replacementSet(&MempoolEntry{Tx: *tx, Fee: fee, Size: tx.Size()})
+}
+
+// overLimits reports whether the pool would break the policy with extraCount more
+// transactions of extraBytes bytes in total. m.mu must be held.
+func (m *Mempool) overLimits(extraCount, extraBytes int) bool {
+	if m.policy.MaxCount > 0 && len(m.entries)+extraCount > m.policy.MaxCount {
+		return true
+	}
+	return m.policy.MaxBytes > 0 && m.bytes+extraBytes > m.policy.MaxBytes
+}
+
+// evictionCandidate returns the id of the entry paying the lowest fee rate among the ones no
+// other pending transaction spends from, so evicting it never leaves a child without its parent.
+// The entries in skip count as already gone, the ones in keep are never picked. Returns "" if
+// there is nothing to evict. m.mu must be held.
+func (m *Mempool) evictionCandidate(skip, keep map[string]bool) string {
+	hasChild := make(map[string]bool)
+	for _, entry := range m.entries {
+		if skip[entry.Tx.Id] {
+			continue
+		}
+		for _, txIn := range entry.Tx.TxIns {
+			hasChild[txIn.TxOutId] = true
+		}
+	}
+
+	lowest := -1
+	for i, entry := range m.entries {
+		if skip[entry.Tx.Id] || keep[entry.Tx.Id] || hasChild[entry.Tx.Id] {
+			continue
+		}
+		if lowest < 0 || entry.FeeRate() < m.entries[lowest].FeeRate() {
+			lowest = i
+		}
+	}
+	if lowest < 0 {
+		return ""
+	}
+	return m.entries[lowest].Tx.Id
+}
+
+// find returns the index of the entry of the transaction, or -1. m.mu must be held.
+func (m *Mempool) find(txId string) int {
+	for i, entry := range m.entries {
+		if entry.Tx.Id == txId {
+			return i
+		}
+	}
+	return -1
+}
+
+// descendants returns the ids of the pending transactions spending the outputs of txIds,
+// directly or through other pending transactions. m.mu must be held.
+func (m *Mempool) descendants(txIds ...string) []string {
+	found := make(map[string]bool)
+	queue := slices.Clone(txIds)
+	result := make([]string, 0)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, entry := range m.entries {
+			if found[entry.Tx.Id] {
+				continue
+			}
+			for _, txIn := range entry.Tx.TxIns {
+				if txIn.TxOutId == id {
+					found[entry.Tx.Id] = true
+					result = append(result, entry.Tx.Id)
+					queue = append(queue, entry.Tx.Id)
+					break
+				}
+			}
+		}
+	}
+	return result
+}
+
+// removeAt drops the entry at index i and releases the outputs it spends. m.mu must be held.
+func (m *Mempool) removeAt(i int) {
+	entry := m.entries[i]
+	for _, txIn := range entry.Tx.TxIns {
+		delete(m.spends, OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex})
+	}
+	m.bytes -= entry.Size
+	m.entries = slices.Delete(m.entries, i, i+1)
+}
+
+// Expire drops the transactions that have been waiting longer than the TTL and returns their ids.
+func (m *Mempool) Expire() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.expire()
+}
+
+// expire is Expire with m.mu held. The children of an expired transaction go with it.
+func (m *Mempool) expire() []string {
+	expired := make([]string, 0)
+	if m.policy.TTL <= 0 {
+		return expired
+	}
+
+	cutoff := m.now().Add(-m.policy.TTL).Unix()
+	for _, entry := range m.entries {
+		if entry.AddedAt <= cutoff {
+			expired = append(expired, entry.Tx.Id)
+		}
+	}
+	return m.removeWithDescendants(expired)
+}
+
+// Remove drops the transactions with the given ids and releases the outputs they spend.
+// It is meant for transactions that were mined, so their children stay in the pool.
+func (m *Mempool) Remove(txIds ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	toRemove := make(map[string]bool, len(txIds))
+	for _, id := range txIds {
+		toRemove[id] = true
+	}
+
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		if toRemove[m.entries[i].Tx.Id] {
+			m.removeAt(i)
+		}
+	}
+}
+
+// RemoveWithDescendants drops the transactions and every pending transaction that spends
+// their outputs, directly or not. It returns the ids of everything that was dropped.
+func (m *Mempool) RemoveWithDescendants(txIds ...string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.removeWithDescendants(txIds)
+}
+
+// removeWithDescendants is RemoveWithDescendants with m.mu held.
+func (m *Mempool) removeWithDescendants(txIds []string) []string {
+	removed := make([]string, 0)
+	for _, id := range append(slices.Clone(txIds), m.descendants(txIds...)...) {
+		if i := m.find(id); i >= 0 {
+			m.removeAt(i)
+			removed = append(removed, id)
+		}
+	}
+	return removed
+}
+
+// Get returns the pending entry of the transaction with this id.
+func (m *Mempool) Get(txId string) (MempoolEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if i := m.find(txId); i >= 0 {
+		return m.entries[i], true
+	}
+	return MempoolEntry{}, false
+}
+
+// Output returns an output created by a pending transaction, whether another pending
+// transaction spends it or not.
+func (m *Mempool) Output(op OutPoint) (UTXO, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i := m.find(op.TxId)
+	if i < 0 || op.Index < 0 || op.Index >= int64(len(m.entries[i].Tx.TxOuts)) {
+		return UTXO{}, false
+	}
+	return UTXO{TxId: op.TxId, Index: op.Index, Output: m.entries[i].Tx.TxOuts[op.Index]}, true
+}
+
+// UnconfirmedOutputs returns the outputs of pending transactions paying address that no
+// other pending transaction spends yet, change included.
+func (m *Mempool) UnconfirmedOutputs(address string) []UTXO {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]UTXO, 0)
+	for _, entry := range m.entries {
+		for i, txOut := range entry.Tx.TxOuts {
+			op := OutPoint{TxId: entry.Tx.Id, Index: int64(i)}
+			if _, spent := m.spends[op]; spent || !txOut.PaysTo(address) {
+				continue
+			}
+			result = append(result, UTXO{TxId: op.TxId, Index: op.Index, Output: txOut})
+		}
+	}
+	return result
+}
+
+// SpentBy returns the id of the pending transaction that spends the output, if any.
+func (m *Mempool) SpentBy(op OutPoint) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	id, ok := m.spends[op]
+	return id, ok
+}
+
+// Entries returns a copy of the pending entries in arrival order.
+func (m *Mempool) Entries() []MempoolEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := make([]MempoolEntry, len(m.entries))
+	copy(entries, m.entries)
+	return entries
+}
+
+// Transactions returns a copy of the pending transactions in arrival order.
+func (m *Mempool) Transactions() []Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	txs := make([]Transaction, len(m.entries))
+	for i, entry := range m.entries {
+		txs[i] = entry.Tx
+	}
+	return txs
+}
+
+func (m *Mempool) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.entries)
+}
+
+// Info returns the size of the pool, the fees it holds and its policy.
+func (m *Mempool) Info() MempoolInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info := MempoolInfo{Count: len(m.entries), Bytes: m.bytes, Policy: m.policy}
+	for _, entry := range m.entries {
+		// Every fee was checked when the entry was added, the sum is bounded by the supply.
+		info.Fees += entry.Fee
+	}
+	return info
+}
+
+// TxPackage is a pending transaction preceded by the pending ancestors it needs, parents first.
+// Mining a child means mining its parents, so a child paying a high fee pulls them in with it.
+type TxPackage struct {
+	Entries []MempoolEntry `json:"entries"`
+	Fee     Amount         `json:"fee"`
+	Size    int            `json:"size"`
+}
+
+// FeeRate is the fee paid per byte by the whole package.
+func (p *TxPackage) FeeRate() float64 {
+	return feeRate(p.Fee, p.Size)
+}
+
+// Package returns the transaction with its pending ancestors.
+func (m *Mempool) Package(txId string) (TxPackage, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.find(txId) < 0 {
+		return TxPackage{}, false
+	}
+	byId := make(map[string]MempoolEntry, len(m.entries))
+	for _, entry := range m.entries {
+		byId[entry.Tx.Id] = entry
+	}
+	return ancestorPackage(byId, txId, nil), true
+}
+
+// ancestorPackage gathers the entry of txId and its ancestors found in byId, leaving out
+// the ones in exclude and everything behind them.
+func ancestorPackage(byId map[string]MempoolEntry, txId string, exclude map[string]bool) TxPackage {
+	var pkg TxPackage
+	visited := make(map[string]bool)
+
+	var visit func(id string)
+	visit = func(id string) {
+		entry, ok := byId[id]
+		if !ok || visited[id] || exclude[id] {
+			return
+		}
+		visited[id] = true
+		for _, txIn := range entry.Tx.TxIns {
+			visit(txIn.TxOutId)
+		}
+		pkg.Entries = append(pkg.Entries, entry)
+		// Every fee was checked when the entry was added, the sum is bounded by the supply.
+		pkg.Fee += entry.Fee
+		pkg.Size += entry.Size
+	}
+	visit(txId)
+	return pkg
+}
+
+// mempoolView sees the confirmed outputs plus the ones created by pending transactions,
+// so a transaction can spend change that is not mined yet.
+type mempoolView struct {
+	base    utxoView
+	mempool *Mempool
+	height  uint64 // Of the next block, where pending outputs would be created at the earliest
+}
+
+func (v mempoolView) Get(op OutPoint) (UTXO, bool) {
+	if u, ok := v.base.Get(op); ok {
+		return u, true
+	}
+	u, ok := v.mempool.Output(op)
+	u.Height = v.height
+	return u, ok
+}
+
+// checkBlockDoubleSpends makes sure no output is spent twice inside of the block.
+func checkBlockDoubleSpends(block *Block) error {
+	spentBy := make(map[OutPoint]string)
+	for _, tx := range block.Transactions {
+		for _, txIn := range tx.TxIns {
+			op := OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex}
+			if other, ok := spentBy[op]; ok {
+				return fmt.Errorf(
+					"ERROR: Block #%d spends output %s twice (transactions %s and %s)\n",
+					block.Index, op, other, tx.Id,
+				)
+			}
+			spentBy[op] = tx.Id
+		}
+	}
+	return nil
+}
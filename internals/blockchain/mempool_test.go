@@ -0,0 +1,136 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newPendingTx returns a transaction spending a made up output, so every call gives a new one.
+func newPendingTx(t *testing.T, n int) Transaction {
+	t.Helper()
+	tx, err := NewTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: fmt.Sprintf("funding-tx-%d", n), TxOutIndex: 0}},
+		TxOuts: []TxOut{{Address: "bob-address", Amount: Coin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *tx
+}
+
+func TestMempool_RejectsFeesBelowTheMinimumRelayFee(t *testing.T) {
+	m := NewMempoolWithPolicy(MempoolPolicy{MinRelayFee: 10})
+	tx := newPendingTx(t, 1)
+
+	if err := m.Add(tx, Amount(10*tx.Size()-1)); !errors.Is(err, ErrFeeTooLow) {
+		t.Fatalf("Add() = %v, want ErrFeeTooLow", err)
+	}
+	if err := m.Add(tx, Amount(10*tx.Size())); err != nil {
+		t.Fatalf("Add() with the minimum fee = %v", err)
+	}
+}
+
+func TestMempool_EvictsTheLowestFeeRateWhenFull(t *testing.T) {
+	m := NewMempoolWithPolicy(MempoolPolicy{MaxCount: 2})
+	cheap, medium, rich := newPendingTx(t, 1), newPendingTx(t, 2), newPendingTx(t, 3)
+
+	for _, add := range []struct {
+		tx  Transaction
+		fee Amount
+	}{{medium, 2 * Coin}, {cheap, Coin}, {rich, 3 * Coin}} {
+		if err := m.Add(add.tx, add.fee); err != nil {
+			t.Fatalf("Add(%s) = %v", add.tx.Id, err)
+		}
+	}
+
+	if _, ok := m.Get(cheap.Id); ok {
+		t.Error("the transaction paying the lowest fee rate should be evicted")
+	}
+	if _, ok := m.SpentBy(OutPoint{TxId: "funding-tx-1", Index: 0}); ok {
+		t.Error("the outputs of the evicted transaction should be released")
+	}
+	if err := m.Add(newPendingTx(t, 4), Coin); !errors.Is(err, ErrMempoolFull) {
+		t.Errorf("Add() paying less than everything in the pool = %v, want ErrMempoolFull", err)
+	}
+	if info := m.Info(); info.Count != 2 || info.Fees != 5*Coin || info.Bytes != medium.Size()+rich.Size() {
+		t.Errorf("Info() = %+v, want the medium and rich transactions", info)
+	}
+}
+
+func TestMempool_EvictsToStayUnderTheByteCap(t *testing.T) {
+	first := newPendingTx(t, 1)
+	m := NewMempoolWithPolicy(MempoolPolicy{MaxBytes: 2 * first.Size()})
+
+	for i, fee := range []Amount{Coin, 2 * Coin, 3 * Coin} {
+		if err := m.Add(newPendingTx(t, i+1), fee); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+	if info := m.Info(); info.Count != 2 || info.Fees != 5*Coin {
+		t.Errorf("Info() = %+v, want the two best paying transactions", info)
+	}
+}
+
+func TestMempool_ExpiresOldTransactions(t *testing.T) {
+	m := NewMempoolWithPolicy(MempoolPolicy{TTL: time.Hour})
+	now := time.Unix(1_700_000_000, 0)
+	m.now = func() time.Time { return now }
+
+	old := newPendingTx(t, 1)
+	if err := m.Add(old, Coin); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Minute)
+	young := newPendingTx(t, 2)
+	if err := m.Add(young, Coin); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(45 * time.Minute)
+	if expired := m.Expire(); len(expired) != 1 || expired[0] != old.Id {
+		t.Fatalf("Expire() = %v, want only %s", expired, old.Id)
+	}
+	if _, ok := m.Get(young.Id); !ok {
+		t.Error("the transaction younger than the TTL should stay")
+	}
+}
+
+func TestMempool_DroppingAParentDropsItsChildren(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+	if err, _ := bc.AppendBlockFor(address); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	coinbase := bc.GetLastBlock().Transactions[0]
+
+	parent, err := bc.SignTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: coinbase.Id, TxOutIndex: 0}},
+		TxOuts: []TxOut{{Address: address, Amount: coinbase.TxOuts[0].Amount - testFee}},
+	}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendTransaction(parent); err != nil {
+		t.Fatalf("Failed to append the parent: %v", err)
+	}
+	child, err := bc.SignTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: parent.Id, TxOutIndex: 0}},
+		TxOuts: []TxOut{{Address: "bob-address", Amount: parent.TxOuts[0].Amount - testFee}},
+	}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendTransaction(child); err != nil {
+		t.Fatalf("Failed to append the child: %v", err)
+	}
+
+	if pkg, _ := bc.Mempool.Package(child.Id); len(pkg.Entries) != 2 || pkg.Fee != 2*testFee {
+		t.Errorf("Package(child) = %+v, want the parent and the child", pkg)
+	}
+	if removed := bc.Mempool.RemoveWithDescendants(parent.Id); len(removed) != 2 {
+		t.Errorf("RemoveWithDescendants(parent) = %v, want the child dropped too", removed)
+	}
+}
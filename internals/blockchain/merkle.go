@@ -0,0 +1,165 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Leaves and inner nodes are hashed with different prefixes so an inner node can never be
+// passed off as a transaction id.
+const (
+	merkleLeafPrefix  = 0x00
+	merkleInnerPrefix = 0x01
+)
+
+// The Merkle root of a block without transactions.
+var emptyMerkleRoot = strings.Repeat("0", 64)
+
+var ErrTxNotInBlock = errors.New("the transaction is not in the block")
+
+// MerkleStep is one level of a Merkle proof: the hash to combine with and its side.
+type MerkleStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // The sibling goes on the left of the running hash
+}
+
+// MerkleProof shows that a transaction is part of a block without sending the whole block.
+type MerkleProof struct {
+	TxId       string       `json:"tx_id"`
+	BlockHash  string       `json:"block_hash"`
+	BlockIndex uint64       `json:"block_index"`
+	MerkleRoot string       `json:"merkle_root"`
+	Steps      []MerkleStep `json:"steps"` // From the leaf up to the root
+}
+
+func merkleLeaf(txId string) []byte {
+	sum := sha256.Sum256(append([]byte{merkleLeafPrefix}, txId...))
+	return sum[:]
+}
+
+func merkleParent(left, right []byte) []byte {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(buf, merkleInnerPrefix)
+	buf = append(buf, left...)
+	buf = append(buf, right...)
+	sum := sha256.Sum256(buf)
+	return sum[:]
+}
+
+// nextMerkleLevel hashes the nodes of a level in pairs. An odd node out is carried up as is
+// rather than paired with itself, so two different lists of ids can't share a root.
+func nextMerkleLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleParent(level[i], level[i+1]))
+	}
+	return next
+}
+
+// MerkleRoot returns the hex encoded root of the Merkle tree over txIds, in order.
+func MerkleRoot(txIds []string) string {
+	if len(txIds) == 0 {
+		return emptyMerkleRoot
+	}
+
+	level := make([][]byte, len(txIds))
+	for i, id := range txIds {
+		level[i] = merkleLeaf(id)
+	}
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// blockMerkleRoot is the Merkle root a block with these transactions must carry.
+func blockMerkleRoot(transactions []Transaction) string {
+	return MerkleRoot(transactionIds(transactions))
+}
+
+// NewMerkleProof builds the proof that the transaction txId is part of block.
+func NewMerkleProof(block *Block, txId string) (*MerkleProof, error) {
+	ids := transactionIds(block.Transactions)
+	position := slices.Index(ids, txId)
+	if position == -1 {
+		return nil, fmt.Errorf("%w: %s is not in block #%d", ErrTxNotInBlock, txId, block.Index)
+	}
+
+	level := make([][]byte, len(ids))
+	for i, id := range ids {
+		level[i] = merkleLeaf(id)
+	}
+
+	steps := make([]MerkleStep, 0)
+	for len(level) > 1 {
+		sibling := position ^ 1
+		if sibling < len(level) {
+			steps = append(steps, MerkleStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < position,
+			})
+		}
+		level = nextMerkleLevel(level)
+		position /= 2
+	}
+
+	return &MerkleProof{
+		TxId:       txId,
+		BlockHash:  block.Hash,
+		BlockIndex: block.Index,
+		MerkleRoot: block.MerkleRoot,
+		Steps:      steps,
+	}, nil
+}
+
+// VerifyMerkleProof checks that the proof links txId to merkleRoot. Light clients take the
+// root from a block header they trust, never from the proof itself.
+func VerifyMerkleProof(txId, merkleRoot string, proof *MerkleProof) bool {
+	if proof == nil || proof.TxId != txId {
+		return false
+	}
+
+	hash := merkleLeaf(txId)
+	for _, step := range proof.Steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return false
+		}
+		if step.Left {
+			hash = merkleParent(sibling, hash)
+		} else {
+			hash = merkleParent(hash, sibling)
+		}
+	}
+	return hex.EncodeToString(hash) == merkleRoot
+}
+
+// BlockByHash returns a copy of the block of the chain with the given hash.
+func (b *Blockchain) BlockByHash(hash string) (*Block, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i := range b.Chain {
+		if b.Chain[i].Hash == hash {
+			block := b.Chain[i]
+			return &block, true
+		}
+	}
+	return nil, false
+}
+
+// MerkleProof returns the proof that the transaction txId is part of the block with blockHash.
+func (b *Blockchain) MerkleProof(blockHash, txId string) (*MerkleProof, error) {
+	block, ok := b.BlockByHash(blockHash)
+	if !ok {
+		return nil, fmt.Errorf("block %s is not in the chain", blockHash)
+	}
+	return NewMerkleProof(block, txId)
+}
@@ -0,0 +1,59 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProof_VerifiesEveryTransaction(t *testing.T) {
+	for count := 1; count <= 7; count++ {
+		block := NewBlock(BlockInsert{Index: 1})
+		for i := range count {
+			block.Transactions = append(block.Transactions, Transaction{Id: fmt.Sprintf("tx-%d", i)})
+		}
+		block.MerkleRoot = blockMerkleRoot(block.Transactions)
+
+		for _, tx := range block.Transactions {
+			proof, err := NewMerkleProof(block, tx.Id)
+			if err != nil {
+				t.Fatalf("NewMerkleProof(%d txs, %s) = %v", count, tx.Id, err)
+			}
+			if !VerifyMerkleProof(tx.Id, block.MerkleRoot, proof) {
+				t.Errorf("proof of %s in a block of %d transactions does not verify", tx.Id, count)
+			}
+			if VerifyMerkleProof("tx-forged", block.MerkleRoot, proof) {
+				t.Errorf("proof of %s also verifies a forged transaction", tx.Id)
+			}
+		}
+	}
+}
+
+func TestMerkleProof_RejectsTamperedProof(t *testing.T) {
+	block := NewBlock(BlockInsert{Index: 1, Transactions: []Transaction{{Id: "a"}, {Id: "b"}, {Id: "c"}}})
+	block.MerkleRoot = blockMerkleRoot(block.Transactions)
+
+	proof, err := NewMerkleProof(block, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof.Steps[0].Left = !proof.Steps[0].Left
+	if VerifyMerkleProof("b", block.MerkleRoot, proof) {
+		t.Error("a proof with a sibling on the wrong side should not verify")
+	}
+
+	if _, err := NewMerkleProof(block, "d"); err == nil {
+		t.Error("NewMerkleProof should fail for a transaction outside of the block")
+	}
+}
+
+func TestValidateChain_RejectsWrongMerkleRoot(t *testing.T) {
+	bc := validTestChain(t)
+	chain := cloneChain(bc.Chain)
+	last := &chain[len(chain)-1]
+
+	// Drop the payment but keep the header, then re-mine so only the Merkle root is off.
+	last.Transactions = last.Transactions[:1]
+	bc.mine(last)
+
+	expectRule(t, bc.ValidateChain(chain), last.Index, RuleMerkleRoot)
+}
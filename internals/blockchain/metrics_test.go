@@ -68,7 +68,7 @@ func writeMetric(file_name, name string, iteration int, diff uint32, value time.
 // 		_ = bc.ValidateTransaction(newTx)
 // 		elapsed := time.Since(start)
 //
-// 		writeMetric("ts_validation_metrics", "TransactionValidation", i, bc.Difficulty, elapsed)
+// 		writeMetric("ts_validation_metrics", "TransactionValidation", i, bc.Params.InitialDifficulty/4, elapsed)
 // 		b.Logf("Validação da transação levou: %v", elapsed)
 // 	}
 // }
@@ -76,7 +76,8 @@ func writeMetric(file_name, name string, iteration int, diff uint32, value time.
 // func BenchmarkMetric_MiningSpeedByDifficulty(b *testing.B) {
 // 	for d := uint32(1); d <= 6; d++ {
 // 		bc := NewBlockchain("")
-// 		bc.Difficulty = d
+// 		bc.Params.InitialDifficulty = d * 4
+// 		bc.Params.RetargetInterval = 0
 //
 // 		for i := range 10 {
 // 			start := time.Now()
@@ -92,7 +93,9 @@ func writeMetric(file_name, name string, iteration int, diff uint32, value time.
 func BenchmarkMetric_HashRateByDifficulty(b *testing.B) {
 	for d := uint32(1); d <= 6; d++ {
 		bc := NewBlockchain("")
-		bc.Difficulty = d
+		// d counts leading zero hex digits, each one is 4 bits.
+		bc.Params.InitialDifficulty = d * 4
+		bc.Params.RetargetInterval = 0
 
 		for i := 0; i < 10; i++ {
 			start := time.Now()
@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrMiningCanceled = errors.New("mining was canceled")
+	ErrStaleBlock     = errors.New("the chain tip changed while mining, the block is stale")
+)
+
+// nonceSpace is how many nonces are tried for a header before its timestamp is rolled forward.
+// It is a variable so tests can make it small.
+var nonceSpace uint64 = 1 << 32
+
+// How many nonces a worker tries between two checks of the context.
+const cancelCheckInterval = 1024
+
+// mineBlock searches for a nonce that gives the block enough proof-of-work, splitting the
+// nonce space between workers goroutines. When every nonce was tried the timestamp is rolled
+// forward and the search starts over. The solution is written to block, which is returned
+// along with the number of hashes computed.
+func mineBlock(ctx context.Context, block *Block, workers int) (*Block, int, error) {
+	workers = max(workers, 1)
+	total := 0
+
+	for {
+		found, attempts, err := searchNonces(ctx, block, workers)
+		total += attempts
+		if err != nil {
+			return nil, total, err
+		}
+		if found != nil {
+			*block = *found
+			return block, total, nil
+		}
+
+		block.Timestamp = max(block.Timestamp+1, time.Now().Unix())
+	}
+}
+
+// searchNonces tries every nonce of the nonce space for the block header.
+// Worker i tries the nonces i, i+workers, i+2*workers... and the first one to find a
+// solution stops the others. It returns a nil block if the nonce space ran out.
+func searchNonces(parent context.Context, block *Block, workers int) (*Block, int, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var attempts atomic.Int64
+	var once sync.Once
+	var found *Block
+	var wg sync.WaitGroup
+
+	for worker := range workers {
+		wg.Add(1)
+		go func(start uint64) {
+			defer wg.Done()
+
+			candidate := *block // Each worker changes the nonce of its own copy of the header.
+			count := int64(0)
+			defer func() { attempts.Add(count) }()
+
+			for nonce := start; nonce < nonceSpace; nonce += uint64(workers) {
+				if count%cancelCheckInterval == 0 && ctx.Err() != nil {
+					return
+				}
+				count++
+
+				candidate.Nonce = nonce
+				hash := hashBlock(&candidate)
+				if hasProofOfWork(hash, candidate.Difficulty) {
+					candidate.Hash = hash
+					once.Do(func() {
+						found = &candidate
+						cancel()
+					})
+					return
+				}
+			}
+		}(uint64(worker))
+	}
+	wg.Wait()
+
+	if found != nil {
+		return found, int(attempts.Load()), nil
+	}
+	if parent.Err() != nil {
+		return nil, int(attempts.Load()), ErrMiningCanceled
+	}
+	return nil, int(attempts.Load()), nil
+}
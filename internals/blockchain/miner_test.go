@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMiner_RollsTimestampWhenNoncesRunOut(t *testing.T) {
+	defer func(space uint64) { nonceSpace = space }(nonceSpace)
+	nonceSpace = 4
+
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 10
+	bc.MinerWorkers = 2
+
+	start := time.Now().Unix()
+	if err, _ := bc.AppendBlock(); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+
+	last := bc.GetLastBlock()
+	if last.Nonce >= nonceSpace {
+		t.Errorf("nonce %d is outside of the nonce space", last.Nonce)
+	}
+	if err := bc.ValidateChain(bc.Chain); err != nil {
+		t.Errorf("mined chain is not valid: %v", err)
+	}
+	if last.Timestamp < start {
+		t.Errorf("timestamp went back from %d to %d", start, last.Timestamp)
+	}
+}
+
+func TestMiner_StopsWhenCanceled(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = maxDifficulty // Never solvable in practice
+	bc.MinerWorkers = 4
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err, _ := bc.AppendBlockContext(ctx, "")
+	if !errors.Is(err, ErrMiningCanceled) {
+		t.Fatalf("AppendBlockContext() = %v, want %v", err, ErrMiningCanceled)
+	}
+	if len(bc.Chain) != 1 {
+		t.Errorf("chain has %d blocks, want only the genesis", len(bc.Chain))
+	}
+}
+
+func TestMiner_StopsWhenTipChanges(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = maxDifficulty
+
+	done := make(chan error)
+	go func() {
+		err, _ := bc.AppendBlockContext(context.Background(), "")
+		done <- err
+	}()
+
+	// Let the miner start, then pretend a competing block arrived.
+	time.Sleep(20 * time.Millisecond)
+	bc.mu.Lock()
+	bc.signalTipChange()
+	bc.mu.Unlock()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrStaleBlock) {
+			t.Fatalf("AppendBlockContext() = %v, want %v", err, ErrStaleBlock)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mining did not stop after the tip changed")
+	}
+}
@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"slices"
+)
+
+// NewMultisigScript returns the locking script spent with signatures of m of the public keys,
+// PKIX encoded:
+//
+//	<m> <pubKey 1> ... <pubKey n> <n> OP_CHECKMULTISIG
+func NewMultisigScript(m int, pubKeys [][]byte) (Script, error) {
+	if len(pubKeys) < 1 || len(pubKeys) > maxMultisigKeys {
+		return nil, fmt.Errorf("%d public keys, a multisig output has 1 to %d", len(pubKeys), maxMultisigKeys)
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("the threshold must be between 1 and %d", len(pubKeys))
+	}
+
+	script := Script{}.addNumber(int64(m))
+	for i, pubKey := range pubKeys {
+		key, err := x509.ParsePKIXPublicKey(pubKey)
+		if _, ok := key.(*ecdsa.PublicKey); err != nil || !ok {
+			return nil, fmt.Errorf("public key %d is not an ECDSA key", i+1)
+		}
+		if slices.ContainsFunc(pubKeys[:i], func(other []byte) bool { return bytes.Equal(other, pubKey) }) {
+			return nil, fmt.Errorf("public key %d is listed twice", i+1)
+		}
+		script = script.addData(pubKey)
+	}
+	return script.addNumber(int64(len(pubKeys))).addOp(OpCheckMultiSig), nil
+}
+
+// Multisig returns the threshold and the public keys of a script made by NewMultisigScript.
+func (s Script) Multisig() (int, [][]byte, bool) {
+	ops, err := s.parse()
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].code != OpCheckMultiSig {
+		return 0, nil, false
+	}
+	m, n := ops[0].code, ops[len(ops)-2].code
+	if m < Op1 || m > Op16 || n < Op1 || n > Op16 || m > n || int(n-Op1+1) != len(ops)-3 {
+		return 0, nil, false
+	}
+
+	pubKeys := make([][]byte, 0, len(ops)-3)
+	for _, op := range ops[1 : len(ops)-2] {
+		if op.code < 1 || op.code > OpPushData2 {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, op.data)
+	}
+	return int(m - Op1 + 1), pubKeys, true
+}
+
+// MultisigAddress returns the address of the outputs m of publicKeys, base64 encoded, can
+// spend together, and the script spending them reveals. The keys themselves are needed,
+// their addresses only hold their hashes.
+func MultisigAddress(m int, publicKeys []string) (string, Script, error) {
+	pubKeys := make([][]byte, len(publicKeys))
+	for i, publicKey := range publicKeys {
+		pubKey, err := decodePublicKey(publicKey)
+		if err != nil {
+			return "", nil, fmt.Errorf("cosigner %d: %w", i+1, err)
+		}
+		pubKeys[i] = pubKey
+	}
+	script, err := NewMultisigScript(m, pubKeys)
+	if err != nil {
+		return "", nil, err
+	}
+	return ScriptAddress(script), script, nil
+}
+
+// signMultisig adds the signature of privKey to the unlocking script of the input at index,
+// which spends spent with the multisig script, revealed if spent only pays its hash. The cosigners
+// can sign one after the other, in any order, the signatures are kept in the order of their keys.
+func signMultisig(tx *Transaction, index int, spent TxOut, script, revealed Script, privKey *ecdsa.PrivateKey) error {
+	m, pubKeys, _ := script.Multisig()
+	pubKey, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	position := slices.IndexFunc(pubKeys, func(k []byte) bool { return bytes.Equal(k, pubKey) })
+	if position < 0 {
+		return fmt.Errorf("input %d spends a multisig output the key is not part of", index)
+	}
+
+	c := &scriptContext{tx: tx, index: index, spent: spent}
+	signatures := make([][]byte, len(pubKeys))
+	ops, err := tx.TxIns[index].Script.parse()
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		for i, k := range pubKeys {
+			if signatures[i] == nil && c.checkSig(op.data, k) {
+				signatures[i] = op.data
+				break
+			}
+		}
+	}
+	if signatures[position] == nil {
+		if signatures[position], err = ecdsa.SignASN1(rand.Reader, privKey, SigHash(tx, index, spent)); err != nil {
+			return err
+		}
+	}
+
+	unlocking, signed := Script{}, 0
+	for _, signature := range signatures {
+		if signature != nil && signed < m {
+			unlocking = unlocking.addData(signature)
+			signed++
+		}
+	}
+	if revealed != nil {
+		unlocking = unlocking.addData(revealed)
+	}
+	tx.TxIns[index].Script = unlocking
+	return nil
+}
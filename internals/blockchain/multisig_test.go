@@ -0,0 +1,144 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"testing"
+)
+
+func TestMultisig_TwoOfThreeNeedsTwoDistinctSignatures(t *testing.T) {
+	bc := NewBlockchain("")
+	keys := make([]*ecdsa.PrivateKey, 3)
+	addresses := make([]string, 3)
+	for i := range keys {
+		keys[i], addresses[i] = newTestKey(t)
+	}
+	outsider, _ := newTestKey(t)
+
+	treasury, script, err := MultisigAddress(2, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The address only holds the hash of the script, the cosigners can only tell an output
+	// is theirs when it is locked by the script itself.
+	funding, err := PayToAddress(treasury, 5*Coin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := NewBlock(BlockInsert{Index: 1, PrevHash: bc.GetLastBlock().Hash, Transactions: []Transaction{{
+		Version: CurrentTxVersion,
+		Id:      "funding-tx",
+		TxOuts:  []TxOut{funding, {Amount: Coin, Script: script}},
+	}}})
+	appendTestBlock(t, bc, block)
+
+	if n := len(bc.GetUTXPoolByAddress(treasury)); n != 2 {
+		t.Errorf("the multisig address has %d outputs, want 2", n)
+	}
+	for _, address := range addresses {
+		if n := len(bc.MultisigOutputs(address)); n != 1 {
+			t.Errorf("a cosigner sees %d multisig outputs, want 1", n)
+		}
+		if n := len(bc.GetUTXPoolByAddress(address)); n != 0 {
+			t.Errorf("a cosigner alone owns %d outputs, want 0", n)
+		}
+	}
+
+	tx, err := NewTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: "funding-tx", TxOutIndex: 0}},
+		TxOuts: []TxOut{{Address: "payee-address", Amount: 5*Coin - testFee}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignInput(tx, 0, funding, keys[0]); err == nil {
+		t.Error("SignInput() without revealing the script should fail")
+	}
+	tx.TxIns[0].Script = RevealScript(script)
+	if err := SignInput(tx, 0, funding, outsider); err == nil {
+		t.Error("SignInput() with a key that is not a cosigner should fail")
+	}
+
+	if err := SignInput(tx, 0, funding, keys[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ValidateTransaction(tx); err == nil {
+		t.Fatal("one signature out of two should not be enough")
+	}
+	// The same key signing twice doesn't make two signatures.
+	if err := SignInput(tx, 0, funding, keys[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ValidateTransaction(tx); err == nil {
+		t.Fatal("a key signing twice should not count as two signatures")
+	}
+	_, signature, err := tx.TxIns[0].Script.splitRevealed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicated := *tx
+	duplicated.TxIns = []TxIn{{TxOutId: "funding-tx", TxOutIndex: 0, Script: append(append(Script{}, signature...), tx.TxIns[0].Script...)}}
+	if err := bc.ValidateTransaction(&duplicated); err == nil {
+		t.Fatal("the same signature pushed twice should not count as two signatures")
+	}
+
+	// The signatures end up in the order of the keys whoever signed first.
+	if err := SignInput(tx, 0, funding, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ValidateTransaction(tx); err != nil {
+		t.Fatalf("ValidateTransaction() with two signatures = %v", err)
+	}
+
+	// Revealing any other script, even one anybody can spend, doesn't unlock the output.
+	_, signatures, err := tx.TxIns[0].Script.splitRevealed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := *tx
+	forged.TxIns = []TxIn{{TxOutId: "funding-tx", TxOutIndex: 0, Script: append(signatures, RevealScript(Script{}.addOp(OpTrue))...)}}
+	if err := bc.ValidateTransaction(&forged); err == nil {
+		t.Fatal("revealing a script that does not match the address should fail")
+	}
+}
+
+func TestMultisig_Script(t *testing.T) {
+	_, address := newTestKey(t)
+	_, other := newTestKey(t)
+
+	tests := []struct {
+		name      string
+		m         int
+		addresses []string
+		valid     bool
+	}{
+		{"1 of 1", 1, []string{address}, true},
+		{"2 of 2", 2, []string{address, other}, true},
+		{"no threshold", 0, []string{address, other}, false},
+		{"threshold above the keys", 3, []string{address, other}, false},
+		{"same key twice", 1, []string{address, address}, false},
+		{"not a key", 1, []string{address, "bob-address"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			multisig, script, err := MultisigAddress(tt.m, tt.addresses)
+			if (err == nil) != tt.valid {
+				t.Fatalf("MultisigAddress() = %v, want valid %v", err, tt.valid)
+			}
+			if err != nil {
+				return
+			}
+			txOut, err := PayToAddress(multisig, Coin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scriptHash, ok := txOut.Script.ScriptHash(); !ok || hex.EncodeToString(scriptHash) != sha256Hex(script) {
+				t.Errorf("the output pays %s, want the hash of the multisig script", txOut.Script)
+			}
+			m, pubKeys, ok := script.Multisig()
+			if !ok || m != tt.m || len(pubKeys) != len(tt.addresses) {
+				t.Errorf("Multisig() = %d, %d keys, %v, want %d of %d", m, len(pubKeys), ok, tt.m, len(tt.addresses))
+			}
+		})
+	}
+}
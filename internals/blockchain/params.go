@@ -0,0 +1,90 @@
+package blockchain
+
+// ConsensusParams are the rules every node of a network has to agree on.
+type ConsensusParams struct {
+	InitialSubsidy  Amount `json:"initial_subsidy"`  // Paid to the miner of a block before the first halving
+	HalvingInterval uint64 `json:"halving_interval"` // Blocks between two halvings of the subsidy, 0 disables halving
+	MaxSupply       Amount `json:"max_supply"`       // What will ever be created by block rewards
+	MaxBlockSize    int    `json:"max_block_size"`   // Maximum size in bytes of the transactions of a block
+
+	InitialDifficulty uint32 `json:"initial_difficulty"` // Leading zero bits the first blocks need
+	MinDifficulty     uint32 `json:"min_difficulty"`     // Retargeting never goes below this
+	RetargetInterval  uint64 `json:"retarget_interval"`  // Blocks between two difficulty adjustments, 0 disables them
+	TargetBlockTime   int64  `json:"target_block_time"`  // Seconds we want between two blocks
+}
+
+func DefaultConsensusParams() ConsensusParams {
+	return ConsensusParams{
+		InitialSubsidy:  50 * Coin,
+		HalvingInterval: 10_000,
+		MaxSupply:       1_000_000 * Coin,
+		MaxBlockSize:    1 << 20,
+
+		InitialDifficulty: 16,
+		MinDifficulty:     1,
+		RetargetInterval:  10,
+		TargetBlockTime:   30,
+	}
+}
+
+// After this many halvings the subsidy is too small to matter.
+const maxHalvings = 64
+
+// scheduledSubsidy is the subsidy of the block at height, ignoring the supply cap.
+func (p ConsensusParams) scheduledSubsidy(height uint64) Amount {
+	if height == 0 {
+		return 0 // the genesis block has no coinbase
+	}
+	if p.HalvingInterval == 0 {
+		return p.InitialSubsidy
+	}
+
+	halvings := height / p.HalvingInterval
+	if halvings >= maxHalvings {
+		return 0
+	}
+	return p.InitialSubsidy >> halvings
+}
+
+// issuedBefore returns how much the blocks before height created, ignoring the supply cap.
+// It saturates at MaxSupply, which is all callers need.
+func (p ConsensusParams) issuedBefore(height uint64) Amount {
+	if height <= 1 {
+		return 0
+	}
+	if p.HalvingInterval == 0 {
+		issued, err := MulAmount(p.InitialSubsidy, height-1)
+		if err != nil {
+			return p.MaxSupply
+		}
+		return min(issued, p.MaxSupply)
+	}
+
+	issued := Amount(0)
+	for era := uint64(0); era < maxHalvings; era++ {
+		start := max(era*p.HalvingInterval, 1)
+		if start >= height || issued >= p.MaxSupply {
+			break
+		}
+		end := min((era+1)*p.HalvingInterval, height)
+		minted, err := MulAmount(p.scheduledSubsidy(start), end-start)
+		if err != nil {
+			return p.MaxSupply
+		}
+		if issued, err = AddAmounts(issued, minted); err != nil {
+			return p.MaxSupply
+		}
+	}
+	return min(issued, p.MaxSupply)
+}
+
+// BlockSubsidy returns the new coins the miner of the block at height can claim.
+// Once MaxSupply is reached blocks only pay the fees of their transactions.
+func (p ConsensusParams) BlockSubsidy(height uint64) Amount {
+	subsidy := p.scheduledSubsidy(height)
+	remaining := p.MaxSupply - p.issuedBefore(height)
+	if remaining <= 0 {
+		return 0
+	}
+	return min(subsidy, remaining)
+}
@@ -0,0 +1,47 @@
+package blockchain
+
+import "testing"
+
+func TestConsensusParams_BlockSubsidy(t *testing.T) {
+	params := ConsensusParams{
+		InitialSubsidy:  50 * Coin,
+		HalvingInterval: 10,
+		MaxSupply:       1_000 * Coin,
+	}
+
+	tests := []struct {
+		height uint64
+		want   Amount
+	}{
+		{0, 0},                  // genesis
+		{1, 50 * Coin},          // first reward
+		{9, 50 * Coin},          // last block of the first era
+		{10, 25 * Coin},         // first halving
+		{20, 1250 * Coin / 100}, // second halving
+		{30, 625 * Coin / 100},
+	}
+	for _, tt := range tests {
+		if got := params.BlockSubsidy(tt.height); got != tt.want {
+			t.Errorf("BlockSubsidy(%d) = %v, want %v", tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestConsensusParams_SupplyCap(t *testing.T) {
+	params := ConsensusParams{
+		InitialSubsidy:  50 * Coin,
+		HalvingInterval: 0,
+		MaxSupply:       120 * Coin,
+	}
+
+	total := Amount(0)
+	for height := uint64(1); height <= 10; height++ {
+		total += params.BlockSubsidy(height)
+	}
+	if total != params.MaxSupply {
+		t.Errorf("total subsidy = %v, want %v", total, params.MaxSupply)
+	}
+	if got := params.BlockSubsidy(3); got != 20*Coin {
+		t.Errorf("BlockSubsidy(3) = %v, want the 20 left before the cap", got)
+	}
+}
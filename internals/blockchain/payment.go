@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// Payment is what a wallet wants to send, before the outputs paying it are picked.
+type Payment struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Amount      Amount `json:"amount"`
+	Fee         Amount `json:"fee"`
+	Replaceable bool   `json:"replaceable"`      // Lets the sender bump the fee later, see SequenceReplaceable
+	Script      Script `json:"script,omitempty"` // The script From is the hash of, when From is a script address
+}
+
+// BuildPayment picks outputs From can spend right now, confirmed ones first, until they cover
+// the amount and the fee. The amount goes to To, whatever is left beyond the fee goes back
+// to From as change. Spending from a script address reveals its script, which the payment
+// must carry. The returned transaction still has to be signed.
+func (b *Blockchain) BuildPayment(p Payment) (TransactionInput, error) {
+	// A mistyped sender would only look like an empty wallet.
+	from, err := addressScript(p.From)
+	if err != nil {
+		return TransactionInput{}, fmt.Errorf("sender: %w", err)
+	}
+	scriptHash, isScript := from.ScriptHash()
+	if sum := sha256.Sum256(p.Script); isScript && !bytes.Equal(sum[:], scriptHash) {
+		return TransactionInput{}, errors.New("sender: the script does not match the script address")
+	}
+	if !isScript && len(p.Script) > 0 {
+		return TransactionInput{}, errors.New("sender: only a script address is spent with a script")
+	}
+	// Pending change can be spent right away, so paying twice before a block is mined works.
+	return fundPayment(p, b.SpendableOutputs(p.From), p.From)
+}
+
+// fundPayment picks outputs of spendable, in order, until they cover the amount and the fee
+// of the payment, and sends whatever is left beyond the fee to change.
+func fundPayment(p Payment, spendable []UTXO, change string) (TransactionInput, error) {
+	if p.Amount <= 0 {
+		return TransactionInput{}, errors.New("the amount must be positive")
+	}
+	if p.Fee < 0 {
+		return TransactionInput{}, errors.New("the fee can't be negative")
+	}
+	totalNeeded, err := AddAmounts(p.Amount, p.Fee)
+	if err != nil {
+		return TransactionInput{}, err
+	}
+	payment, err := PayToAddress(p.To, p.Amount)
+	if err != nil {
+		return TransactionInput{}, fmt.Errorf("recipient: %w", err)
+	}
+
+	var txIns []TxIn
+	var totalInput Amount
+	for _, utxo := range spendable {
+		txIn := TxIn{TxOutId: utxo.TxId, TxOutIndex: utxo.Index}
+		if _, ok := utxo.Output.Script.ScriptHash(); ok && len(p.Script) > 0 {
+			txIn.Script = RevealScript(p.Script)
+		}
+		if p.Replaceable {
+			txIn.Sequence = SequenceReplaceable
+		}
+		txIns = append(txIns, txIn)
+		// Confirmed and pending outputs are bounded by the supply, their sum can't overflow.
+		totalInput += utxo.Output.Amount
+		if totalInput >= totalNeeded {
+			break
+		}
+	}
+	if totalInput < totalNeeded {
+		return TransactionInput{}, ErrInsufficientFunds
+	}
+
+	txOuts := []TxOut{payment}
+	// Whatever is not sent back as change is the fee paid to the miner.
+	if left := totalInput - totalNeeded; left > 0 {
+		changeOut, err := PayToAddress(change, left)
+		if err != nil {
+			return TransactionInput{}, fmt.Errorf("change: %w", err)
+		}
+		txOuts = append(txOuts, changeOut)
+	}
+	return TransactionInput{TxIns: txIns, TxOuts: txOuts}, nil
+}
@@ -0,0 +1,273 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// PartialTransaction is a transaction on its way to being signed by several cosigners, or by a
+// machine that can't see the chain. It carries the outputs the inputs spend, which the
+// signatures commit to, and the signatures gathered so far. Once every input has the
+// signatures it needs, Finalize turns it into a transaction ready to be broadcast.
+type PartialTransaction struct {
+	Tx     Transaction    `json:"tx"` // Without signatures nor unlocking scripts
+	Inputs []PartialInput `json:"inputs"`
+}
+
+type PartialInput struct {
+	Spent      TxOut             `json:"spent"`
+	Script     Script            `json:"script,omitempty"` // The script Spent pays the hash of, if it pays a script address
+	Signatures map[string]string `json:"signatures"`       // Base64 DER signatures, by the base64 PKIX public key that made them
+}
+
+var ErrIncompleteTransaction = errors.New("the transaction is missing signatures")
+
+// NewPartialTransaction creates the unsigned transaction. spent holds the output each input
+// spends, in the same order. An input spending a script address must reveal the script, see
+// RevealScript, which the partial transaction keeps aside until it is finalized.
+func NewPartialTransaction(input TransactionInput, spent []TxOut) (*PartialTransaction, error) {
+	if len(spent) != len(input.TxIns) {
+		return nil, fmt.Errorf("%d inputs but %d spent outputs", len(input.TxIns), len(spent))
+	}
+
+	input.TxIns = slices.Clone(input.TxIns)
+	revealed := make([]Script, len(spent))
+	for i := range input.TxIns {
+		var err error
+		if _, revealed[i], err = spendingScript(&input.TxIns[i], spent[i]); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		input.TxIns[i].Signature = ""
+		input.TxIns[i].Script = nil
+	}
+	tx, err := NewTransaction(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PartialTransaction{Tx: *tx, Inputs: make([]PartialInput, len(spent))}
+	for i, txOut := range spent {
+		p.Inputs[i] = PartialInput{Spent: txOut, Script: revealed[i], Signatures: make(map[string]string)}
+	}
+	return p, nil
+}
+
+// CreatePartialTransaction creates the unsigned transaction, looking up the outputs the inputs
+// spend in the UTXO set and the mempool.
+func (b *Blockchain) CreatePartialTransaction(input TransactionInput) (*PartialTransaction, error) {
+	spent, err := b.spentOutputs(input.TxIns)
+	if err != nil {
+		return nil, err
+	}
+	return NewPartialTransaction(input, spent)
+}
+
+// DecodePartialTransaction reads a partial transaction written by Encode.
+func DecodePartialTransaction(encoded string) (*PartialTransaction, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("partial transaction: %w", err)
+	}
+	var p PartialTransaction
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("partial transaction: %w", err)
+	}
+	if err := p.check(); err != nil {
+		return nil, fmt.Errorf("partial transaction: %w", err)
+	}
+	return &p, nil
+}
+
+// Encode returns the partial transaction as base64 encoded JSON, easy to pass around.
+func (p *PartialTransaction) Encode() (string, error) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// check makes sure the transaction matches its id and every signature is valid.
+func (p *PartialTransaction) check() error {
+	id, err := generateTransactionId(&p.Tx)
+	if err != nil {
+		return err
+	}
+	if id != p.Tx.Id {
+		return fmt.Errorf("transaction id %s does not match its content (%s)", p.Tx.Id, id)
+	}
+	if len(p.Inputs) != len(p.Tx.TxIns) {
+		return fmt.Errorf("%d inputs but %d spent outputs", len(p.Tx.TxIns), len(p.Inputs))
+	}
+
+	for i, txIn := range p.Tx.TxIns {
+		if txIn.Signature != "" || len(txIn.Script) > 0 {
+			return fmt.Errorf("input %d is already unlocked", i)
+		}
+		if _, _, err := spendingScript(&TxIn{Script: p.Inputs[i].reveal()}, p.Inputs[i].Spent); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+		if p.Inputs[i].Signatures == nil {
+			p.Inputs[i].Signatures = make(map[string]string)
+		}
+		for pubKey, signature := range p.Inputs[i].Signatures {
+			if !p.checkSig(i, pubKey, signature) {
+				return fmt.Errorf("invalid signature of %s on input %d", pubKey, i)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSig reports whether signature is a valid signature of the input at index by pubKey,
+// both base64 encoded.
+func (p *PartialTransaction) checkSig(index int, pubKey, signature string) bool {
+	rawKey, errKey := base64.StdEncoding.DecodeString(pubKey)
+	rawSignature, errSig := base64.StdEncoding.DecodeString(signature)
+	if errKey != nil || errSig != nil {
+		return false
+	}
+	c := &scriptContext{tx: &p.Tx, index: index, spent: p.Inputs[index].Spent}
+	return c.checkSig(rawSignature, rawKey)
+}
+
+// Sign adds the signature of privKey to every input it can unlock and returns how many it signed.
+func (p *PartialTransaction) Sign(privKey *ecdsa.PrivateKey) (int, error) {
+	pubKey, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	signed := 0
+	for i, input := range p.Inputs {
+		if !input.signedBy(pubKey) {
+			continue
+		}
+		signature, err := ecdsa.SignASN1(rand.Reader, privKey, SigHash(&p.Tx, i, input.Spent))
+		if err != nil {
+			return signed, err
+		}
+		input.Signatures[base64.StdEncoding.EncodeToString(pubKey)] = base64.StdEncoding.EncodeToString(signature)
+		signed++
+	}
+	if signed == 0 {
+		return 0, errors.New("the key can't sign any input of the transaction")
+	}
+	return signed, nil
+}
+
+// lockingScript is the script the signatures must satisfy: the one the input reveals if the
+// spent output pays a script address, the locking script of the output otherwise.
+func (in PartialInput) lockingScript() Script {
+	if _, ok := in.Spent.Script.ScriptHash(); ok {
+		return in.Script
+	}
+	return in.Spent.Script
+}
+
+// reveal is the push of the script the input reveals, which ends its unlocking script.
+func (in PartialInput) reveal() Script {
+	if _, ok := in.Spent.Script.ScriptHash(); ok {
+		return RevealScript(in.Script)
+	}
+	return nil
+}
+
+// signedBy reports whether a signature of pubKey, PKIX encoded, can help unlock the input.
+func (in PartialInput) signedBy(pubKey []byte) bool {
+	script := in.lockingScript()
+	if len(script) == 0 {
+		owner, err := decodePublicKey(in.Spent.Address)
+		return err == nil && bytes.Equal(owner, pubKey)
+	}
+	if pubKeyHash, ok := script.PubKeyHash(); ok {
+		return bytes.Equal(PubKeyHash(pubKey), pubKeyHash)
+	}
+	_, pubKeys, _ := script.Multisig()
+	return slices.ContainsFunc(pubKeys, func(k []byte) bool { return bytes.Equal(k, pubKey) })
+}
+
+// CombinePartialTransactions merges the signatures of copies of the same partial transaction,
+// each signed by different cosigners.
+func CombinePartialTransactions(copies ...*PartialTransaction) (*PartialTransaction, error) {
+	if len(copies) == 0 {
+		return nil, errors.New("nothing to combine")
+	}
+
+	combined := &PartialTransaction{Tx: copies[0].Tx, Inputs: make([]PartialInput, len(copies[0].Inputs))}
+	for i, input := range copies[0].Inputs {
+		combined.Inputs[i] = PartialInput{Spent: input.Spent, Script: input.Script, Signatures: maps.Clone(input.Signatures)}
+	}
+	for _, other := range copies[1:] {
+		if other.Tx.Id != combined.Tx.Id || len(other.Inputs) != len(combined.Inputs) {
+			return nil, fmt.Errorf("transaction %s can't be combined with %s", other.Tx.Id, combined.Tx.Id)
+		}
+		for i, input := range other.Inputs {
+			maps.Copy(combined.Inputs[i].Signatures, input.Signatures)
+		}
+	}
+	if err := combined.check(); err != nil {
+		return nil, err
+	}
+	return combined, nil
+}
+
+// Finalize builds the unlocking data of every input from the signatures and returns the
+// transaction ready to be broadcast. It fails with ErrIncompleteTransaction if an input
+// still lacks signatures.
+func (p *PartialTransaction) Finalize() (*Transaction, error) {
+	tx := p.Tx
+	tx.TxIns = slices.Clone(p.Tx.TxIns)
+
+	for i, input := range p.Inputs {
+		spent := input.Spent
+		signatures := make(map[string][]byte, len(input.Signatures))
+		for pubKey, signature := range input.Signatures {
+			if raw, err := base64.StdEncoding.DecodeString(signature); err == nil {
+				signatures[pubKey] = raw
+			}
+		}
+
+		switch m, pubKeys, isMultisig := input.lockingScript().Multisig(); {
+		case isMultisig:
+			unlocking, signed := Script{}, 0
+			for _, pubKey := range pubKeys {
+				if signature, ok := signatures[base64.StdEncoding.EncodeToString(pubKey)]; ok && signed < m {
+					unlocking = unlocking.addData(signature)
+					signed++
+				}
+			}
+			if signed == m {
+				tx.TxIns[i].Script = append(unlocking, input.reveal()...)
+			}
+		default:
+			for encoded, signature := range signatures {
+				pubKey, err := base64.StdEncoding.DecodeString(encoded)
+				if err != nil || !input.signedBy(pubKey) {
+					continue
+				}
+				if len(spent.Script) == 0 {
+					tx.TxIns[i].Signature = base64.StdEncoding.EncodeToString(signature)
+				} else {
+					tx.TxIns[i].Script = append(Script{}.addData(signature).addData(pubKey), input.reveal()...)
+				}
+			}
+		}
+
+		if tx.TxIns[i].Signature == "" && len(tx.TxIns[i].Script) == 0 {
+			return nil, fmt.Errorf("%w: input %d", ErrIncompleteTransaction, i)
+		}
+		if err := verifyInput(&tx, i, spent); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return &tx, nil
+}
@@ -0,0 +1,119 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPartialTransaction_CosignersSignSeparately(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	keys := make([]*ecdsa.PrivateKey, 3)
+	addresses := make([]string, 3)
+	for i := range keys {
+		keys[i], addresses[i] = newTestKey(t)
+	}
+	treasury, script, err := MultisigAddress(2, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, bc, 1, treasury)
+	_, payee := newTestKey(t)
+
+	input, err := bc.BuildPayment(Payment{From: treasury, To: payee, Amount: Coin, Fee: testFee, Script: script})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := bc.CreatePartialTransaction(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := created.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each cosigner gets a copy and signs it on their own machine.
+	copies := make([]*PartialTransaction, 2)
+	for i, key := range []*ecdsa.PrivateKey{keys[2], keys[0]} {
+		if copies[i], err = DecodePartialTransaction(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := copies[i].Sign(key); err != nil || n != 1 {
+			t.Fatalf("Sign() = %d, %v, want the input signed", n, err)
+		}
+	}
+	if _, err := copies[0].Sign(func() *ecdsa.PrivateKey { k, _ := newTestKey(t); return k }()); err == nil {
+		t.Error("Sign() with a key that is not a cosigner should fail")
+	}
+	if _, err := copies[0].Finalize(); !errors.Is(err, ErrIncompleteTransaction) {
+		t.Fatalf("Finalize() with one signature = %v, want ErrIncompleteTransaction", err)
+	}
+
+	combined, err := CombinePartialTransactions(copies...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := combined.Finalize()
+	if err != nil {
+		t.Fatalf("Finalize() = %v", err)
+	}
+	if err := bc.AppendTransaction(tx); err != nil {
+		t.Fatalf("AppendTransaction() of the finalized transaction = %v", err)
+	}
+}
+
+func TestPartialTransaction_OfflineSigner(t *testing.T) {
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+	mineBlocks(t, bc, 1, address)
+
+	input, err := bc.BuildPayment(Payment{From: address, To: "not an address", Amount: Coin})
+	if err == nil {
+		t.Fatal("BuildPayment() to an invalid address should fail")
+	}
+	input, err = bc.BuildPayment(Payment{From: address, To: address, Amount: 1000 * Coin})
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("BuildPayment() = %v, want ErrInsufficientFunds", err)
+	}
+	input, err = bc.BuildPayment(Payment{From: address, To: address, Amount: Coin, Fee: testFee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := bc.CreatePartialTransaction(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := created.Encode()
+
+	// The signer only sees the encoded transaction, which carries the outputs it spends.
+	offline, err := DecodePartialTransaction(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := offline.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+	signed, _ := offline.Encode()
+
+	// A signature over another amount than the one spent is caught as soon as it is decoded.
+	tampered := strings.Replace(signed, signed[len(signed)/2:len(signed)/2+4], "AAAA", 1)
+	if _, err := DecodePartialTransaction(tampered); err == nil {
+		t.Error("DecodePartialTransaction() of a tampered transaction should fail")
+	}
+
+	back, err := DecodePartialTransaction(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := back.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ValidateTransaction(tx); err != nil {
+		t.Errorf("ValidateTransaction() = %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// How many reorgs Reorgs remembers.
+const reorgHistorySize = 32
+
+var ErrNoCommonAncestor = errors.New("the chains do not share a genesis block")
+
+// ReorgEvent describes a switch to a chain that does not extend our tip.
+type ReorgEvent struct {
+	OldTip     string   `json:"old_tip"`
+	NewTip     string   `json:"new_tip"`
+	ForkHeight uint64   `json:"fork_height"` // Height of the last block both chains share
+	Depth      int      `json:"depth"`       // Blocks of ours that were disconnected
+	Connected  int      `json:"connected"`   // Blocks of the new chain that were connected
+	Restored   []string `json:"restored"`    // Transactions of the disconnected blocks put back in the mempool
+	Evicted    []string `json:"evicted"`     // Transactions dropped because they became invalid or conflicting
+	Timestamp  int64    `json:"timestamp"`
+}
+
+// SubscribeReorgs returns a channel receiving every reorg from now on.
+// A subscriber that falls behind misses events rather than blocking the chain.
+func (b *Blockchain) SubscribeReorgs() <-chan ReorgEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan ReorgEvent, 16)
+	b.reorgSubscribers = append(b.reorgSubscribers, ch)
+	return ch
+}
+
+// Reorgs returns the most recent reorgs, oldest first.
+func (b *Blockchain) Reorgs() []ReorgEvent {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return slices.Clone(b.reorgs)
+}
+
+// emitReorg records the event and sends it to the subscribers. b.mu must be held.
+func (b *Blockchain) emitReorg(event ReorgEvent) {
+	b.reorgs = append(b.reorgs, event)
+	if len(b.reorgs) > reorgHistorySize {
+		b.reorgs = b.reorgs[len(b.reorgs)-reorgHistorySize:]
+	}
+	for _, ch := range b.reorgSubscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// forkPoint returns the index of the last block both chains share, or -1 if they don't
+// even share the genesis block.
+func forkPoint(a, b []Block) int {
+	fork := -1
+	for i := 0; i < min(len(a), len(b)) && a[i].Hash == b[i].Hash; i++ {
+		fork = i
+	}
+	return fork
+}
+
+// reorganize makes target the active chain. Our blocks after the fork point are disconnected,
+// the ones of target are validated and connected, then the mempool is brought in line with
+// the new UTXO set. A ReorgEvent is emitted if any of our blocks was disconnected.
+// b.mu must be held.
+func (b *Blockchain) reorganize(target []Block) error {
+	fork := forkPoint(b.Chain, target)
+	if fork < 0 {
+		return ErrNoCommonAncestor
+	}
+
+	oldChain := b.Chain
+	disconnected, err := b.switchChain(target, fork)
+	if err != nil {
+		return err
+	}
+
+	if b.store != nil {
+		// The store is only rewritten when blocks were disconnected, a chain that grew is appended.
+		var err error
+		if len(disconnected) == 0 {
+			connected := make([]*Block, 0, len(b.Chain)-fork-1)
+			for i := fork + 1; i < len(b.Chain); i++ {
+				connected = append(connected, &b.Chain[i])
+			}
+			err = b.store.Append(connected...)
+		} else {
+			err = b.store.Rewrite(b.Chain)
+		}
+		if err != nil {
+			if _, undoErr := b.switchChain(oldChain, fork); undoErr != nil {
+				panic(fmt.Sprintf("Failed to restore the chain after a store error: %v\n", undoErr))
+			}
+			return err
+		}
+	}
+	for _, block := range b.Chain[fork+1:] {
+		b.tree.add(block)
+	}
+	b.signalTipChange()
+
+	restored, evicted := b.refreshMempool(disconnected, b.Chain[fork+1:])
+	if len(disconnected) > 0 {
+		b.emitReorg(ReorgEvent{
+			OldTip:     oldChain[len(oldChain)-1].Hash,
+			NewTip:     b.lastBlock().Hash,
+			ForkHeight: b.Chain[fork].Index,
+			Depth:      len(disconnected),
+			Connected:  len(b.Chain) - fork - 1,
+			Restored:   restored,
+			Evicted:    evicted,
+			Timestamp:  time.Now().Unix(),
+		})
+	}
+	return nil
+}
+
+// switchChain disconnects the blocks of the active chain after fork, newest first, and then
+// validates and connects the blocks of target after fork. It returns the disconnected blocks.
+// On error the active chain and the UTXO set are left as they were.
+func (b *Blockchain) switchChain(target []Block, fork int) ([]Block, error) {
+	current := b.Chain
+	if err := b.disconnectTo(current, fork); err != nil {
+		return nil, err
+	}
+
+	chain := slices.Clone(current[:fork+1])
+	now := time.Now()
+	for i := fork + 1; i < len(target); i++ {
+		block := target[i]
+		err := b.validateBlock(chain, &block, b.utxos, now)
+		if err == nil {
+			err = b.utxos.ConnectBlock(&block)
+		}
+		if err != nil {
+			// Put our own blocks back, they were valid a moment ago.
+			if undoErr := b.disconnectTo(chain, fork); undoErr != nil {
+				panic(fmt.Sprintf("Failed to undo a reorg: %v\n", undoErr))
+			}
+			for j := fork + 1; j < len(current); j++ {
+				if undoErr := b.utxos.ConnectBlock(&current[j]); undoErr != nil {
+					panic(fmt.Sprintf("Failed to undo a reorg: %v\n", undoErr))
+				}
+			}
+			return nil, err
+		}
+		chain = append(chain, block)
+	}
+
+	disconnected := slices.Clone(current[fork+1:])
+	slices.Reverse(disconnected)
+	b.Chain = chain
+	return disconnected, nil
+}
+
+// disconnectTo disconnects the blocks of chain after fork from the UTXO set, newest first.
+func (b *Blockchain) disconnectTo(chain []Block, fork int) error {
+	for i := len(chain) - 1; i > fork; i-- {
+		if err := b.utxos.DisconnectBlock(&chain[i]); err != nil {
+			for j := i + 1; j < len(chain); j++ {
+				b.utxos.ConnectBlock(&chain[j])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshMempool drops the pending transactions the connected blocks confirmed, tries to put back
+// the transactions of the disconnected blocks, oldest block first, and then drops the pending
+// transactions the new chain made invalid along with their descendants.
+// It returns the ids of the restored and of the evicted transactions.
+func (b *Blockchain) refreshMempool(disconnected, connected []Block) (restored, evicted []string) {
+	confirmed := make(map[string]bool)
+	for _, block := range connected {
+		for _, tx := range block.Transactions {
+			confirmed[tx.Id] = true
+		}
+		b.Mempool.Remove(transactionIds(block.Transactions)...)
+	}
+
+	restored, evicted = make([]string, 0), make([]string, 0)
+	// The disconnected transactions go back first, pending children may spend their outputs.
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions {
+			if tx.IsCoinbase() || confirmed[tx.Id] {
+				continue
+			}
+			if err := b.appendTransaction(&tx); err != nil {
+				evicted = append(evicted, tx.Id)
+				continue
+			}
+			restored = append(restored, tx.Id)
+		}
+	}
+
+	for _, entry := range b.Mempool.Entries() {
+		if _, ok := b.Mempool.Get(entry.Tx.Id); !ok {
+			continue // Went with an invalid parent
+		}
+		if _, err := checkTransaction(&entry.Tx, b.pendingView()); err != nil {
+			evicted = append(evicted, b.Mempool.RemoveWithDescendants(entry.Tx.Id)...)
+		}
+	}
+	return restored, evicted
+}
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestReorganize_RestoresAndEvictsTransactions(t *testing.T) {
+	node := NewBlockchain("")
+	node.Params.InitialDifficulty = 8
+	priv, address := newTestKey(t)
+
+	// Two coinbases both chains share.
+	for range 2 {
+		if err, _ := node.AppendBlockFor(address); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+	}
+	coinbase1, coinbase2 := node.Chain[1].Transactions[0], node.Chain[2].Transactions[0]
+
+	peer := NewBlockchain("")
+	peer.Params = node.Params
+	if err := peer.reorganize(node.Chain); err != nil {
+		t.Fatalf("Failed to copy the shared blocks: %v", err)
+	}
+
+	spend := func(bc *Blockchain, coinbase Transaction, to string) *Transaction {
+		tx, err := bc.SignTransaction(TransactionInput{
+			TxIns:  []TxIn{{TxOutId: coinbase.Id, TxOutIndex: 0}},
+			TxOuts: []TxOut{{Address: to, Amount: coinbase.TxOuts[0].Amount - Coin}},
+		}, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AppendTransaction(tx); err != nil {
+			t.Fatalf("Failed to append tx: %v", err)
+		}
+		return tx
+	}
+
+	// Our block confirms a payment, and another one waits in the mempool.
+	confirmed := spend(node, coinbase1, "bob-address")
+	if err, _ := node.AppendBlock(); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	pending := spend(node, coinbase2, "bob-address")
+
+	// The peer spends the pending coins elsewhere and gets ahead by one block.
+	spend(peer, coinbase2, "carol-address")
+	for range 2 {
+		if err, _ := peer.AppendBlock(); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+	}
+
+	events := node.SubscribeReorgs()
+	node.mu.Lock()
+	err := node.reorganize(peer.Chain)
+	node.mu.Unlock()
+	if err != nil {
+		t.Fatalf("reorganize() = %v", err)
+	}
+
+	if node.GetLastBlock().Hash != peer.GetLastBlock().Hash {
+		t.Fatal("the node should follow the peer's chain")
+	}
+	if err := node.CheckUTXOConsistency(); err != nil {
+		t.Errorf("UTXO set out of sync after the reorg: %v", err)
+	}
+
+	event := <-events
+	if event.Depth != 1 || event.Connected != 2 || event.ForkHeight != 2 {
+		t.Errorf("event = %+v, want depth 1, 2 connected blocks and a fork at #2", event)
+	}
+	if !slices.Equal(event.Restored, []string{confirmed.Id}) {
+		t.Errorf("restored %v, want the payment of the disconnected block %s", event.Restored, confirmed.Id)
+	}
+	if !slices.Equal(event.Evicted, []string{pending.Id}) {
+		t.Errorf("evicted %v, want the conflicting pending payment %s", event.Evicted, pending.Id)
+	}
+	if ids := transactionIds(node.Mempool.Transactions()); !slices.Equal(ids, []string{confirmed.Id}) {
+		t.Errorf("mempool holds %v, want only %s", ids, confirmed.Id)
+	}
+}
+
+func TestReorganize_KeepsChainOnInvalidBlock(t *testing.T) {
+	node := NewBlockchain("")
+	node.Params.InitialDifficulty = 4
+	for range 2 {
+		if err, _ := node.AppendBlock(); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+	}
+	tip := node.GetLastBlock().Hash
+
+	// Forks after block #1 with a block whose hash does not match its header.
+	broken := cloneChain(node.Chain)
+	broken[2].Hash = "forged-hash"
+
+	node.mu.Lock()
+	err := node.reorganize(broken)
+	node.mu.Unlock()
+	if err == nil {
+		t.Fatal("reorganize() should fail on an invalid block")
+	}
+	if node.GetLastBlock().Hash != tip || len(node.Chain) != 3 {
+		t.Error("a failed reorg should leave the chain as it was")
+	}
+	if err := node.CheckUTXOConsistency(); err != nil {
+		t.Errorf("UTXO set out of sync after a failed reorg: %v", err)
+	}
+}
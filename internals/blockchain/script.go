@@ -0,0 +1,673 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Script is a program of the small stack language outputs can be locked with. The input
+// spending such an output carries an unlocking script, which may only push data. It runs
+// first, then the locking script runs on the stack it left, and the input is valid if the
+// locking script ends with a true value on top of the stack. An output paying a script
+// address only holds the hash of its script, see PayToScriptHash.
+//
+// Scripts are byte code. Opcodes 0x01 to 0x4b push that many of the following bytes,
+// OpPushData1 and OpPushData2 push as many bytes as the following one or two bytes (big endian)
+// say. Any opcode not listed below makes the whole script invalid, even in a branch not taken.
+type Script []byte
+
+const (
+	OpFalse     byte = 0x00 // Pushes an empty value, which is false
+	OpPushData1 byte = 0x4c
+	OpPushData2 byte = 0x4d
+	Op1         byte = 0x51 // Op1 to Op16 push the number they are named after as a single byte
+	Op16        byte = 0x60
+
+	OpIf     byte = 0x63 // Runs the next branch if the top of the stack is true
+	OpNotIf  byte = 0x64 // Runs the next branch if the top of the stack is false
+	OpElse   byte = 0x67
+	OpEndIf  byte = 0x68
+	OpVerify byte = 0x69 // Fails unless the top of the stack is true
+	OpReturn byte = 0x6a // Always fails, the output can never be spent
+
+	OpDrop byte = 0x75
+	OpDup  byte = 0x76
+
+	OpEqual       byte = 0x87
+	OpEqualVerify byte = 0x88
+	OpSHA256      byte = 0xa8
+
+	OpCheckSig       byte = 0xac // Pops a public key and a signature, pushes whether the signature signs the input
+	OpCheckSigVerify byte = 0xad
+	// Pops n, n public keys, m and m signatures, pushes whether each signature signs the input
+	// with a different key. The signatures must be in the same order as their keys.
+	OpCheckMultiSig       byte = 0xae
+	OpCheckMultiSigVerify byte = 0xaf
+
+	// Fails unless the lock time of the transaction is at least the number on top of the
+	// stack, both being heights or both timestamps, see LockTimeThreshold. It leaves the
+	// number on the stack.
+	OpCheckLockTimeVerify byte = 0xb1
+)
+
+const OpTrue = Op1
+
+// The limits keep the cost of running a script bounded.
+const (
+	MaxScriptSize        = 10_000 // Bytes of a locking or unlocking script
+	MaxScriptElementSize = 520    // Bytes of a single stack element
+	maxScriptOps         = 201    // Opcodes run by a script, not counting the pushes
+	maxStackSize         = 1000
+	maxMultisigKeys      = 16
+)
+
+var opNames = map[byte]string{
+	OpFalse:          "OP_0",
+	OpPushData1:      "OP_PUSHDATA1",
+	OpPushData2:      "OP_PUSHDATA2",
+	OpIf:             "OP_IF",
+	OpNotIf:          "OP_NOTIF",
+	OpElse:           "OP_ELSE",
+	OpEndIf:          "OP_ENDIF",
+	OpVerify:         "OP_VERIFY",
+	OpReturn:         "OP_RETURN",
+	OpDrop:           "OP_DROP",
+	OpDup:            "OP_DUP",
+	OpEqual:          "OP_EQUAL",
+	OpEqualVerify:    "OP_EQUALVERIFY",
+	OpSHA256:         "OP_SHA256",
+	OpCheckSig:       "OP_CHECKSIG",
+	OpCheckSigVerify: "OP_CHECKSIGVERIFY",
+
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+}
+
+var ErrScriptFailed = errors.New("the script did not end with a true value on the stack")
+
+// scriptOp is a single instruction of a script. data is what a push opcode pushes.
+type scriptOp struct {
+	code byte
+	data []byte
+}
+
+func (op scriptOp) isPush() bool {
+	return op.code <= OpPushData2 || (op.code >= Op1 && op.code <= Op16)
+}
+
+// parse splits the script into its instructions.
+func (s Script) parse() ([]scriptOp, error) {
+	if len(s) > MaxScriptSize {
+		return nil, fmt.Errorf("script has %d bytes, the limit is %d", len(s), MaxScriptSize)
+	}
+
+	ops := make([]scriptOp, 0)
+	for pc := 0; pc < len(s); {
+		code := s[pc]
+		pc++
+
+		size := -1
+		switch {
+		case code < OpPushData1:
+			size = int(code)
+		case code == OpPushData1:
+			if pc+1 > len(s) {
+				return nil, errors.New("script ends in the middle of OP_PUSHDATA1")
+			}
+			size = int(s[pc])
+			pc++
+		case code == OpPushData2:
+			if pc+2 > len(s) {
+				return nil, errors.New("script ends in the middle of OP_PUSHDATA2")
+			}
+			size = int(binary.BigEndian.Uint16(s[pc:]))
+			pc += 2
+		case code >= Op1 && code <= Op16:
+		default:
+			if _, ok := opNames[code]; !ok {
+				return nil, fmt.Errorf("unknown opcode 0x%02x", code)
+			}
+		}
+
+		op := scriptOp{code: code}
+		if size >= 0 {
+			if pc+size > len(s) {
+				return nil, fmt.Errorf("script ends in the middle of a push of %d bytes", size)
+			}
+			op.data = s[pc : pc+size]
+			pc += size
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// IsPushOnly reports whether the script is valid and only pushes data.
+func (s Script) IsPushOnly() bool {
+	ops, err := s.parse()
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if !op.isPush() {
+			return false
+		}
+	}
+	return true
+}
+
+// String disassembles the script, pushed data is written in hex.
+func (s Script) String() string {
+	ops, err := s.parse()
+	if err != nil {
+		return fmt.Sprintf("[invalid script: %v]", err)
+	}
+
+	words := make([]string, len(ops))
+	for i, op := range ops {
+		switch {
+		case op.code >= Op1 && op.code <= Op16:
+			words[i] = fmt.Sprintf("OP_%d", op.code-Op1+1)
+		case op.code != OpFalse && op.isPush():
+			words[i] = hex.EncodeToString(op.data)
+		default:
+			words[i] = opNames[op.code]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Scripts are written in hex in JSON.
+func (s Script) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(s)), nil
+}
+
+func (s *Script) UnmarshalText(text []byte) error {
+	raw, err := hex.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("script: %w", err)
+	}
+	*s = raw
+	return nil
+}
+
+// addOp appends opcodes to the script.
+func (s Script) addOp(codes ...byte) Script {
+	return append(s, codes...)
+}
+
+// addData appends the shortest push of data to the script.
+func (s Script) addData(data []byte) Script {
+	switch {
+	case len(data) == 0:
+		return append(s, OpFalse)
+	case len(data) < int(OpPushData1):
+		s = append(s, byte(len(data)))
+	case len(data) <= 0xff:
+		s = append(s, OpPushData1, byte(len(data)))
+	default:
+		s = append(s, OpPushData2)
+		s = binary.BigEndian.AppendUint16(s, uint16(len(data)))
+	}
+	return append(s, data...)
+}
+
+// PubKeyHash is the SHA-256 of the PKIX encoding of a public key.
+func PubKeyHash(pubKey []byte) []byte {
+	sum := sha256.Sum256(pubKey)
+	return sum[:]
+}
+
+// PayToPubKeyHash returns the standard locking script, which is spent with a signature and the
+// public key hashing to pubKeyHash:
+//
+//	OP_DUP OP_SHA256 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHash(pubKeyHash []byte) Script {
+	return Script{}.addOp(OpDup, OpSHA256).addData(pubKeyHash).addOp(OpEqualVerify, OpCheckSig)
+}
+
+// PubKeyHash returns the public key hash a pay-to-public-key-hash script pays to.
+func (s Script) PubKeyHash() ([]byte, bool) {
+	if len(s) != 3+sha256.Size+2 || s[0] != OpDup || s[1] != OpSHA256 || s[2] != sha256.Size ||
+		s[3+sha256.Size] != OpEqualVerify || s[4+sha256.Size] != OpCheckSig {
+		return nil, false
+	}
+	return s[3 : 3+sha256.Size], true
+}
+
+// PayToScriptHash returns the locking script of the outputs paying a script address, which are
+// spent by revealing the script hashing to scriptHash:
+//
+//	OP_SHA256 <scriptHash> OP_EQUAL
+//
+// The unlocking script pushes what the script needs, then the script itself, see RevealScript.
+// The script is checked against the hash, then it runs on the rest of the unlocking script as
+// if it were the locking script.
+func PayToScriptHash(scriptHash []byte) Script {
+	return Script{}.addOp(OpSHA256).addData(scriptHash).addOp(OpEqual)
+}
+
+// ScriptHash returns the script hash a pay-to-script-hash script pays to.
+func (s Script) ScriptHash() ([]byte, bool) {
+	if len(s) != 2+sha256.Size+1 || s[0] != OpSHA256 || s[1] != sha256.Size || s[2+sha256.Size] != OpEqual {
+		return nil, false
+	}
+	return s[2 : 2+sha256.Size], true
+}
+
+// RevealScript returns the unlocking script an input spending a pay-to-script-hash output starts
+// with: the push of script. Signing the input puts the signatures in front of it.
+func RevealScript(script Script) Script {
+	return Script{}.addData(script)
+}
+
+// splitRevealed splits an unlocking script of a pay-to-script-hash output into the script it
+// reveals, its last push, and the pushes before it.
+func (s Script) splitRevealed() (Script, Script, error) {
+	ops, err := s.parse()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ops) == 0 || ops[len(ops)-1].data == nil {
+		return nil, nil, errors.New("the unlocking script does not reveal the script the output is locked to")
+	}
+
+	rest := Script{}
+	for _, op := range ops[:len(ops)-1] {
+		if op.data == nil {
+			rest = rest.addOp(op.code)
+		} else {
+			rest = rest.addData(op.data)
+		}
+	}
+	return Script(ops[len(ops)-1].data), rest, nil
+}
+
+// spendingScript returns the script the input must satisfy to spend spent: its locking script or,
+// for a pay-to-script-hash output, the script the input reveals, which is returned as revealed too.
+func spendingScript(txIn *TxIn, spent TxOut) (script, revealed Script, err error) {
+	scriptHash, ok := spent.Script.ScriptHash()
+	if !ok {
+		return spent.Script, nil, nil
+	}
+	if revealed, _, err = txIn.Script.splitRevealed(); err != nil {
+		return nil, nil, err
+	}
+	if sum := sha256.Sum256(revealed); !bytes.Equal(sum[:], scriptHash) {
+		return nil, nil, errors.New("the input reveals a script that does not match the hash of the output")
+	}
+	return revealed, revealed, nil
+}
+
+// PayToAddress returns the standard output paying amount to address: a pay-to-public-key-hash
+// script for the address of a key, or a raw public key, a pay-to-script-hash script for a script address.
+func PayToAddress(address string, amount Amount) (TxOut, error) {
+	script, err := addressScript(address)
+	if err != nil {
+		return TxOut{}, err
+	}
+	return TxOut{Amount: amount, Script: script}, nil
+}
+
+// scriptStack is the stack scripts run on, the top is the last element.
+type scriptStack [][]byte
+
+func (s *scriptStack) push(value []byte) {
+	*s = append(*s, value)
+}
+
+func (s *scriptStack) pop() ([]byte, error) {
+	if len(*s) == 0 {
+		return nil, errors.New("pop from an empty stack")
+	}
+	value := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return value, nil
+}
+
+func (s *scriptStack) popBool() (bool, error) {
+	value, err := s.pop()
+	return isTrue(value), err
+}
+
+// popNumber pops a number, see scriptNumber.
+func (s *scriptStack) popNumber() (int64, error) {
+	value, err := s.pop()
+	if err != nil {
+		return 0, err
+	}
+	return scriptNumber(value)
+}
+
+// Numbers on the stack are unsigned and big endian, at most 4 bytes long. The empty value is 0.
+func scriptNumber(value []byte) (int64, error) {
+	if len(value) > 4 {
+		return 0, fmt.Errorf("number of %d bytes, the limit is 4", len(value))
+	}
+	n := int64(0)
+	for _, b := range value {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+// addNumber appends the shortest push of n, which can't be negative.
+func (s Script) addNumber(n int64) Script {
+	switch {
+	case n == 0:
+		return s.addOp(OpFalse)
+	case n <= 16:
+		return s.addOp(Op1 + byte(n-1))
+	}
+	value := binary.BigEndian.AppendUint32(nil, uint32(n))
+	for len(value) > 1 && value[0] == 0 {
+		value = value[1:]
+	}
+	return s.addData(value)
+}
+
+// isTrue reports whether a stack element is true, which is any value with a non zero byte.
+func isTrue(value []byte) bool {
+	return slices.ContainsFunc(value, func(b byte) bool { return b != 0 })
+}
+
+func boolValue(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+// scriptContext is the input a script runs for: the input at index of tx, which spends spent.
+type scriptContext struct {
+	tx    *Transaction
+	index int
+	spent TxOut
+}
+
+// checkSig reports whether signature, DER encoded, signs the input with pubKey, PKIX encoded.
+func (c *scriptContext) checkSig(signature, pubKey []byte) bool {
+	key, err := x509.ParsePKIXPublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+	return ecdsa.VerifyASN1(ecdsaKey, SigHash(c.tx, c.index, c.spent), signature)
+}
+
+// checkMultiSig pops the operands of OP_CHECKMULTISIG and reports whether the signatures are valid.
+// It returns how many public keys there were, they count as opcodes run.
+func (c *scriptContext) checkMultiSig(stack *scriptStack) (bool, int, error) {
+	n, err := stack.popNumber()
+	if err != nil {
+		return false, 0, err
+	}
+	if n < 1 || n > maxMultisigKeys {
+		return false, 0, fmt.Errorf("%d public keys, it must be 1 to %d", n, maxMultisigKeys)
+	}
+	pubKeys := make([][]byte, n)
+	for i := range pubKeys {
+		if pubKeys[i], err = stack.pop(); err != nil {
+			return false, 0, err
+		}
+	}
+	// The first key pushed is the deepest one.
+	slices.Reverse(pubKeys)
+	for i, pubKey := range pubKeys {
+		if slices.ContainsFunc(pubKeys[:i], func(other []byte) bool { return bytes.Equal(other, pubKey) }) {
+			return false, 0, errors.New("the same public key is listed twice")
+		}
+	}
+
+	m, err := stack.popNumber()
+	if err != nil {
+		return false, 0, err
+	}
+	if m < 1 || m > n {
+		return false, 0, fmt.Errorf("%d signatures required out of %d keys", m, n)
+	}
+	signatures := make([][]byte, m)
+	for i := range signatures {
+		if signatures[i], err = stack.pop(); err != nil {
+			return false, 0, err
+		}
+	}
+	slices.Reverse(signatures)
+
+	// Each signature must match a later key than the previous one, so no key signs twice.
+	key := 0
+	for _, signature := range signatures {
+		for key < len(pubKeys) && !c.checkSig(signature, pubKeys[key]) {
+			key++
+		}
+		if key == len(pubKeys) {
+			return false, int(n), nil
+		}
+		key++
+	}
+	return true, int(n), nil
+}
+
+// run executes the script on stack.
+func (c *scriptContext) run(script Script, stack *scriptStack) error {
+	ops, err := script.parse()
+	if err != nil {
+		return err
+	}
+
+	branches := make([]bool, 0) // Whether each OP_IF we are in runs its current branch
+	executed := 0
+	for _, op := range ops {
+		if !op.isPush() {
+			if executed++; executed > maxScriptOps {
+				return fmt.Errorf("script runs more than %d opcodes", maxScriptOps)
+			}
+		}
+		running := !slices.Contains(branches, false)
+
+		switch op.code {
+		case OpIf, OpNotIf:
+			taken := false
+			if running {
+				if taken, err = stack.popBool(); err != nil {
+					return fmt.Errorf("%s: %w", opNames[op.code], err)
+				}
+				taken = taken == (op.code == OpIf)
+			}
+			branches = append(branches, taken)
+			continue
+		case OpElse:
+			if len(branches) == 0 {
+				return errors.New("OP_ELSE without OP_IF")
+			}
+			branches[len(branches)-1] = !branches[len(branches)-1]
+			continue
+		case OpEndIf:
+			if len(branches) == 0 {
+				return errors.New("OP_ENDIF without OP_IF")
+			}
+			branches = branches[:len(branches)-1]
+			continue
+		}
+		if !running {
+			continue
+		}
+
+		if op.code == OpCheckMultiSig || op.code == OpCheckMultiSigVerify {
+			valid, keys, err := c.checkMultiSig(stack)
+			if err != nil {
+				return fmt.Errorf("%s: %w", opNames[op.code], err)
+			}
+			if executed += keys; executed > maxScriptOps {
+				return fmt.Errorf("script runs more than %d opcodes", maxScriptOps)
+			}
+			if op.code == OpCheckMultiSigVerify && !valid {
+				return fmt.Errorf("%s failed", opNames[op.code])
+			}
+			if op.code == OpCheckMultiSig {
+				stack.push(boolValue(valid))
+			}
+		} else if err := c.step(op, stack); err != nil {
+			return err
+		}
+		if len(*stack) > maxStackSize {
+			return fmt.Errorf("the stack holds more than %d elements", maxStackSize)
+		}
+	}
+
+	if len(branches) > 0 {
+		return errors.New("OP_IF without OP_ENDIF")
+	}
+	return nil
+}
+
+// step runs a single opcode other than the flow control ones.
+func (c *scriptContext) step(op scriptOp, stack *scriptStack) error {
+	if op.isPush() {
+		value := op.data
+		if op.code >= Op1 && op.code <= Op16 {
+			value = []byte{op.code - Op1 + 1}
+		}
+		if len(value) > MaxScriptElementSize {
+			return fmt.Errorf("push of %d bytes, the limit is %d", len(value), MaxScriptElementSize)
+		}
+		stack.push(value)
+		return nil
+	}
+
+	name := opNames[op.code]
+	switch op.code {
+	case OpVerify:
+		ok, err := stack.popBool()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !ok {
+			return fmt.Errorf("%s failed", name)
+		}
+	case OpReturn:
+		return fmt.Errorf("%s makes the output unspendable", name)
+	case OpDrop:
+		if _, err := stack.pop(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	case OpDup:
+		value, err := stack.pop()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		stack.push(value)
+		stack.push(value)
+	case OpEqual, OpEqualVerify:
+		a, errA := stack.pop()
+		b, errB := stack.pop()
+		if err := errors.Join(errA, errB); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		equal := bytes.Equal(a, b)
+		if op.code == OpEqualVerify {
+			if !equal {
+				return fmt.Errorf("%s failed", name)
+			}
+			return nil
+		}
+		stack.push(boolValue(equal))
+	case OpSHA256:
+		value, err := stack.pop()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		sum := sha256.Sum256(value)
+		stack.push(sum[:])
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, errKey := stack.pop()
+		signature, errSig := stack.pop()
+		if err := errors.Join(errKey, errSig); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		valid := c.checkSig(signature, pubKey)
+		if op.code == OpCheckSigVerify {
+			if !valid {
+				return fmt.Errorf("%s failed", name)
+			}
+			return nil
+		}
+		stack.push(boolValue(valid))
+	case OpCheckLockTimeVerify:
+		value, err := stack.pop()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		stack.push(value)
+		n, err := scriptNumber(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		lockTime := uint32(n)
+		switch {
+		case c.tx.Version < TxVersionLockTime:
+			return fmt.Errorf("%s: version %d transactions have no lock time", name, c.tx.Version)
+		case (lockTime < LockTimeThreshold) != (c.tx.LockTime < LockTimeThreshold):
+			return fmt.Errorf("%s: compares a height with a timestamp", name)
+		case c.tx.LockTime < lockTime:
+			return fmt.Errorf("%s failed: the transaction is locked until %d, not %d", name, c.tx.LockTime, lockTime)
+		}
+	default:
+		return fmt.Errorf("unknown opcode 0x%02x", op.code)
+	}
+	return nil
+}
+
+// VerifyScript runs the unlocking script of the input at index of tx, then the locking script
+// of spent, the output that input spends, or the script it reveals if spent pays a script hash.
+func VerifyScript(tx *Transaction, index int, spent TxOut) error {
+	if index < 0 || index >= len(tx.TxIns) {
+		return fmt.Errorf("transaction %s has no input %d", tx.Id, index)
+	}
+	unlocking := tx.TxIns[index].Script
+	if !unlocking.IsPushOnly() {
+		return errors.New("the unlocking script may only push data")
+	}
+
+	c := &scriptContext{tx: tx, index: index, spent: spent}
+	locking := spent.Script
+	if _, ok := spent.Script.ScriptHash(); ok {
+		revealed, rest, err := unlocking.splitRevealed()
+		if err != nil {
+			return fmt.Errorf("unlocking script: %w", err)
+		}
+		// The revealed script is checked on its own, it may be longer than a stack element.
+		stack := scriptStack{revealed}
+		if err := c.run(spent.Script, &stack); err != nil {
+			return fmt.Errorf("locking script: %w", err)
+		}
+		if !isTrue(stack[len(stack)-1]) {
+			return errors.New("the unlocking script reveals a script that does not match the hash of the output")
+		}
+		unlocking, locking = rest, revealed
+	}
+
+	stack := make(scriptStack, 0)
+	if err := c.run(unlocking, &stack); err != nil {
+		return fmt.Errorf("unlocking script: %w", err)
+	}
+	if err := c.run(locking, &stack); err != nil {
+		return fmt.Errorf("locking script: %w", err)
+	}
+	if len(stack) == 0 || !isTrue(stack[len(stack)-1]) {
+		return ErrScriptFailed
+	}
+	return nil
+}
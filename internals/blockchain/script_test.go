@@ -0,0 +1,119 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestScript_PayToPubKeyHashAndLegacyOutputsAreSpentTogether(t *testing.T) {
+	bc := NewBlockchain("")
+	priv, address := newTestKey(t)
+	_, otherAddress := newTestKey(t)
+
+	standard, err := PayToAddress(address, 2*Coin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funding := &Transaction{
+		Version: CurrentTxVersion,
+		Id:      "funding-tx",
+		TxOuts:  []TxOut{{Address: address, Amount: 3 * Coin}, standard},
+	}
+	block := NewBlock(BlockInsert{Index: 1, PrevHash: bc.GetLastBlock().Hash, Transactions: []Transaction{*funding}})
+	appendTestBlock(t, bc, block)
+
+	if n := len(bc.GetUTXPoolByAddress(address)); n != 2 {
+		t.Fatalf("the address has %d outputs, want its legacy and its pay-to-public-key-hash output", n)
+	}
+
+	payment, err := PayToAddress(otherAddress, 5*Coin-testFee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := mustSign(t, bc, TransactionInput{
+		TxIns:  []TxIn{{TxOutId: "funding-tx", TxOutIndex: 0}, {TxOutId: "funding-tx", TxOutIndex: 1}},
+		TxOuts: []TxOut{payment},
+	}, priv)
+	if tx.TxIns[0].Signature == "" || len(tx.TxIns[0].Script) > 0 {
+		t.Error("the legacy output should be unlocked by a signature")
+	}
+	if len(tx.TxIns[1].Script) == 0 || tx.TxIns[1].Signature != "" {
+		t.Error("the pay-to-public-key-hash output should be unlocked by a script")
+	}
+	if err := bc.ValidateTransaction(tx); err != nil {
+		t.Fatalf("ValidateTransaction() = %v", err)
+	}
+
+	other, _ := newTestKey(t)
+	if err := SignInput(tx, 1, standard, other); err == nil {
+		t.Error("SignInput() with a key the output does not pay should fail")
+	}
+
+	// The pushed public key must hash to the one the output is locked to.
+	forged := *tx
+	forged.TxIns = append([]TxIn(nil), tx.TxIns...)
+	forged.TxIns[1].Script = Script{}.addData([]byte("signature")).addData([]byte("public key"))
+	if err := bc.ValidateTransaction(&forged); err == nil {
+		t.Error("an unlocking script with the wrong key should be rejected")
+	}
+}
+
+func TestScript_Interpreter(t *testing.T) {
+	secret := []byte("secret")
+	hash := sha256.Sum256(secret)
+	scriptHash := func(s Script) []byte {
+		sum := sha256.Sum256(s)
+		return sum[:]
+	}
+
+	tests := []struct {
+		name      string
+		unlocking Script
+		locking   Script
+		valid     bool
+	}{
+		{"true", nil, Script{}.addOp(OpTrue), true},
+		{"false", nil, Script{}.addOp(OpFalse), false},
+		{"empty", nil, nil, false},
+		{"hash preimage", Script{}.addData(secret), Script{}.addOp(OpSHA256).addData(hash[:]).addOp(OpEqualVerify, OpTrue), true},
+		{"wrong preimage", Script{}.addData([]byte("guess")), Script{}.addOp(OpSHA256).addData(hash[:]).addOp(OpEqualVerify, OpTrue), false},
+		{"script hash", RevealScript(Script{}.addOp(OpTrue)), PayToScriptHash(scriptHash(Script{}.addOp(OpTrue))), true},
+		{"script hash of another script", RevealScript(Script{}.addOp(OpTrue)), PayToScriptHash(hash[:]), false},
+		{"script hash runs the script", RevealScript(Script{}.addOp(OpFalse)), PayToScriptHash(scriptHash(Script{}.addOp(OpFalse))), false},
+		{"script hash without the script", nil, PayToScriptHash(hash[:]), false},
+		{"if branch", Script{}.addOp(OpTrue), Script{}.addOp(OpIf, OpTrue, OpElse, OpFalse, OpEndIf), true},
+		{"else branch", Script{}.addOp(OpFalse), Script{}.addOp(OpIf, OpTrue, OpElse, OpFalse, OpEndIf), false},
+		{"notif", Script{}.addOp(OpFalse), Script{}.addOp(OpNotIf, OpTrue, OpEndIf), true},
+		{"nested branch not taken", Script{}.addOp(OpFalse), Script{}.addOp(OpIf, OpIf, OpReturn, OpEndIf, OpElse, OpTrue, OpEndIf), true},
+		{"unbalanced if", Script{}.addOp(OpTrue), Script{}.addOp(OpIf, OpTrue), false},
+		{"verify", Script{}.addOp(OpFalse), Script{}.addOp(OpVerify, OpTrue), false},
+		{"return", nil, Script{}.addOp(OpTrue, OpReturn), false},
+		{"stack underflow", nil, Script{}.addOp(OpDup), false},
+		{"unknown opcode in a branch not taken", Script{}.addOp(OpFalse), Script{}.addOp(OpIf, 0xff, OpEndIf, OpTrue), false},
+		{"truncated push", nil, Script{0x05, 0x01}, false},
+		{"unlocking script runs an opcode", Script{}.addOp(OpTrue, OpDup), Script{}.addOp(OpDrop), false},
+		{"large push", Script{}.addData(make([]byte, 300)).addOp(OpTrue), Script{}.addOp(OpVerify, OpDrop, OpTrue), true},
+		{"element too large", Script{}.addData(make([]byte, MaxScriptElementSize+1)), Script{}.addOp(OpDrop, OpTrue), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &Transaction{Version: CurrentTxVersion, TxIns: []TxIn{{Script: tt.unlocking}}}
+			err := VerifyScript(tx, 0, TxOut{Amount: Coin, Script: tt.locking})
+			if (err == nil) != tt.valid {
+				t.Errorf("VerifyScript(%s | %s) = %v, want valid %v", tt.unlocking, tt.locking, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestScript_OpsAreBounded(t *testing.T) {
+	locking := Script{}.addOp(OpTrue)
+	for range maxScriptOps {
+		locking = locking.addOp(OpDup, OpDrop)
+	}
+	tx := &Transaction{Version: CurrentTxVersion, TxIns: []TxIn{{}}}
+	if err := VerifyScript(tx, 0, TxOut{Amount: Coin, Script: locking}); err == nil || errors.Is(err, ErrScriptFailed) {
+		t.Errorf("VerifyScript() of %d opcodes = %v, want the limit of %d hit", 2*maxScriptOps, err, maxScriptOps)
+	}
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/diegorezm/DBlockchain/internals/utils"
+)
+
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	priv, err := utils.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate keypair: %v", err)
+	}
+	keypair, err := utils.EncodeKeyPair(priv)
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+	return priv, keypair.PublicKey
+}
+
+func TestSigning_CombinesInputsOfDifferentKeys(t *testing.T) {
+	bc := NewBlockchain("")
+	alice, aliceAddress := newTestKey(t)
+	bob, bobAddress := newTestKey(t)
+
+	funding := &Transaction{
+		Version: CurrentTxVersion,
+		Id:      "funding-tx",
+		TxOuts:  []TxOut{{Address: aliceAddress, Amount: 3 * Coin}, {Address: bobAddress, Amount: 2 * Coin}},
+	}
+	block := NewBlock(BlockInsert{Index: 1, PrevHash: bc.GetLastBlock().Hash, Transactions: []Transaction{*funding}})
+	appendTestBlock(t, bc, block)
+
+	input := TransactionInput{
+		TxIns:  []TxIn{{TxOutId: "funding-tx", TxOutIndex: 0}, {TxOutId: "funding-tx", TxOutIndex: 1}},
+		TxOuts: []TxOut{{Address: "carol-address", Amount: 5 * Coin}},
+	}
+	if err := bc.ValidateTransaction(mustSign(t, bc, input, alice)); err == nil {
+		t.Fatal("Alice alone should not be able to spend Bob's output")
+	}
+
+	tx := mustSign(t, bc, input, alice, bob)
+	if err := bc.ValidateTransaction(tx); err != nil {
+		t.Fatalf("A transaction signed by both owners should be valid: %v", err)
+	}
+}
+
+func mustSign(t *testing.T, bc *Blockchain, input TransactionInput, keys ...*ecdsa.PrivateKey) *Transaction {
+	t.Helper()
+	// Signing fills in the signatures of the inputs, keep the caller's untouched.
+	input.TxIns = append([]TxIn(nil), input.TxIns...)
+	tx, err := bc.SignTransaction(input, keys...)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	return tx
+}
+
+func TestSigning_CommitsToSpentOutput(t *testing.T) {
+	priv, address := newTestKey(t)
+	spent := TxOut{Address: address, Amount: 5 * Coin}
+
+	tx, err := NewSignedTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: "funding-tx", TxOutIndex: 0}},
+		TxOuts: []TxOut{{Address: "bob-address", Amount: 4 * Coin}},
+	}, []TxOut{spent}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !VerifyInputSignature(tx, 0, spent, &priv.PublicKey) {
+		t.Fatal("the signature should verify against the output it was made for")
+	}
+	if VerifyInputSignature(tx, 0, TxOut{Address: address, Amount: 50 * Coin}, &priv.PublicKey) {
+		t.Error("the signature should not verify if the spent amount is different")
+	}
+
+	// Signatures are DER, so small r and s values keep their meaning.
+	raw, err := base64.StdEncoding.DecodeString(tx.TxIns[0].Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(raw, &sig); err != nil || len(rest) != 0 {
+		t.Errorf("signature is not DER encoded: %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	blockStoreFileName = "blocks.dat"
+	blockStoreMagic    = "DBLK"
+	blockStoreVersion  = uint32(2)
+	// Version 1 stores hold blocks hashed over gob, see ErrLegacyStore.
+	legacyStoreVersion = uint32(1)
+
+	// header: magic (4 bytes) + format version (4 bytes)
+	blockStoreHeaderSize = 8
+	// every record is: payload length (4 bytes) + payload + crc32 of the payload (4 bytes)
+	recordOverhead = 8
+)
+
+// ErrLegacyStore is returned when opening a store written before blocks were hashed over the
+// canonical encoding, see encoding.go, which includes every chain saved when amounts were floats.
+// Such a store can't be converted: the hashes and proofs of work of its blocks were computed over
+// gob, they would all have to be mined again. Moving its data directory aside and restarting the
+// node starts a new chain, which it syncs from its peers.
+var ErrLegacyStore = errors.New("store: the blocks were hashed with the gob encoding of version 1 stores and can't be loaded anymore")
+
+// BlockStore is an append-only file that keeps every block of the chain on disk.
+// A record is only considered written once its length, payload and checksum are all there,
+// so a block that was half written when the node crashed is detected and dropped on the next open.
+type BlockStore struct {
+	path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+// OpenBlockStore opens (or creates) the block store inside of dataDir.
+func OpenBlockStore(dataDir string) (*BlockStore, error) {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("store: failed to create data dir %s: %w", dataDir, err)
+	}
+
+	path := filepath.Join(dataDir, blockStoreFileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to open %s: %w", path, err)
+	}
+
+	s := &BlockStore{path: path, file: file}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("store: failed to stat %s: %w", path, err)
+	}
+
+	// A crash can also happen before the header itself was fully written.
+	if info.Size() < blockStoreHeaderSize {
+		if err := s.writeHeader(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// LoadBlocks reads every complete block from the store.
+// If the last record is incomplete or corrupted the file is truncated right before it,
+// a damaged record followed by valid ones is an error and the file is left untouched.
+func (s *BlockStore) LoadBlocks() ([]Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("store: seek failed: %w", err)
+	}
+	data, err := io.ReadAll(s.file)
+	if err != nil {
+		return nil, fmt.Errorf("store: read failed: %w", err)
+	}
+
+	if err := checkStoreHeader(data); err != nil {
+		if errors.Is(err, ErrLegacyStore) {
+			return nil, fmt.Errorf("%w, move %s aside and restart the node to sync the chain again from its peers", err, filepath.Dir(s.path))
+		}
+		return nil, err
+	}
+
+	blocks := make([]Block, 0)
+	offset := blockStoreHeaderSize
+
+	for offset < len(data) {
+		block, size, err := readRecord(data[offset:])
+		if err != nil {
+			// Only the last record can be torn by a crash, damage before good blocks means the
+			// file itself is corrupted and truncating it would throw those blocks away.
+			if next, ok := nextValidRecord(data, offset+1); ok {
+				return nil, fmt.Errorf("store: damaged record at offset %d of %s is followed by a valid one at offset %d: %w", offset, s.path, next, err)
+			}
+			log.Printf("store: dropping damaged record at offset %d of %s: %v", offset, s.path, err)
+			if err := s.truncate(int64(offset)); err != nil {
+				return nil, err
+			}
+			break
+		}
+		blocks = append(blocks, block)
+		offset += size
+	}
+
+	if _, err := s.file.Seek(0, io.SeekEnd); err != nil {
+		return nil, fmt.Errorf("store: seek failed: %w", err)
+	}
+	return blocks, nil
+}
+
+// Append writes blocks at the end of the store and flushes them to disk.
+// If they can't all be written the store is truncated back to where it was.
+func (s *BlockStore) Append(blocks ...*Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, block := range blocks {
+		record, err := encodeRecord(block)
+		if err != nil {
+			return err
+		}
+		buf.Write(record)
+	}
+
+	end, err := s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("store: seek failed: %w", err)
+	}
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		s.truncate(end)
+		return fmt.Errorf("store: failed to append block #%d: %w", blocks[0].Index, err)
+	}
+	return s.file.Sync()
+}
+
+// Rewrite replaces the whole content of the store with the given chain.
+// The new chain is written to a temporary file first and then renamed over the old one,
+// that way a crash in the middle of a rewrite leaves the previous chain untouched.
+func (s *BlockStore) Rewrite(chain []Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	buf.Write(storeHeader())
+	for i := range chain {
+		record, err := encodeRecord(&chain[i])
+		if err != nil {
+			return err
+		}
+		buf.Write(record)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := writeFileSync(tmpPath, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("store: failed to close %s: %w", s.path, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("store: failed to replace %s: %w", s.path, err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("store: failed to reopen %s: %w", s.path, err)
+	}
+	s.file = file
+	return nil
+}
+
+func (s *BlockStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func (s *BlockStore) writeHeader() error {
+	if err := s.file.Truncate(0); err != nil {
+		return fmt.Errorf("store: failed to reset %s: %w", s.path, err)
+	}
+	if _, err := s.file.WriteAt(storeHeader(), 0); err != nil {
+		return fmt.Errorf("store: failed to write header of %s: %w", s.path, err)
+	}
+	return s.file.Sync()
+}
+
+func (s *BlockStore) truncate(size int64) error {
+	if err := s.file.Truncate(size); err != nil {
+		return fmt.Errorf("store: failed to truncate %s: %w", s.path, err)
+	}
+	return s.file.Sync()
+}
+
+func storeHeader() []byte {
+	header := make([]byte, blockStoreHeaderSize)
+	copy(header, blockStoreMagic)
+	binary.BigEndian.PutUint32(header[4:], blockStoreVersion)
+	return header
+}
+
+func checkStoreHeader(data []byte) error {
+	if len(data) < blockStoreHeaderSize || string(data[:4]) != blockStoreMagic {
+		return errors.New("store: not a block store file")
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version == legacyStoreVersion {
+		return ErrLegacyStore
+	}
+	if version != blockStoreVersion {
+		return fmt.Errorf("store: unsupported store version %d", version)
+	}
+	return nil
+}
+
+func encodeRecord(block *Block) ([]byte, error) {
+	payload, err := json.Marshal(block)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to encode block #%d: %w", block.Index, err)
+	}
+
+	record := make([]byte, 4, len(payload)+recordOverhead)
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	record = append(record, payload...)
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(payload))
+	return record, nil
+}
+
+// readRecord decodes the record at the start of data and returns its total size in bytes.
+func readRecord(data []byte) (Block, int, error) {
+	var block Block
+
+	if len(data) < recordOverhead {
+		return block, 0, errors.New("incomplete record header")
+	}
+
+	payloadLen := int(binary.BigEndian.Uint32(data))
+	size := payloadLen + recordOverhead
+	if len(data) < size {
+		return block, 0, fmt.Errorf("record needs %d bytes, only %d available", size, len(data))
+	}
+
+	payload := data[4 : 4+payloadLen]
+	checksum := binary.BigEndian.Uint32(data[4+payloadLen:])
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return block, 0, errors.New("checksum mismatch")
+	}
+
+	if err := json.Unmarshal(payload, &block); err != nil {
+		return block, 0, fmt.Errorf("invalid block payload: %w", err)
+	}
+	return block, size, nil
+}
+
+// nextValidRecord returns the offset of the first valid record at or after from.
+func nextValidRecord(data []byte, from int) (int, bool) {
+	for offset := from; offset+recordOverhead <= len(data); offset++ {
+		if _, _, err := readRecord(data[offset:]); err == nil {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("store: failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("store: failed to write %s: %w", path, err)
+	}
+	return file.Sync()
+}
@@ -0,0 +1,186 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlockStore_PersistsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+
+	bc, err := OpenBlockchain("", dir)
+	if err != nil {
+		t.Fatalf("Failed to open blockchain: %v", err)
+	}
+	for range 2 {
+		if err, _ := bc.AppendBlock(); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+	}
+	lastHash := bc.GetLastBlock().Hash
+	bc.Close()
+
+	reopened, err := OpenBlockchain("", dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	defer reopened.Close()
+
+	if len(reopened.Chain) != 3 {
+		t.Fatalf("len(Chain) = %d, want %d", len(reopened.Chain), 3)
+	}
+	if reopened.GetLastBlock().Hash != lastHash {
+		t.Errorf("last hash = %s, want %s", reopened.GetLastBlock().Hash, lastHash)
+	}
+}
+
+func TestBlockStore_TruncatesPartialBlock(t *testing.T) {
+	dir := t.TempDir()
+
+	bc, err := OpenBlockchain("", dir)
+	if err != nil {
+		t.Fatalf("Failed to open blockchain: %v", err)
+	}
+	if err, _ := bc.AppendBlock(); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	bc.Close()
+
+	path := filepath.Join(dir, blockStoreFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goodSize := info.Size()
+
+	// Simulate a crash in the middle of writing the next block.
+	record, err := encodeRecord(&bc.Chain[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(record[:len(record)/2])
+	f.Close()
+
+	reopened, err := OpenBlockchain("", dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	defer reopened.Close()
+
+	if len(reopened.Chain) != 2 {
+		t.Fatalf("len(Chain) = %d, want %d", len(reopened.Chain), 2)
+	}
+
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != goodSize {
+		t.Errorf("store size = %d, want %d", info.Size(), goodSize)
+	}
+
+	if err, _ := reopened.AppendBlock(); err != nil {
+		t.Fatalf("Failed to mine block after recovery: %v", err)
+	}
+}
+
+func TestBlockStore_RefusesToDropBlocksAfterADamagedRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	bc, err := OpenBlockchain("", dir)
+	if err != nil {
+		t.Fatalf("Failed to open blockchain: %v", err)
+	}
+	for range 2 {
+		if err, _ := bc.AppendBlock(); err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+	}
+	bc.Close()
+
+	// Flip a byte in the payload of the first record, the blocks after it are still intact.
+	path := filepath.Join(dir, blockStoreFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[blockStoreHeaderSize+4] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenBlockchain("", dir); err == nil {
+		t.Fatal("OpenBlockchain() of a store damaged in the middle should fail")
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(data) {
+		t.Errorf("store size = %d, want %d", len(after), len(data))
+	}
+}
+
+func TestBlockStore_RefusesLegacyStores(t *testing.T) {
+	dir := t.TempDir()
+	header := binary.BigEndian.AppendUint32([]byte(blockStoreMagic), legacyStoreVersion)
+	if err := os.WriteFile(filepath.Join(dir, blockStoreFileName), header, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := OpenBlockchain("", dir)
+	if !errors.Is(err, ErrLegacyStore) {
+		t.Fatalf("OpenBlockchain() of a version 1 store = %v, want ErrLegacyStore", err)
+	}
+	if !strings.Contains(err.Error(), dir) {
+		t.Errorf("the error %q should name the data directory to move aside", err)
+	}
+}
+
+func TestBlockStore_AppendsBlocksExtendingTheTip(t *testing.T) {
+	dir := t.TempDir()
+	node, err := OpenBlockchain("", dir)
+	if err != nil {
+		t.Fatalf("Failed to open blockchain: %v", err)
+	}
+	peer := NewBlockchain("")
+	_, minerAddress := newTestKey(t)
+	mineBlocks(t, peer, 2, minerAddress)
+
+	path := filepath.Join(dir, blockStoreFileName)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range peer.Chain[1:] {
+		if _, err := node.ProcessBlock(block); err != nil {
+			t.Fatalf("ProcessBlock(#%d) = %v", block.Index, err)
+		}
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("the store was rewritten, the blocks extending the tip should be appended")
+	}
+	node.Close()
+
+	reopened, err := OpenBlockchain("", dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.GetLastBlock().Hash != peer.GetLastBlock().Hash {
+		t.Errorf("the reopened tip is #%d, want #%d", reopened.GetLastBlock().Index, peer.GetLastBlock().Index)
+	}
+}
@@ -5,123 +5,252 @@ import (
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
-	"encoding/gob"
+	"encoding/json"
 	"fmt"
-	"math/big"
+	"strings"
 )
 
+// An output is locked either by a script, or, for legacy outputs, by the public key in Address.
 type TxOut struct {
-	Address string  `json:"address"`
-	Amount  float64 `json:"amount"`
+	Address string `json:"address"`
+	Amount  Amount `json:"amount"`           // Base units, written in dcoins in JSON
+	Script  Script `json:"script,omitempty"` // Locking script, see Script. Version 5 and up.
 }
 
 type TxIn struct {
 	TxOutId    string `json:"tx_out_id"`
 	TxOutIndex int64  `json:"tx_out_index"`
 	Signature  string `json:"signature"`
+	Sequence   uint32 `json:"sequence,omitempty"` // Flags and relative lock of the input, see SequenceReplaceable and SequenceLockMask. Version 3 and up.
+	Script     Script `json:"script,omitempty"`   // Unlocking script, for outputs locked by a script. Version 5 and up.
 }
 
+// An input with this sequence flag lets the transaction be replaced in the mempool by one
+// paying a higher fee. Without it, the first transaction seen spending an output is final.
+const SequenceReplaceable uint32 = 1 << 31
+
 type UTXO struct {
 	TxId   string `json:"tx_id"`
 	Index  int64  `json:"index"`
 	Output TxOut  `json:"output"`
+	Height uint64 `json:"height"` // Block the output was created in, the next block for pending outputs
 }
 
+// The versions of the transaction encoding, see encoding.go. Versions 0 and 1 computed
+// ids over gob, whose output depends on the process, and are no longer valid.
+// Version 2 inputs have no sequence.
+const (
+	MinTxVersion      uint32 = 2
+	TxVersionSequence uint32 = 3 // Commits to the sequence of the inputs
+	TxVersionLockTime uint32 = 4 // Adds the lock time, and the relative locks in the sequences
+	TxVersionScripts  uint32 = 5 // Adds the locking scripts of the outputs
+	CurrentTxVersion         = TxVersionScripts
+)
+
 type Transaction struct {
+	Version  uint32  `json:"version"`
 	Id       string  `json:"id"`
 	TxIns    []TxIn  `json:"tx_ins"`
 	TxOuts   []TxOut `json:"tx_outs"`
-	IsSystem bool    `json:"is_system"`
+	LockTime uint32  `json:"lock_time,omitempty"` // Earliest block height or time it can be mined at, see LockTimeThreshold. Version 4 and up.
+	IsSystem bool    `json:"is_system"`           // Set on coinbases, but not committed to by the id, see IsCoinbase
 }
 
 type TransactionInput struct {
 	TxIns    []TxIn  `json:"tx_ins"`
 	TxOuts   []TxOut `json:"tx_outs"`
+	LockTime uint32  `json:"lock_time,omitempty"`
 	IsSystem bool    `json:"is_system"`
 }
 
-func generateTransactionId(txIns []TxIn, txOuts []TxOut) (string, error) {
-	var txInContentBuf, txOutContentBuf bytes.Buffer
-
-	txInEnc := gob.NewEncoder(&txInContentBuf)
-	err := txInEnc.Encode(txIns)
+// The input of a coinbase transaction doesn't spend anything, it points to this id
+// and uses the block height as index, which keeps every coinbase id unique.
+var coinbaseTxOutId = strings.Repeat("0", 64)
 
-	if err != nil {
-		return "", err
+// NewCoinbaseTransaction creates the transaction that pays the block reward to the miner.
+// If there is no miner address or nothing to pay the reward is simply not claimed.
+func NewCoinbaseTransaction(height uint64, minerAddress string, amount Amount) (*Transaction, error) {
+	txOuts := []TxOut{}
+	if minerAddress != "" && amount > 0 {
+		txOut, err := PayToAddress(minerAddress, amount)
+		if err != nil {
+			return nil, fmt.Errorf("miner address: %w", err)
+		}
+		txOuts = append(txOuts, txOut)
 	}
 
-	txOutEnc := gob.NewEncoder(&txOutContentBuf)
-	err = txOutEnc.Encode(txOuts)
+	return NewTransaction(TransactionInput{
+		TxIns:    []TxIn{{TxOutId: coinbaseTxOutId, TxOutIndex: int64(height)}},
+		TxOuts:   txOuts,
+		IsSystem: true,
+	})
+}
 
+// Size is the number of bytes the transaction takes when sent to other nodes or stored in a block.
+func (tx *Transaction) Size() int {
+	data, err := json.Marshal(tx)
 	if err != nil {
-		return "", err
+		panic(fmt.Sprintf("Failed to encode transaction %s: %v\n", tx.Id, err))
 	}
+	return len(data)
+}
 
-	var combined bytes.Buffer
-	combined.Write(txInContentBuf.Bytes())
-	combined.Write(txOutContentBuf.Bytes())
-
-	sum := sha256.Sum256(combined.Bytes())
-	return fmt.Sprintf("%x", sum[:]), nil
+// SignalsReplacement reports whether the transaction opted in to replace-by-fee.
+func (tx *Transaction) SignalsReplacement() bool {
+	for _, txIn := range tx.TxIns {
+		if txIn.Sequence&SequenceReplaceable != 0 {
+			return true
+		}
+	}
+	return false
 }
 
-func SignTransactionId(txId string, privKey *ecdsa.PrivateKey) (string, error) {
-	hash := sha256.Sum256([]byte(txId))
-	r, s, err := ecdsa.Sign(rand.Reader, privKey, hash[:])
+// IsCoinbase reports whether the transaction is a block reward. It goes by the shape of the
+// inputs, which the id commits to, and not by IsSystem, which anyone relaying a block can flip.
+func (tx *Transaction) IsCoinbase() bool {
+	return len(tx.TxIns) == 1 && tx.TxIns[0].TxOutId == coinbaseTxOutId
+}
 
-	if err != nil {
-		return "", err
+func transactionIds(txs []Transaction) []string {
+	ids := make([]string, len(txs))
+	for i, tx := range txs {
+		ids[i] = tx.Id
 	}
+	return ids
+}
 
-	signature := append(r.Bytes(), s.Bytes()...)
+// The id commits to the inputs and outputs, but not to the signatures, since those are made over them.
+func generateTransactionId(tx *Transaction) (string, error) {
+	if tx.Version < MinTxVersion || tx.Version > CurrentTxVersion {
+		return "", fmt.Errorf("unknown transaction version %d", tx.Version)
+	}
+	if tx.Version < TxVersionSequence {
+		for i, txIn := range tx.TxIns {
+			if txIn.Sequence != 0 {
+				return "", fmt.Errorf("input %d has a sequence, which version %d transactions don't commit to", i, tx.Version)
+			}
+		}
+	}
+	if tx.Version < TxVersionLockTime && tx.LockTime != 0 {
+		return "", fmt.Errorf("version %d transactions don't commit to a lock time", tx.Version)
+	}
+	if tx.Version < TxVersionScripts {
+		for i, txOut := range tx.TxOuts {
+			if len(txOut.Script) > 0 {
+				return "", fmt.Errorf("output %d has a script, which version %d transactions don't commit to", i, tx.Version)
+			}
+		}
+	}
+	return sha256Hex(encodeTransactionBody(tx)), nil
+}
 
-	return base64.StdEncoding.EncodeToString(signature), nil
+// SigHash is what the signature of the input at index signs: the whole transaction except the
+// signatures, plus the address, amount and locking script of the output that input spends.
+func SigHash(tx *Transaction, index int, spent TxOut) []byte {
+	sum := sha256.Sum256(encodeSigHash(tx, index, spent))
+	return sum[:]
 }
 
-func VerifyTransactionSignature(txId string, signature string, pubKey *ecdsa.PublicKey) bool {
-	hash := sha256.Sum256([]byte(txId))
-	sigBytes, err := base64.StdEncoding.DecodeString(signature)
+// SignInput signs the input at index, which spends the output spent, with privKey.
+// Signatures are DER encoded. A legacy output is unlocked by the base64 encoded signature,
+// a pay-to-public-key-hash output by an unlocking script pushing the signature and the public key.
+// A multisig output is unlocked by the signatures of its cosigners, each one signing in turn.
+// An input spending a pay-to-script-hash output must already reveal the script, see RevealScript.
+func SignInput(tx *Transaction, index int, spent TxOut, privKey *ecdsa.PrivateKey) error {
+	if index < 0 || index >= len(tx.TxIns) {
+		return fmt.Errorf("transaction %s has no input %d", tx.Id, index)
+	}
+	script, revealed, err := spendingScript(&tx.TxIns[index], spent)
 	if err != nil {
-		return false
+		return fmt.Errorf("input %d: %w", index, err)
+	}
+	if _, _, ok := script.Multisig(); ok {
+		return signMultisig(tx, index, spent, script, revealed, privKey)
+	}
+
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, SigHash(tx, index, spent))
+	if err != nil {
+		return err
+	}
+	if len(spent.Script) == 0 {
+		tx.TxIns[index].Signature = base64.StdEncoding.EncodeToString(signature)
+		return nil
 	}
 
-	r := new(big.Int).SetBytes(sigBytes[:len(sigBytes)/2])
-	s := new(big.Int).SetBytes(sigBytes[len(sigBytes)/2:])
-	return ecdsa.Verify(pubKey, hash[:], r, s)
+	pubKeyHash, ok := script.PubKeyHash()
+	if !ok {
+		return fmt.Errorf("input %d spends a script that is neither pay-to-public-key-hash nor multisig: %s", index, script)
+	}
+	pubKey, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(PubKeyHash(pubKey), pubKeyHash) {
+		return fmt.Errorf("input %d spends an output paying another key", index)
+	}
+	tx.TxIns[index].Script = Script{}.addData(signature).addData(pubKey)
+	if revealed != nil {
+		tx.TxIns[index].Script = tx.TxIns[index].Script.addData(revealed)
+	}
+	return nil
 }
 
-func NewTransaction(input TransactionInput) (*Transaction, error) {
-	id, err := generateTransactionId(input.TxIns, input.TxOuts)
+// VerifyInputSignature checks the signature of the input at index against the output it spends.
+func VerifyInputSignature(tx *Transaction, index int, spent TxOut, pubKey *ecdsa.PublicKey) bool {
+	if index < 0 || index >= len(tx.TxIns) {
+		return false
+	}
 
+	signature, err := base64.StdEncoding.DecodeString(tx.TxIns[index].Signature)
 	if err != nil {
-		return nil, err
+		return false
 	}
+	return ecdsa.VerifyASN1(pubKey, SigHash(tx, index, spent), signature)
+}
 
-	return &Transaction{
-		Id:       id,
+func NewTransaction(input TransactionInput) (*Transaction, error) {
+	tx := &Transaction{
+		Version:  CurrentTxVersion,
 		TxIns:    input.TxIns,
 		TxOuts:   input.TxOuts,
+		LockTime: input.LockTime,
 		IsSystem: input.IsSystem,
-	}, nil
+	}
+
+	id, err := generateTransactionId(tx)
+	if err != nil {
+		return nil, err
+	}
+	tx.Id = id
+	return tx, nil
 }
 
-func NewSignedTransaction(input TransactionInput, privKey *ecdsa.PrivateKey) (*Transaction, error) {
-	id, err := generateTransactionId(input.TxIns, input.TxOuts)
+// NewSignedTransaction creates the transaction and signs each input. spent holds the output
+// each input spends, in the same order. A single key signs every input, otherwise there must
+// be one key per input, so inputs owned by different keys can be combined.
+func NewSignedTransaction(input TransactionInput, spent []TxOut, privKeys ...*ecdsa.PrivateKey) (*Transaction, error) {
+	if len(spent) != len(input.TxIns) {
+		return nil, fmt.Errorf("%d inputs but %d spent outputs", len(input.TxIns), len(spent))
+	}
+	if len(privKeys) != 1 && len(privKeys) != len(input.TxIns) {
+		return nil, fmt.Errorf("%d inputs but %d private keys", len(input.TxIns), len(privKeys))
+	}
 
+	tx, err := NewTransaction(input)
 	if err != nil {
 		return nil, err
 	}
 
-	for i := range input.TxIns {
-		sig, _ := SignTransactionId(id, privKey)
-		input.TxIns[i].Signature = sig
+	for i := range tx.TxIns {
+		privKey := privKeys[0]
+		if len(privKeys) > 1 {
+			privKey = privKeys[i]
+		}
+		if err := SignInput(tx, i, spent[i], privKey); err != nil {
+			return nil, err
+		}
 	}
-
-	return &Transaction{
-		Id:       id,
-		TxIns:    input.TxIns,
-		TxOuts:   input.TxOuts,
-		IsSystem: input.IsSystem,
-	}, nil
+	return tx, nil
 }
@@ -0,0 +1,310 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// OutPoint points to a single output of a transaction.
+type OutPoint struct {
+	TxId  string `json:"tx_id"`
+	Index int64  `json:"index"`
+}
+
+func (o OutPoint) String() string {
+	return fmt.Sprintf("%s_%d", o.TxId, o.Index)
+}
+
+// UTXOSet is an index of every unspent output of the chain.
+// Instead of walking the whole chain it is updated each time a block is connected or disconnected.
+type UTXOSet struct {
+	utxos      map[OutPoint]UTXO
+	byAddress  map[string]map[OutPoint]struct{}
+	byCosigner map[string]map[OutPoint]struct{} // Multisig outputs, under each of their keys
+	paid       map[string]struct{}              // Every owner an output was ever created for, never cleared
+	// The outputs each block spent, keyed by block hash. They are needed to disconnect the block later.
+	undo map[string][]UTXO
+}
+
+func NewUTXOSet() *UTXOSet {
+	return &UTXOSet{
+		utxos:      make(map[OutPoint]UTXO),
+		byAddress:  make(map[string]map[OutPoint]struct{}),
+		byCosigner: make(map[string]map[OutPoint]struct{}),
+		paid:       make(map[string]struct{}),
+		undo:       make(map[string][]UTXO),
+	}
+}
+
+// buildUTXOSet connects every block of the chain into a new set.
+func buildUTXOSet(chain []Block) (*UTXOSet, error) {
+	set := NewUTXOSet()
+	for i := range chain {
+		if err := set.ConnectBlock(&chain[i]); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+func (s *UTXOSet) Get(op OutPoint) (UTXO, bool) {
+	u, ok := s.utxos[op]
+	return u, ok
+}
+
+func (s *UTXOSet) Len() int {
+	return len(s.utxos)
+}
+
+func (s *UTXOSet) All() []UTXO {
+	result := make([]UTXO, 0, len(s.utxos))
+	for _, u := range s.utxos {
+		result = append(result, u)
+	}
+	return result
+}
+
+func (s *UTXOSet) ByAddress(address string) []UTXO {
+	return s.lookup(s.byAddress[addressKey(address)])
+}
+
+// WasPaid reports whether an output paying address was ever connected, even if it was spent since.
+func (s *UTXOSet) WasPaid(address string) bool {
+	_, ok := s.paid[addressKey(address)]
+	return ok
+}
+
+// ByCosigner returns the multisig outputs the key of address is one of the keys of.
+func (s *UTXOSet) ByCosigner(address string) []UTXO {
+	return s.lookup(s.byCosigner[addressKey(address)])
+}
+
+func (s *UTXOSet) lookup(ops map[OutPoint]struct{}) []UTXO {
+	result := make([]UTXO, 0, len(ops))
+	for op := range ops {
+		result = append(result, s.utxos[op])
+	}
+	return result
+}
+
+// ConnectBlock spends the inputs and adds the outputs of every transaction in the block.
+// If one of the inputs does not exist the set is left exactly as it was before the call.
+func (s *UTXOSet) ConnectBlock(block *Block) error {
+	spent := make([]UTXO, 0)
+	created := make([]OutPoint, 0)
+
+	rollback := func() {
+		for _, op := range created {
+			s.remove(op)
+		}
+		for _, u := range spent {
+			s.add(u)
+		}
+	}
+
+	for _, tx := range block.Transactions {
+		for _, txIn := range spentInputs(&tx) {
+			op := OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex}
+			u, ok := s.utxos[op]
+			if !ok {
+				rollback()
+				return fmt.Errorf("utxo: block #%d spends missing output %s in tx %s", block.Index, op, tx.Id)
+			}
+			s.remove(op)
+			spent = append(spent, u)
+		}
+
+		for i, txOut := range tx.TxOuts {
+			u := UTXO{TxId: tx.Id, Index: int64(i), Output: txOut, Height: block.Index}
+			s.add(u)
+			created = append(created, OutPoint{TxId: tx.Id, Index: int64(i)})
+		}
+	}
+
+	s.undo[block.Hash] = spent
+	return nil
+}
+
+// DisconnectBlock reverts a block previously connected with ConnectBlock.
+// Only the tip of the chain can be disconnected.
+func (s *UTXOSet) DisconnectBlock(block *Block) error {
+	spent, ok := s.undo[block.Hash]
+	if !ok {
+		return fmt.Errorf("utxo: no undo data for block #%d (%s)", block.Index, block.Hash)
+	}
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		for j := range tx.TxOuts {
+			s.remove(OutPoint{TxId: tx.Id, Index: int64(j)})
+		}
+	}
+	for _, u := range spent {
+		s.add(u)
+	}
+
+	delete(s.undo, block.Hash)
+	return nil
+}
+
+func (s *UTXOSet) add(u UTXO) {
+	op := OutPoint{TxId: u.TxId, Index: u.Index}
+	s.utxos[op] = u
+
+	indexAdd(s.byAddress, u.Output.ownerKey(), op)
+	s.paid[u.Output.ownerKey()] = struct{}{}
+	for _, key := range u.Output.cosignerKeys() {
+		indexAdd(s.byCosigner, key, op)
+	}
+}
+
+func (s *UTXOSet) remove(op OutPoint) {
+	u, ok := s.utxos[op]
+	if !ok {
+		return
+	}
+	delete(s.utxos, op)
+
+	indexRemove(s.byAddress, u.Output.ownerKey(), op)
+	for _, key := range u.Output.cosignerKeys() {
+		indexRemove(s.byCosigner, key, op)
+	}
+}
+
+func indexAdd(index map[string]map[OutPoint]struct{}, key string, op OutPoint) {
+	if index[key] == nil {
+		index[key] = make(map[OutPoint]struct{})
+	}
+	index[key][op] = struct{}{}
+}
+
+func indexRemove(index map[string]map[OutPoint]struct{}, key string, op OutPoint) {
+	delete(index[key], op)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// spentInputs returns the inputs that really spend an output, which is none for a coinbase.
+func spentInputs(tx *Transaction) []TxIn {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	return tx.TxIns
+}
+
+// addressKey is what the outputs paying address are indexed by: the hash of its public key,
+// so the legacy and the pay-to-public-key-hash outputs of a key are found together, or the
+// hash of the script of a multisig or hash-time-locked address.
+// Public keys are often pasted with some extra whitespace around them.
+func addressKey(address string) string {
+	if script, err := addressScript(address); err == nil {
+		return scriptKey(script)
+	}
+	return strings.TrimSpace(address)
+}
+
+// scriptKey is the addressKey of the outputs locked by script.
+func scriptKey(script Script) string {
+	if pubKeyHash, ok := script.PubKeyHash(); ok {
+		return hex.EncodeToString(pubKeyHash)
+	}
+	// Outputs paying the hash of a script share the key of the outputs locked by the script itself.
+	if scriptHash, ok := script.ScriptHash(); ok {
+		return "script:" + hex.EncodeToString(scriptHash)
+	}
+	return "script:" + sha256Hex(script)
+}
+
+// ownerKey is the addressKey of whoever can spend the output.
+func (o TxOut) ownerKey() string {
+	if len(o.Script) == 0 {
+		return addressKey(o.Address)
+	}
+	return scriptKey(o.Script)
+}
+
+// cosignerKeys are the addressKeys of the keys of a multisig output.
+func (o TxOut) cosignerKeys() []string {
+	_, pubKeys, _ := o.Script.Multisig()
+	keys := make([]string, len(pubKeys))
+	for i, pubKey := range pubKeys {
+		keys[i] = hex.EncodeToString(PubKeyHash(pubKey))
+	}
+	return keys
+}
+
+// PaysTo reports whether address can spend the output with its key alone.
+func (o TxOut) PaysTo(address string) bool {
+	return o.ownerKey() == addressKey(address)
+}
+
+func (u UTXO) equal(other UTXO) bool {
+	return u.TxId == other.TxId && u.Index == other.Index && u.Height == other.Height &&
+		u.Output.Address == other.Output.Address && u.Output.Amount == other.Output.Amount &&
+		bytes.Equal(u.Output.Script, other.Output.Script)
+}
+
+// CheckConsistency compares the set with a full rescan of the chain
+// and reports the first difference it finds.
+func (s *UTXOSet) CheckConsistency(chain []Block) error {
+	expected := rescanUTXOPool(chain)
+
+	if len(expected) != len(s.utxos) {
+		return fmt.Errorf("utxo: index has %d outputs, rescan found %d", len(s.utxos), len(expected))
+	}
+
+	for op, want := range expected {
+		got, ok := s.utxos[op]
+		if !ok {
+			return fmt.Errorf("utxo: output %s is missing from the index", op)
+		}
+		if !got.equal(want) {
+			return fmt.Errorf("utxo: output %s differs, index has %v, rescan found %v", op, got, want)
+		}
+		if _, ok := s.byAddress[want.Output.ownerKey()][op]; !ok {
+			return fmt.Errorf("utxo: output %s is missing from the address index", op)
+		}
+		for _, key := range want.Output.cosignerKeys() {
+			if _, ok := s.byCosigner[key][op]; !ok {
+				return fmt.Errorf("utxo: output %s is missing from the cosigner index", op)
+			}
+		}
+	}
+
+	indexed := 0
+	for _, ops := range s.byAddress {
+		indexed += len(ops)
+	}
+	if indexed != len(expected) {
+		return fmt.Errorf("utxo: address index has %d outputs, rescan found %d", indexed, len(expected))
+	}
+	return nil
+}
+
+// rescanUTXOPool walks every transaction of the chain to find the unspent outputs.
+func rescanUTXOPool(chain []Block) map[OutPoint]UTXO {
+	utxos := make(map[OutPoint]UTXO)
+
+	for _, block := range chain {
+		for _, tx := range block.Transactions {
+			for _, txIn := range spentInputs(&tx) {
+				delete(utxos, OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex})
+			}
+
+			for i, txOut := range tx.TxOuts {
+				op := OutPoint{TxId: tx.Id, Index: int64(i)}
+				utxos[op] = UTXO{
+					TxId:   tx.Id,
+					Index:  int64(i),
+					Output: txOut,
+					Height: block.Index,
+				}
+			}
+		}
+	}
+
+	return utxos
+}
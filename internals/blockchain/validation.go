@@ -0,0 +1,472 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/diegorezm/DBlockchain/internals/utils"
+)
+
+// The consensus rules a block can break.
+const (
+	RuleGenesis     = "genesis"
+	RuleBlockHash   = "block-hash"
+	RuleVersion     = "version"
+	RuleMerkleRoot  = "merkle-root"
+	RulePrevHash    = "prev-hash"
+	RuleIndex       = "index"
+	RuleDifficulty  = "difficulty"
+	RuleProofOfWork = "proof-of-work"
+	RuleChainWork   = "chain-work"
+	RuleTimestamp   = "timestamp"
+	RuleLockTime    = "lock-time"
+	RuleBlockSize   = "block-size"
+	RuleDoubleSpend = "double-spend"
+	RuleCoinbase    = "coinbase"
+	RuleTransaction = "transaction"
+	RuleSignature   = "signature"
+)
+
+const (
+	// A block can't be older than the median timestamp of the blocks before it.
+	medianTimeSpan = 11
+	// How far into the future a block timestamp can be, to allow for clock drift between nodes.
+	maxFutureBlockTime = 2 * time.Hour
+)
+
+// ValidationError tells which block broke which consensus rule.
+type ValidationError struct {
+	BlockIndex uint64
+	BlockHash  string
+	Rule       string
+	Err        error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("block #%d (%s) broke the %s rule: %v", e.BlockIndex, e.BlockHash, e.Rule, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ValidationError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		BlockIndex uint64 `json:"block_index"`
+		BlockHash  string `json:"block_hash"`
+		Rule       string `json:"rule"`
+		Message    string `json:"message"`
+	}{e.BlockIndex, e.BlockHash, e.Rule, e.Err.Error()})
+}
+
+// corruptible reports whether the block may only have been damaged on its way to us. The block
+// hash covers neither the signatures, the unlocking scripts nor the chain work, and a body that
+// doesn't match the Merkle root is not the one the header was mined with, so another copy of the
+// block could still be valid.
+func (e *ValidationError) corruptible() bool {
+	switch e.Rule {
+	case RuleMerkleRoot, RuleChainWork, RuleBlockSize, RuleSignature:
+		return true
+	}
+	return false
+}
+
+func newValidationError(block *Block, rule string, format string, args ...any) *ValidationError {
+	return &ValidationError{
+		BlockIndex: block.Index,
+		BlockHash:  block.Hash,
+		Rule:       rule,
+		Err:        fmt.Errorf(format, args...),
+	}
+}
+
+// ValidateChain replays the whole chain, starting from the genesis block, through every consensus rule.
+// The returned error is a *ValidationError naming the first block that failed and why.
+func (b *Blockchain) ValidateChain(chain []Block) error {
+	_, err := b.validateChain(chain)
+	return err
+}
+
+// IsChainValid reports whether ValidateChain accepts the chain.
+func (b *Blockchain) IsChainValid(chain []Block) bool {
+	if err := b.ValidateChain(chain); err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+// validateChain validates the chain and returns the UTXO set built while replaying it.
+func (b *Blockchain) validateChain(chain []Block) (*UTXOSet, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("the chain is empty")
+	}
+
+	genesis := &chain[0]
+	expectedGenesis := generateGenesis()
+	if genesis.Index != 0 || genesis.Hash != expectedGenesis.Hash || hashBlock(genesis) != genesis.Hash ||
+		genesis.ChainWork != expectedGenesis.ChainWork || blockMerkleRoot(genesis.Transactions) != genesis.MerkleRoot {
+		return nil, newValidationError(genesis, RuleGenesis, "the genesis block does not match this network")
+	}
+
+	utxos := NewUTXOSet()
+	if err := utxos.ConnectBlock(genesis); err != nil {
+		return nil, newValidationError(genesis, RuleGenesis, "%v", err)
+	}
+
+	now := time.Now()
+	for i := 1; i < len(chain); i++ {
+		if err := b.validateBlock(chain[:i], &chain[i], utxos, now); err != nil {
+			return nil, err
+		}
+		if err := utxos.ConnectBlock(&chain[i]); err != nil {
+			return nil, newValidationError(&chain[i], RuleTransaction, "%v", err)
+		}
+	}
+	return utxos, nil
+}
+
+// validateBlock checks a block on top of chain, which holds every block up to its parent.
+// utxos must be the UTXO set at the parent block, it is not modified.
+func (b *Blockchain) validateBlock(chain []Block, block *Block, utxos utxoView, now time.Time) error {
+	if err := b.validateHeader(chain, block, now); err != nil {
+		return err
+	}
+
+	if size := blockSize(block); size > b.Params.MaxBlockSize {
+		return newValidationError(block, RuleBlockSize, "block has %d bytes of transactions, the limit is %d", size, b.Params.MaxBlockSize)
+	}
+
+	if err := checkBlockDoubleSpends(block); err != nil {
+		return newValidationError(block, RuleDoubleSpend, "%v", err)
+	}
+
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return newValidationError(block, RuleCoinbase, "the first transaction must be the coinbase")
+	}
+	coinbase := &block.Transactions[0]
+	if err := checkCoinbase(coinbase, block.Index); err != nil {
+		return newValidationError(block, RuleCoinbase, "%v", err)
+	}
+
+	view := newBlockView(utxos, block.Index)
+	mtp := medianTimePast(chain)
+	fees := Amount(0)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		for j := range tx.TxOuts {
+			if _, exists := view.Get(OutPoint{TxId: tx.Id, Index: int64(j)}); exists {
+				return newValidationError(block, RuleTransaction, "transaction %s overwrites an unspent output", tx.Id)
+			}
+		}
+		if i == 0 {
+			view.apply(tx)
+			continue
+		}
+
+		if tx.IsCoinbase() {
+			return newValidationError(block, RuleCoinbase, "transaction %s is a second coinbase", tx.Id)
+		}
+		fee, err := checkTransaction(tx, view)
+		if err != nil {
+			rule := RuleTransaction
+			if errors.As(err, new(unlockError)) {
+				rule = RuleSignature
+			}
+			return newValidationError(block, rule, "transaction %s: %v", tx.Id, err)
+		}
+		if err := checkLocks(tx, view, block.Index, mtp); err != nil {
+			return newValidationError(block, RuleLockTime, "transaction %s: %v", tx.Id, err)
+		}
+		if fees, err = AddAmounts(fees, fee); err != nil {
+			return newValidationError(block, RuleTransaction, "fees: %v", err)
+		}
+		view.apply(tx)
+	}
+
+	reward, err := sumOutputs(coinbase)
+	if err != nil {
+		return newValidationError(block, RuleCoinbase, "%v", err)
+	}
+	allowed, err := AddAmounts(b.Params.BlockSubsidy(block.Index), fees)
+	if err != nil {
+		return newValidationError(block, RuleCoinbase, "subsidy plus fees: %v", err)
+	}
+	if reward > allowed {
+		return newValidationError(block, RuleCoinbase, "coinbase pays %s, only %s (subsidy plus fees) is allowed", reward, allowed)
+	}
+
+	return nil
+}
+
+// checkBlockSanity checks the rules a block must follow whatever chain it is put on.
+// They are cheap, so they are checked before keeping a block whose parent is unknown.
+func checkBlockSanity(block *Block, params ConsensusParams) error {
+	if block.Version != CurrentBlockVersion {
+		return newValidationError(block, RuleVersion, "unknown block version %d", block.Version)
+	}
+
+	// Whatever its branch, a block can't be easier to mine than retargeting allows at its height,
+	// or orphans would cost nothing to make.
+	if floor := params.difficultyFloor(block.Index); block.Difficulty < floor {
+		return newValidationError(block, RuleDifficulty, "difficulty is %d, a block at this height needs at least %d", block.Difficulty, floor)
+	}
+
+	if computed := hashBlock(block); computed != block.Hash {
+		return newValidationError(block, RuleBlockHash, "stored hash %s, re-computed hash %s", block.Hash, computed)
+	}
+
+	if !hasProofOfWork(block.Hash, block.Difficulty) {
+		return newValidationError(block, RuleProofOfWork, "hash does not meet difficulty %d", block.Difficulty)
+	}
+
+	if computed := blockMerkleRoot(block.Transactions); computed != block.MerkleRoot {
+		return newValidationError(block, RuleMerkleRoot, "header commits to %s, the transactions give %s", block.MerkleRoot, computed)
+	}
+
+	// The Merkle root only commits to the ids, make sure the transactions are the ones they name.
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if id, err := generateTransactionId(tx); err != nil || id != tx.Id {
+			return newValidationError(block, RuleMerkleRoot, "transaction %s does not match its id", tx.Id)
+		}
+	}
+	return nil
+}
+
+// validateHeader checks the rules that depend on the blocks before block, but not on the
+// outputs its transactions spend. chain holds every block up to the parent of block.
+func (b *Blockchain) validateHeader(chain []Block, block *Block, now time.Time) error {
+	parent := &chain[len(chain)-1]
+
+	if err := checkBlockSanity(block, b.Params); err != nil {
+		return err
+	}
+
+	if expected := b.Params.nextDifficulty(chain); block.Difficulty != expected {
+		return newValidationError(block, RuleDifficulty, "difficulty is %d, expected %d", block.Difficulty, expected)
+	}
+
+	if expected := accumulateWork(parent, block.Difficulty); block.ChainWork != expected {
+		return newValidationError(block, RuleChainWork, "chain work is %s, expected %s", block.ChainWork, expected)
+	}
+
+	if block.PrevHash != parent.Hash {
+		return newValidationError(block, RulePrevHash, "expected %s (block #%d), got %s", parent.Hash, parent.Index, block.PrevHash)
+	}
+
+	if block.Index != parent.Index+1 {
+		return newValidationError(block, RuleIndex, "expected index %d", parent.Index+1)
+	}
+
+	if mtp := medianTimePast(chain); block.Timestamp < mtp {
+		return newValidationError(block, RuleTimestamp, "timestamp %d is before the median time past %d", block.Timestamp, mtp)
+	}
+	if limit := now.Add(maxFutureBlockTime).Unix(); block.Timestamp > limit {
+		return newValidationError(block, RuleTimestamp, "timestamp %d is too far in the future", block.Timestamp)
+	}
+	return nil
+}
+
+// checkCoinbase validates the shape of a coinbase, how much it pays is checked with the rest of the block.
+func checkCoinbase(coinbase *Transaction, height uint64) error {
+	if coinbase.Version < MinTxVersion || coinbase.Version > CurrentTxVersion {
+		return fmt.Errorf("unknown transaction version %d", coinbase.Version)
+	}
+	id, err := generateTransactionId(coinbase)
+	if err != nil {
+		return err
+	}
+	if id != coinbase.Id {
+		return fmt.Errorf("coinbase id %s does not match its content (%s)", coinbase.Id, id)
+	}
+
+	if coinbase.TxIns[0].TxOutIndex != int64(height) {
+		return fmt.Errorf("coinbase is for height %d, expected %d", coinbase.TxIns[0].TxOutIndex, height)
+	}
+
+	if _, err := sumOutputs(coinbase); err != nil {
+		return fmt.Errorf("coinbase %v", err)
+	}
+	return nil
+}
+
+// medianTimePast returns the median timestamp of the last blocks of the chain.
+func medianTimePast(chain []Block) int64 {
+	start := max(len(chain)-medianTimeSpan, 0)
+	timestamps := make([]int64, 0, len(chain)-start)
+	for _, block := range chain[start:] {
+		timestamps = append(timestamps, block.Timestamp)
+	}
+	slices.Sort(timestamps)
+	return timestamps[len(timestamps)/2]
+}
+
+// checkTransaction validates a transaction against the outputs visible in view
+// and returns the fee it pays, which is whatever the inputs hold beyond the outputs.
+func checkTransaction(tx *Transaction, view utxoView) (Amount, error) {
+	if tx.IsCoinbase() {
+		return 0, errors.New("coinbase transactions are only allowed as the first transaction of a block")
+	}
+	if tx.Version < MinTxVersion || tx.Version > CurrentTxVersion {
+		return 0, fmt.Errorf("unknown transaction version %d", tx.Version)
+	}
+
+	id, err := generateTransactionId(tx)
+	if err != nil {
+		return 0, err
+	}
+	if id != tx.Id {
+		return 0, fmt.Errorf("transaction id %s does not match its content (%s)", tx.Id, id)
+	}
+
+	if len(tx.TxOuts) == 0 {
+		return 0, errors.New("transaction has no outputs")
+	}
+
+	totalInput := Amount(0)
+	seen := make(map[OutPoint]bool, len(tx.TxIns))
+	spent := make([]UTXO, len(tx.TxIns))
+
+	for i, txIn := range tx.TxIns {
+		// 1. Find matching UTXO
+		op := OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex}
+		utxoKey := op.String()
+
+		if seen[op] {
+			return 0, fmt.Errorf("invalid TxIn: %s is spent twice by the same transaction", utxoKey)
+		}
+		seen[op] = true
+
+		utxo, ok := view.Get(op)
+		if !ok {
+			return 0, fmt.Errorf("invalid TxIn: no matching UTXO for %s", utxoKey)
+		}
+		spent[i] = utxo
+
+		if totalInput, err = AddAmounts(totalInput, utxo.Output.Amount); err != nil {
+			return 0, fmt.Errorf("inputs: %w", err)
+		}
+	}
+
+	// 2. Validate outputs
+	totalOutput, err := sumOutputs(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	// 3. Inputs must be ≥ outputs
+	if totalInput < totalOutput {
+		return 0, fmt.Errorf("input (%s) < output (%s)", totalInput, totalOutput)
+	}
+
+	// 4. Unlock the outputs. This comes last, the id doesn't cover the signatures and scripts,
+	// so what breaks a rule the id does cover is reported first.
+	for i, utxo := range spent {
+		if err := verifyInput(tx, i, utxo.Output); err != nil {
+			op := OutPoint{TxId: utxo.TxId, Index: utxo.Index}
+			return 0, fmt.Errorf("invalid TxIn %s: %w", op, unlockError{err})
+		}
+	}
+	return SubAmounts(totalInput, totalOutput)
+}
+
+// unlockError is returned by checkTransaction for an input that doesn't unlock the output it spends.
+type unlockError struct {
+	err error
+}
+
+func (e unlockError) Error() string { return e.err.Error() }
+func (e unlockError) Unwrap() error { return e.err }
+
+// verifyInput checks that the input at index of tx unlocks spent: a legacy output with a
+// signature of its public key, an output locked by a script with an unlocking script.
+func verifyInput(tx *Transaction, index int, spent TxOut) error {
+	txIn := &tx.TxIns[index]
+	if len(spent.Script) == 0 {
+		if len(txIn.Script) > 0 {
+			return errors.New("a legacy output is unlocked by a signature, not a script")
+		}
+		pubKey, err := utils.DecodePublicKey(spent.Address)
+		if err != nil {
+			return fmt.Errorf("invalid public key for address %s", spent.Address)
+		}
+		if !VerifyInputSignature(tx, index, spent, pubKey) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	if tx.Version < TxVersionScripts {
+		return fmt.Errorf("version %d transactions can't spend outputs locked by a script", tx.Version)
+	}
+	if txIn.Signature != "" {
+		return errors.New("an output locked by a script is unlocked by a script, not a signature")
+	}
+	return VerifyScript(tx, index, spent)
+}
+
+// sumOutputs checks that every output holds a positive amount no larger than MaxMoney
+// and returns their total.
+func sumOutputs(tx *Transaction) (Amount, error) {
+	total := Amount(0)
+	for i, txOut := range tx.TxOuts {
+		if txOut.Amount <= 0 {
+			return 0, fmt.Errorf("output %d has a non positive amount", i)
+		}
+		if txOut.Amount > MaxMoney {
+			return 0, fmt.Errorf("output %d holds more than %s", i, MaxMoney)
+		}
+
+		var err error
+		if total, err = AddAmounts(total, txOut.Amount); err != nil {
+			return 0, fmt.Errorf("outputs: %w", err)
+		}
+	}
+	return total, nil
+}
+
+// utxoView is anything transactions can be validated against.
+type utxoView interface {
+	Get(op OutPoint) (UTXO, bool)
+}
+
+// blockView sees the outputs of a base view plus the changes made by the
+// transactions applied on top of it, without modifying the base.
+type blockView struct {
+	base    utxoView
+	height  uint64 // Of the block the applied transactions are in
+	created map[OutPoint]UTXO
+	spent   map[OutPoint]bool
+}
+
+func newBlockView(base utxoView, height uint64) *blockView {
+	return &blockView{
+		base:    base,
+		height:  height,
+		created: make(map[OutPoint]UTXO),
+		spent:   make(map[OutPoint]bool),
+	}
+}
+
+func (v *blockView) Get(op OutPoint) (UTXO, bool) {
+	if v.spent[op] {
+		return UTXO{}, false
+	}
+	if u, ok := v.created[op]; ok {
+		return u, true
+	}
+	return v.base.Get(op)
+}
+
+func (v *blockView) apply(tx *Transaction) {
+	for _, txIn := range spentInputs(tx) {
+		v.spent[OutPoint{TxId: txIn.TxOutId, Index: txIn.TxOutIndex}] = true
+	}
+	for i, txOut := range tx.TxOuts {
+		v.created[OutPoint{TxId: tx.Id, Index: int64(i)}] = UTXO{TxId: tx.Id, Index: int64(i), Output: txOut, Height: v.height}
+	}
+}
@@ -0,0 +1,177 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/diegorezm/DBlockchain/internals/utils"
+)
+
+// validTestChain mines a small chain where a miner pays part of its reward to someone else.
+func validTestChain(t *testing.T) *Blockchain {
+	t.Helper()
+	bc := NewBlockchain("")
+	bc.Params.InitialDifficulty = 8 // keeps the tests fast
+
+	priv, err := utils.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate keypair: %v", err)
+	}
+	keypair, err := utils.EncodeKeyPair(priv)
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+
+	if err, _ := bc.AppendBlockFor(keypair.PublicKey); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	funding := bc.GetLastBlock().Transactions[0]
+
+	payment, err := bc.SignTransaction(TransactionInput{
+		TxIns:  []TxIn{{TxOutId: funding.Id, TxOutIndex: 0}},
+		TxOuts: []TxOut{{Address: "bob-address", Amount: 45 * Coin}},
+	}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendTransaction(payment); err != nil {
+		t.Fatalf("Failed to append payment: %v", err)
+	}
+	if err, _ := bc.AppendBlock(); err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	return bc
+}
+
+func expectRule(t *testing.T, err error, index uint64, rule string) {
+	t.Helper()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a *ValidationError, got %v", err)
+	}
+	if validationErr.Rule != rule || validationErr.BlockIndex != index {
+		t.Fatalf("got rule %q at block #%d, want %q at block #%d (%v)",
+			validationErr.Rule, validationErr.BlockIndex, rule, index, err)
+	}
+}
+
+func TestValidateChain_AcceptsMinedChain(t *testing.T) {
+	bc := validTestChain(t)
+	if err := bc.ValidateChain(bc.Chain); err != nil {
+		t.Fatalf("ValidateChain() = %v, want nil", err)
+	}
+}
+
+func TestValidateChain_ChecksLastBlock(t *testing.T) {
+	bc := validTestChain(t)
+	chain := cloneChain(bc.Chain)
+	chain[len(chain)-1].Nonce++
+
+	expectRule(t, bc.ValidateChain(chain), chain[len(chain)-1].Index, RuleBlockHash)
+}
+
+func TestValidateChain_RejectsMissingProofOfWork(t *testing.T) {
+	bc := validTestChain(t)
+	chain := cloneChain(bc.Chain)
+	last := &chain[len(chain)-1]
+	for {
+		last.Nonce++
+		last.Hash = hashBlock(last)
+		if !hasProofOfWork(last.Hash, last.Difficulty) {
+			break
+		}
+	}
+
+	expectRule(t, bc.ValidateChain(chain), last.Index, RuleProofOfWork)
+}
+
+func TestValidateChain_RejectsForeignGenesis(t *testing.T) {
+	bc := validTestChain(t)
+	chain := cloneChain(bc.Chain)
+	chain[0].Timestamp = 1
+	chain[0].Hash = hashBlock(&chain[0])
+
+	expectRule(t, bc.ValidateChain(chain), 0, RuleGenesis)
+}
+
+func TestValidateChain_RejectsInflatedOutputs(t *testing.T) {
+	bc := validTestChain(t)
+	chain := cloneChain(bc.Chain)
+	last := &chain[len(chain)-1]
+
+	// Spend more than the input holds, fix the id and re-mine so only the transaction rule breaks.
+	payment := &last.Transactions[1]
+	payment.TxOuts = []TxOut{{Address: "bob-address", Amount: 1000 * Coin}}
+	payment.Id, _ = generateTransactionId(payment)
+	last.MerkleRoot = blockMerkleRoot(last.Transactions)
+	bc.mine(last)
+
+	expectRule(t, bc.ValidateChain(chain), last.Index, RuleTransaction)
+}
+
+func TestValidateChain_RejectsFutureTimestamp(t *testing.T) {
+	bc := validTestChain(t)
+	chain := cloneChain(bc.Chain)
+	last := &chain[len(chain)-1]
+	last.Timestamp = time.Now().Add(3 * time.Hour).Unix()
+	bc.mine(last)
+
+	expectRule(t, bc.ValidateChain(chain), last.Index, RuleTimestamp)
+}
+
+func cloneChain(chain []Block) []Block {
+	cloned := make([]Block, len(chain))
+	for i, block := range chain {
+		cloned[i] = block
+		cloned[i].Transactions = make([]Transaction, len(block.Transactions))
+		for j, tx := range block.Transactions {
+			cloned[i].Transactions[j] = tx
+			cloned[i].Transactions[j].TxIns = append([]TxIn(nil), tx.TxIns...)
+			cloned[i].Transactions[j].TxOuts = append([]TxOut(nil), tx.TxOuts...)
+		}
+	}
+	return cloned
+}
+
+func TestValidateChain_RejectsOverpayingCoinbase(t *testing.T) {
+	bc := validTestChain(t)
+	chain := cloneChain(bc.Chain)
+	last := &chain[len(chain)-1]
+
+	// The payment leaves a fee of 5, so the coinbase can claim at most subsidy + 5.
+	allowed := bc.Params.BlockSubsidy(last.Index) + 5*Coin
+	_, greedyMiner := newTestKey(t)
+	coinbase, err := NewCoinbaseTransaction(last.Index, greedyMiner, allowed+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last.Transactions[0] = *coinbase
+	last.MerkleRoot = blockMerkleRoot(last.Transactions)
+	bc.mine(last)
+
+	expectRule(t, bc.ValidateChain(chain), last.Index, RuleCoinbase)
+}
+
+func TestValidateChain_RejectsSystemTransactions(t *testing.T) {
+	bc := validTestChain(t)
+	chain := cloneChain(bc.Chain)
+	last := &chain[len(chain)-1]
+
+	minted, err := NewTransaction(TransactionInput{
+		IsSystem: true,
+		TxOuts:   []TxOut{{Address: "bob-address", Amount: 1000 * Coin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AppendTransaction(minted); err == nil {
+		t.Error("The mempool should not accept system transactions")
+	}
+
+	last.Transactions = append(last.Transactions, *minted)
+	last.MerkleRoot = blockMerkleRoot(last.Transactions)
+	bc.mine(last)
+
+	expectRule(t, bc.ValidateChain(chain), last.Index, RuleTransaction)
+}
@@ -12,7 +12,7 @@ import "github.com/diegorezm/DBlockchain/internals/frontend/layout"
 import "github.com/diegorezm/DBlockchain/internals/frontend/components/icons"
 import "github.com/diegorezm/DBlockchain/internals/blockchain"
 
-func TransactionsPage(currentPublicKey string, mempool []blockchain.Transaction) templ.Component {
+func TransactionsPage(currentPublicKey string, mempool []blockchain.MempoolEntry, bumpable map[string]bool) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -57,7 +57,7 @@ func TransactionsPage(currentPublicKey string, mempool []blockchain.Transaction)
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			templ_7745c5c3_Err = TransactionsMempoolTable(mempool).Render(ctx, templ_7745c5c3_Buffer)
+			templ_7745c5c3_Err = TransactionsMempoolTable(mempool, bumpable).Render(ctx, templ_7745c5c3_Buffer)
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
@@ -117,7 +117,7 @@ func createTransactionDialog(publicKey string) templ.Component {
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "\" name=\"from\" hidden> <label class=\"label\">To</label> <textarea class=\"textarea textarea-bordered w-full mb-2\" required placeholder=\"Someone else public key...\" name=\"to\"></textarea> <label class=\"label\">Amount</label> <input type=\"number\" class=\"input input-bordered w-full mb-4\" required min=\"1\" name=\"amount\"><div class=\"modal-action\"><button class=\"btn btn-md btn-outline\" type=\"button\" onclick=\"create_transaction_modal.close()\">Cancel</button> <button class=\"btn btn-md btn-primary\" type=\"submit\">Confirm</button></div></form><div id=\"alert-error\"></div><div id=\"alert-warning\"></div></div><form method=\"dialog\" class=\"modal-backdrop\"><button>close</button></form></dialog>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "\" name=\"from\" hidden> <label class=\"label\">To</label> <textarea class=\"textarea textarea-bordered w-full mb-2\" required placeholder=\"Someone else's address...\" name=\"to\"></textarea> <label class=\"label\">Amount</label> <input type=\"number\" class=\"input input-bordered w-full mb-2\" required min=\"0.00000001\" step=\"0.00000001\" name=\"amount\"> <label class=\"label\">Fee</label> <input type=\"number\" class=\"input input-bordered w-full mb-2\" min=\"0\" step=\"0.00000001\" value=\"0\" name=\"fee\"> <label class=\"label mb-4\"><input type=\"checkbox\" class=\"checkbox checkbox-sm\" name=\"replaceable\" value=\"true\" checked> Allow bumping the fee while the transaction is pending</label><div class=\"modal-action\"><button class=\"btn btn-md btn-outline\" type=\"button\" onclick=\"create_transaction_modal.close()\">Cancel</button> <button class=\"btn btn-md btn-primary\" type=\"submit\">Confirm</button></div></form><div id=\"alert-error\"></div><div id=\"alert-warning\"></div></div><form method=\"dialog\" class=\"modal-backdrop\"><button>close</button></form></dialog>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
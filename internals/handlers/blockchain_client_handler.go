@@ -1,20 +1,36 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
 	"time"
 
 	"github.com/diegorezm/DBlockchain/internals/blockchain"
 	"github.com/diegorezm/DBlockchain/internals/frontend/components/alerts"
 	"github.com/diegorezm/DBlockchain/internals/frontend/pages/blocks_page"
+	"github.com/diegorezm/DBlockchain/internals/frontend/pages/transactions_page"
 	"github.com/diegorezm/DBlockchain/internals/utils"
 	webutils "github.com/diegorezm/DBlockchain/internals/web_utils"
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/schema"
 )
 
-var decoder = schema.NewDecoder()
+var decoder = newFormDecoder()
+
+func newFormDecoder() *schema.Decoder {
+	d := schema.NewDecoder()
+	// Amounts are typed in dcoins and parsed exactly, an invalid one fails the decoding.
+	d.RegisterConverter(blockchain.Amount(0), func(value string) reflect.Value {
+		amount, err := blockchain.ParseAmount(value)
+		if err != nil {
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(amount)
+	})
+	return d
+}
 
 type BlockchainClientHandler struct {
 	blockchain *blockchain.Blockchain
@@ -27,27 +43,38 @@ func NewBlockchainClientHandler(bl *blockchain.Blockchain) *BlockchainClientHand
 }
 
 func (bc *BlockchainClientHandler) GetChain(w http.ResponseWriter, r *http.Request) {
-	chain := bc.blockchain.Chain
+	chain := bc.blockchain.GetChain()
 	webutils.WriteJSON(w, 200, chain, "Blocks fetched")
 }
 
+// Mine mines a new block. The reward goes to the wallet saved in the browser, or to the node's
+// miner address when there is none.
 func (bc *BlockchainClientHandler) Mine(w http.ResponseWriter, r *http.Request) {
-	if err, _ := bc.blockchain.AppendBlock(); err != nil {
+	minerAddress := getPublicKeyFromCookies(r)
+	if minerAddress == "" {
+		minerAddress = bc.blockchain.MinerAddress
+	}
+
+	// Mining stops as soon as the client goes away.
+	if err, _ := bc.blockchain.AppendBlockContext(r.Context(), minerAddress); err != nil {
 		webutils.WriteInternalServerError(w, fmt.Sprintf("Failed to mine new block: %v", err))
 		return
 	}
 	time.Sleep(time.Duration(5000))
-	chain := bc.blockchain.Chain
+	chain := bc.blockchain.GetChain()
 	table := blocks_page.BlocksTable(chain)
 	w.Header().Set("Content-Type", "text/html")
 	table.Render(r.Context(), w)
 }
 
 type appendTransactionInput struct {
-	PrivateKey string  `schema:"private_key"`
-	From       string  `schema:"from"`
-	To         string  `schema:"to"`
-	Amount     float64 `schema:"amount"`
+	PrivateKey string            `schema:"private_key"`
+	From       string            `schema:"from"`
+	To         string            `schema:"to"`
+	Amount     blockchain.Amount `schema:"amount"`
+	Fee        blockchain.Amount `schema:"fee"`
+	// Lets the sender bump the fee later, see blockchain.SequenceReplaceable.
+	Replaceable bool `schema:"replaceable"`
 }
 
 func (bc *BlockchainClientHandler) AppendTransaction(w http.ResponseWriter, r *http.Request) {
@@ -63,48 +90,36 @@ func (bc *BlockchainClientHandler) AppendTransaction(w http.ResponseWriter, r *h
 		return
 	}
 
-	privKey, err := utils.DecodePrivateKey(input.PrivateKey)
-	if err != nil {
-		webutils.WriteTempl(w, http.StatusBadRequest, alerts.AlertError("Failed to parse private key"), r.Context())
-		return
+	payment := blockchain.Payment{
+		From:        input.From,
+		To:          input.To,
+		Amount:      input.Amount,
+		Fee:         input.Fee,
+		Replaceable: input.Replaceable,
 	}
 
-	availableUTXOs := bc.blockchain.GetUTXPoolByAddress(input.From)
-
-	var txIns []blockchain.TxIn
-	var totalInput float64
-	for _, utxo := range availableUTXOs {
-		txIns = append(txIns, blockchain.TxIn{
-			TxOutId:    utxo.TxId,
-			TxOutIndex: utxo.Index,
-		})
-		totalInput += utxo.Output.Amount
-		if totalInput >= input.Amount {
-			break
+	var signedTx *blockchain.Transaction
+	// The extended private key of an HD wallet pays from all its addresses, with the change
+	// going to a fresh one.
+	if account, parseErr := utils.ParseExtendedKey(input.PrivateKey); parseErr == nil && account.IsPrivate() {
+		signedTx, err = bc.blockchain.SignHDPayment(account, payment)
+	} else {
+		privKey, decodeErr := utils.DecodePrivateKey(input.PrivateKey)
+		if decodeErr != nil {
+			webutils.WriteTempl(w, http.StatusBadRequest, alerts.AlertError("Failed to parse private key"), r.Context())
+			return
+		}
+		var txInput blockchain.TransactionInput
+		if txInput, err = bc.blockchain.BuildPayment(payment); err == nil {
+			signedTx, err = bc.blockchain.SignTransaction(txInput, privKey)
 		}
 	}
-
-	if totalInput < input.Amount {
+	if errors.Is(err, blockchain.ErrInsufficientFunds) {
 		webutils.WriteTempl(w, http.StatusInternalServerError, alerts.AlertWarning("Insufficient funds."), r.Context())
 		return
 	}
-
-	txOuts := []blockchain.TxOut{
-		{Address: input.To, Amount: input.Amount},
-	}
-	if change := totalInput - input.Amount; change > 0 {
-		txOuts = append(txOuts, blockchain.TxOut{Address: input.From, Amount: change})
-	}
-
-	txInput := blockchain.TransactionInput{
-		TxIns:    txIns,
-		TxOuts:   txOuts,
-		IsSystem: false,
-	}
-
-	signedTx, err := blockchain.NewSignedTransaction(txInput, privKey)
 	if err != nil {
-		webutils.WriteTempl(w, http.StatusInternalServerError, alerts.AlertError("Signing failed."), r.Context())
+		webutils.WriteTempl(w, http.StatusBadRequest, alerts.AlertError(fmt.Sprintf("Invalid payment: %v", err)), r.Context())
 		return
 	}
 
@@ -116,67 +131,61 @@ func (bc *BlockchainClientHandler) AppendTransaction(w http.ResponseWriter, r *h
 	webutils.WriteTempl(w, http.StatusOK, alerts.AlertInfo("Transaction added to pool."), r.Context())
 }
 
-type buyCoinsRequest struct {
-	PrivateKey string  `schema:"private_key"`
-	To         string  `schema:"to"`
-	Amount     float32 `schema:"amount"`
+type bumpFeeInput struct {
+	PrivateKey string            `schema:"private_key"`
+	TxId       string            `schema:"tx_id"`
+	Fee        blockchain.Amount `schema:"fee"`
 }
 
-func (bc *BlockchainClientHandler) BuyCoins(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-
-	if err != nil {
-		webutils.WriteBadRequest(w, "Could not parse your request.")
+// BumpFee replaces one of the user's pending transactions with one paying a higher fee.
+func (bc *BlockchainClientHandler) BumpFee(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		webutils.WriteTempl(w, http.StatusBadRequest, transactions_page.BumpFeeAlert(alerts.AlertError("Could not parse your request.")), r.Context())
 		return
 	}
 
-	var input buyCoinsRequest
-	err = decoder.Decode(&input, r.PostForm)
-
-	if err != nil {
-		webutils.WriteTempl(w, http.StatusBadRequest, alerts.AlertError(fmt.Sprintf("Failed to parse your request: %v", err)), r.Context())
+	var input bumpFeeInput
+	if err := decoder.Decode(&input, r.PostForm); err != nil {
+		webutils.WriteTempl(w, http.StatusBadRequest, transactions_page.BumpFeeAlert(alerts.AlertError("Could not parse your request.")), r.Context())
 		return
 	}
 
 	privKey, err := utils.DecodePrivateKey(input.PrivateKey)
 	if err != nil {
-		webutils.WriteTempl(w, http.StatusBadRequest, alerts.AlertError(fmt.Sprintf("Failed to parse your private key: %v", err)), r.Context())
+		webutils.WriteTempl(w, http.StatusBadRequest, transactions_page.BumpFeeAlert(alerts.AlertError("Failed to parse private key")), r.Context())
 		return
 	}
 
-	if input.Amount <= 0 {
-		webutils.WriteTempl(w, http.StatusBadRequest, alerts.AlertError("The amount should be greater than 0."), r.Context())
-		return
-	}
-
-	if input.To == "" {
-		webutils.WriteTempl(w, http.StatusBadRequest, alerts.AlertError("Not a valid address."), r.Context())
+	replacement, err := bc.blockchain.BumpFee(input.TxId, input.Fee, privKey)
+	if err != nil {
+		alert := alerts.AlertError(fmt.Sprintf("Failed to bump the fee: %v", err))
+		webutils.WriteTempl(w, http.StatusBadRequest, transactions_page.BumpFeeAlert(alert), r.Context())
 		return
 	}
 
-	txInput := blockchain.TransactionInput{
-		IsSystem: true,
-		TxIns:    []blockchain.TxIn{},
-		TxOuts: []blockchain.TxOut{
-			{
-				Address: input.To,
-				Amount:  float64(input.Amount),
-			},
-		},
-	}
+	alert := alerts.AlertInfo(fmt.Sprintf("Transaction replaced by %s.", replacement.Id))
+	webutils.WriteTempl(w, http.StatusOK, transactions_page.BumpFeeAlert(alert), r.Context())
+}
 
-	signedTx, err := blockchain.NewSignedTransaction(txInput, privKey)
+// GetBlockTemplate shows which transactions the next block mined by this node would include.
+func (bc *BlockchainClientHandler) GetBlockTemplate(w http.ResponseWriter, r *http.Request) {
+	template, err := bc.blockchain.NewBlockTemplate(bc.blockchain.MinerAddress)
 	if err != nil {
-		webutils.WriteTempl(w, http.StatusInternalServerError, alerts.AlertError(fmt.Sprintf("Failed sign your transaction: %v", err)), r.Context())
+		webutils.WriteInternalServerError(w, fmt.Sprintf("Failed to build block template: %v", err))
 		return
 	}
+	webutils.WriteSuccess(w, template, "Next block template.")
+}
 
-	if err := bc.blockchain.AppendTransaction(signedTx); err != nil {
-		webutils.WriteTempl(w, http.StatusInternalServerError, alerts.AlertError(fmt.Sprintf("Failed add your transaction: %v", err)), r.Context())
+// GetMerkleProof returns the proof that a transaction is part of a block, so light clients
+// can check it against the block header without downloading the block.
+func (bc *BlockchainClientHandler) GetMerkleProof(w http.ResponseWriter, r *http.Request) {
+	proof, err := bc.blockchain.MerkleProof(chi.URLParam(r, "hash"), chi.URLParam(r, "txId"))
+	if err != nil {
+		webutils.WriteNotFound(w, err.Error())
 		return
 	}
-
-	webutils.WriteTempl(w, http.StatusOK, alerts.AlertInfo("Your transaction was successfull! Now just wait for your another block to be mined."), r.Context())
+	webutils.WriteSuccess(w, proof, "Merkle proof.")
 }
 
 func (bc *BlockchainClientHandler) ReplaceChain(w http.ResponseWriter, r *http.Request) {
@@ -192,26 +201,80 @@ func (bc *BlockchainClientHandler) ReplaceChain(w http.ResponseWriter, r *http.R
 	if replaced {
 		message = "Blockchain was successfully replaced."
 	} else {
-		message = "Blockchain was not replaced (current chain is valid and/or longer)."
+		message = "Blockchain was not replaced (no valid chain with more work was found)."
 	}
 
 	webutils.WriteTempl(w, http.StatusOK, alerts.AlertInfo(message), r.Context())
 }
 
+// GetReorgs lists the most recent chain reorganizations, oldest first.
+func (bc *BlockchainClientHandler) GetReorgs(w http.ResponseWriter, r *http.Request) {
+	webutils.WriteSuccess(w, bc.blockchain.Reorgs(), "Recent reorgs.")
+}
+
+type processBlockResponse struct {
+	Status blockchain.BlockStatus `json:"status"`
+}
+
+// ProcessBlock accepts a single block announced by a peer.
+func (bc *BlockchainClientHandler) ProcessBlock(w http.ResponseWriter, r *http.Request) {
+	block, err := webutils.ParseJSON[blockchain.Block](r.Body)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid block: %v", err))
+		return
+	}
+
+	status, err := bc.blockchain.ProcessBlock(block)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Block rejected: %v", err))
+		return
+	}
+	webutils.WriteSuccess(w, processBlockResponse{Status: status}, "Block accepted.")
+}
+
+// GetChainTips lists the tip of the active chain and of the side chains the node knows of.
+func (bc *BlockchainClientHandler) GetChainTips(w http.ResponseWriter, r *http.Request) {
+	webutils.WriteSuccess(w, bc.blockchain.ChainTips(), "Chain tips.")
+}
+
+type chainValidationResponse struct {
+	IsValid bool  `json:"isValid"`
+	Error   error `json:"error,omitempty"`
+}
+
 func (bc *BlockchainClientHandler) IsChainValid(w http.ResponseWriter, r *http.Request) {
-	isValid := blockchain.IsChainValid(bc.blockchain.Chain)
+	err := bc.blockchain.ValidateChain(bc.blockchain.GetChain())
+
+	respData := chainValidationResponse{
+		IsValid: err == nil,
+	}
 
-	respData := map[string]bool{
-		"isValid": isValid,
+	var validationErr *blockchain.ValidationError
+	if errors.As(err, &validationErr) {
+		respData.Error = validationErr
 	}
 	webutils.WriteSuccess(w, respData, "Blockchain validation status.")
 }
 
+func (bc *BlockchainClientHandler) CheckUTXOIndex(w http.ResponseWriter, r *http.Request) {
+	if err := bc.blockchain.CheckUTXOConsistency(); err != nil {
+		webutils.WriteInternalServerError(w, err.Error())
+		return
+	}
+	webutils.WriteSuccess[any](w, nil, "The UTXO index matches the chain.")
+}
+
 func (bc *BlockchainClientHandler) Register(r chi.Router) {
 	r.Get("/chain", bc.GetChain)
 	r.Get("/chain/is_valid", bc.IsChainValid)
+	r.Get("/chain/utxos/check", bc.CheckUTXOIndex)
 	r.Post("/chain/replace", bc.ReplaceChain)
+	r.Get("/chain/reorgs", bc.GetReorgs)
+	r.Get("/chain/tips", bc.GetChainTips)
+	r.Post("/blocks", bc.ProcessBlock)
 	r.Post("/chain/mine", bc.Mine)
+	r.Get("/chain/template", bc.GetBlockTemplate)
+	r.Get("/chain/blocks/{hash}/proof/{txId}", bc.GetMerkleProof)
 	r.Post("/transactions/add", bc.AppendTransaction)
-	r.Post("/transactions/buy", bc.BuyCoins)
+	r.Post("/transactions/bump", bc.BumpFee)
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/diegorezm/DBlockchain/internals/frontend/pages/blocks_page"
 	"github.com/diegorezm/DBlockchain/internals/frontend/pages/transactions_page"
 	"github.com/diegorezm/DBlockchain/internals/frontend/pages/wallet_page"
+	"github.com/diegorezm/DBlockchain/internals/utils"
 	webutils "github.com/diegorezm/DBlockchain/internals/web_utils"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -27,15 +28,24 @@ func (h *FrontendHandler) GetIndexPage(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *FrontendHandler) GetWalletPage(w http.ResponseWriter, r *http.Request) {
-	var utxos []blockchain.UTXO
+	var utxos, multisig []blockchain.UTXO
 
 	publicKey := getPublicKeyFromCookies(r)
 
-	if publicKey != "" {
+	// The wallet is saved as its public key, the address is derived from it. An HD wallet is
+	// saved as the extended public key of its account, and receives on a fresh address each time.
+	var address string
+	if account, err := utils.ParseExtendedKey(publicKey); err == nil {
+		if scan, err := h.blockchain.ScanHDWallet(account.Neuter(), blockchain.DefaultGapLimit); err == nil {
+			utxos, address = scan.UTXOs(), scan.NextReceive
+		}
+	} else if publicKey != "" {
 		utxos = h.blockchain.GetUTXPoolByAddress(publicKey)
+		multisig = h.blockchain.MultisigOutputs(publicKey)
+		address, _ = blockchain.AddressOf(publicKey)
 	}
 
-	walletPage := wallet_page.WalletPage(publicKey, utxos)
+	walletPage := wallet_page.WalletPage(publicKey, address, utxos, multisig)
 
 	ctx := r.Context()
 	if err := walletPage.Render(ctx, w); err != nil {
@@ -53,7 +63,7 @@ func (h *FrontendHandler) GetCreateWalletPage(w http.ResponseWriter, r *http.Req
 }
 
 func (h *FrontendHandler) GetBlocksPage(w http.ResponseWriter, r *http.Request) {
-	blocksPage := blocks_page.BlocksPage(h.blockchain.Chain)
+	blocksPage := blocks_page.BlocksPage(h.blockchain.GetChain())
 	ctx := r.Context()
 	if err := blocksPage.Render(ctx, w); err != nil {
 		webutils.WriteInternalServerError(w, err.Error())
@@ -63,7 +73,7 @@ func (h *FrontendHandler) GetBlocksPage(w http.ResponseWriter, r *http.Request)
 func (h *FrontendHandler) GetTransactionsPage(w http.ResponseWriter, r *http.Request) {
 	publicKey := getPublicKeyFromCookies(r)
 
-	transactionsPage := transactions_page.TransactionsPage(publicKey, h.blockchain.TransactionsMempool)
+	transactionsPage := transactions_page.TransactionsPage(publicKey, h.blockchain.Mempool.Entries(), h.blockchain.FeeBumpable(publicKey))
 
 	ctx := r.Context()
 
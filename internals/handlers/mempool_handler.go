@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/diegorezm/DBlockchain/internals/blockchain"
+	webutils "github.com/diegorezm/DBlockchain/internals/web_utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// MempoolHandler is the admin API of the mempool.
+type MempoolHandler struct {
+	blockchain *blockchain.Blockchain
+	adminToken string // Bearer token the requests must carry, the API is disabled when empty
+}
+
+func NewMempoolHandler(blockchain *blockchain.Blockchain, adminToken string) *MempoolHandler {
+	return &MempoolHandler{blockchain: blockchain, adminToken: adminToken}
+}
+
+type mempoolResponse struct {
+	Info    blockchain.MempoolInfo    `json:"info"`
+	Entries []blockchain.MempoolEntry `json:"entries"`
+}
+
+// ListEntries returns the policy and size of the mempool with every pending entry.
+func (mh *MempoolHandler) ListEntries(w http.ResponseWriter, r *http.Request) {
+	mh.blockchain.Mempool.Expire()
+	webutils.WriteSuccess(w, mempoolResponse{
+		Info:    mh.blockchain.Mempool.Info(),
+		Entries: mh.blockchain.Mempool.Entries(),
+	}, "Mempool entries.")
+}
+
+type mempoolEntryResponse struct {
+	blockchain.MempoolEntry
+	Package blockchain.TxPackage `json:"package"` // The entry with its pending ancestors
+}
+
+func (mh *MempoolHandler) GetEntry(w http.ResponseWriter, r *http.Request) {
+	txId := chi.URLParam(r, "txId")
+	entry, ok := mh.blockchain.Mempool.Get(txId)
+	pkg, inPackage := mh.blockchain.Mempool.Package(txId)
+	if !ok || !inPackage {
+		webutils.WriteNotFound(w, "The transaction is not in the mempool.")
+		return
+	}
+	webutils.WriteSuccess(w, mempoolEntryResponse{MempoolEntry: entry, Package: pkg}, "Mempool entry.")
+}
+
+// DropEntry removes a pending transaction and the ones spending its outputs, they can be sent again later.
+func (mh *MempoolHandler) DropEntry(w http.ResponseWriter, r *http.Request) {
+	removed := mh.blockchain.Mempool.RemoveWithDescendants(chi.URLParam(r, "txId"))
+	if len(removed) == 0 {
+		webutils.WriteNotFound(w, "The transaction is not in the mempool.")
+		return
+	}
+	webutils.WriteSuccess(w, removed, "Transactions dropped from the mempool.")
+}
+
+func (mh *MempoolHandler) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mh.adminToken == "" {
+			webutils.WriteCustomError(w, http.StatusForbidden, "The admin API is disabled, start the node with -admin-token to enable it.")
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(mh.adminToken)) != 1 {
+			webutils.WriteUnauthorized(w, "A valid admin token is required.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (mh *MempoolHandler) Register(r chi.Router) {
+	r.Route("/mempool", func(r chi.Router) {
+		r.Use(mh.requireAdmin)
+		r.Get("/", mh.ListEntries)
+		r.Get("/{txId}", mh.GetEntry)
+		r.Delete("/{txId}", mh.DropEntry)
+	})
+}
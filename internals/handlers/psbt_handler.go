@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/diegorezm/DBlockchain/internals/blockchain"
+	"github.com/diegorezm/DBlockchain/internals/utils"
+	webutils "github.com/diegorezm/DBlockchain/internals/web_utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// PSBTHandler is the API of partially signed transactions: one is created for a payment, passed
+// around to every cosigner, or to an offline machine, to be signed, then finalized and broadcast.
+type PSBTHandler struct {
+	blockchain *blockchain.Blockchain
+}
+
+func NewPSBTHandler(blockchain *blockchain.Blockchain) *PSBTHandler {
+	return &PSBTHandler{blockchain: blockchain}
+}
+
+type psbtResponse struct {
+	PSBT     string `json:"psbt"` // See blockchain.PartialTransaction.Encode
+	TxId     string `json:"tx_id"`
+	Complete bool   `json:"complete"` // Whether it can be finalized
+}
+
+func newPSBTResponse(p *blockchain.PartialTransaction) (psbtResponse, error) {
+	encoded, err := p.Encode()
+	if err != nil {
+		return psbtResponse{}, err
+	}
+	_, err = p.Finalize()
+	return psbtResponse{PSBT: encoded, TxId: p.Tx.Id, Complete: err == nil}, nil
+}
+
+func (ph *PSBTHandler) writePSBT(w http.ResponseWriter, p *blockchain.PartialTransaction, message string) {
+	response, err := newPSBTResponse(p)
+	if err != nil {
+		webutils.WriteInternalServerError(w, "Failed to encode the partially signed transaction.")
+		return
+	}
+	webutils.WriteSuccess(w, response, message)
+}
+
+// Create returns the unsigned transaction of a payment.
+func (ph *PSBTHandler) Create(w http.ResponseWriter, r *http.Request) {
+	payment, err := webutils.ParseJSON[blockchain.Payment](r.Body)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+
+	input, err := ph.blockchain.BuildPayment(payment)
+	if errors.Is(err, blockchain.ErrInsufficientFunds) {
+		webutils.WriteBadRequest(w, "Insufficient funds.")
+		return
+	}
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid payment: %v", err))
+		return
+	}
+
+	p, err := ph.blockchain.CreatePartialTransaction(input)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Failed to create the transaction: %v", err))
+		return
+	}
+	ph.writePSBT(w, p, "Partially signed transaction created.")
+}
+
+type signPSBTInput struct {
+	PSBT       string `json:"psbt"`
+	PrivateKey string `json:"private_key"`
+}
+
+// Sign adds the signature of the private key to every input it can unlock.
+func (ph *PSBTHandler) Sign(w http.ResponseWriter, r *http.Request) {
+	input, err := webutils.ParseJSON[signPSBTInput](r.Body)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	p, err := blockchain.DecodePartialTransaction(input.PSBT)
+	if err != nil {
+		webutils.WriteBadRequest(w, err.Error())
+		return
+	}
+	privKey, err := utils.DecodePrivateKey(input.PrivateKey)
+	if err != nil {
+		webutils.WriteBadRequest(w, "Failed to parse private key")
+		return
+	}
+
+	if _, err := p.Sign(privKey); err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Signing failed: %v", err))
+		return
+	}
+	ph.writePSBT(w, p, "Partially signed transaction signed.")
+}
+
+type combinePSBTInput struct {
+	PSBTs []string `json:"psbts"`
+}
+
+// Combine merges the signatures of copies of the same transaction signed by different cosigners.
+func (ph *PSBTHandler) Combine(w http.ResponseWriter, r *http.Request) {
+	input, err := webutils.ParseJSON[combinePSBTInput](r.Body)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+
+	copies := make([]*blockchain.PartialTransaction, len(input.PSBTs))
+	for i, encoded := range input.PSBTs {
+		if copies[i], err = blockchain.DecodePartialTransaction(encoded); err != nil {
+			webutils.WriteBadRequest(w, fmt.Sprintf("Copy %d: %v", i+1, err))
+			return
+		}
+	}
+	combined, err := blockchain.CombinePartialTransactions(copies...)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Failed to combine: %v", err))
+		return
+	}
+	ph.writePSBT(w, combined, "Partially signed transactions combined.")
+}
+
+type finalizePSBTInput struct {
+	PSBT string `json:"psbt"`
+}
+
+// Finalize returns the signed transaction, ready to be broadcast.
+func (ph *PSBTHandler) Finalize(w http.ResponseWriter, r *http.Request) {
+	input, err := webutils.ParseJSON[finalizePSBTInput](r.Body)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	p, err := blockchain.DecodePartialTransaction(input.PSBT)
+	if err != nil {
+		webutils.WriteBadRequest(w, err.Error())
+		return
+	}
+
+	tx, err := p.Finalize()
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Failed to finalize: %v", err))
+		return
+	}
+	webutils.WriteSuccess(w, tx, "Transaction finalized.")
+}
+
+// Broadcast adds a finalized transaction to the mempool.
+func (ph *PSBTHandler) Broadcast(w http.ResponseWriter, r *http.Request) {
+	tx, err := webutils.ParseJSON[blockchain.Transaction](r.Body)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	if err := ph.blockchain.AppendTransaction(&tx); err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Failed to add transaction: %v", err))
+		return
+	}
+	webutils.WriteSuccess(w, tx.Id, "Transaction added to pool.")
+}
+
+func (ph *PSBTHandler) Register(r chi.Router) {
+	r.Route("/psbt", func(r chi.Router) {
+		r.Post("/", ph.Create)
+		r.Post("/sign", ph.Sign)
+		r.Post("/combine", ph.Combine)
+		r.Post("/finalize", ph.Finalize)
+		r.Post("/broadcast", ph.Broadcast)
+	})
+}
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/diegorezm/DBlockchain/internals/blockchain"
+	"github.com/diegorezm/DBlockchain/internals/frontend/components/alerts"
 	"github.com/diegorezm/DBlockchain/internals/frontend/pages/wallet_page"
 	"github.com/diegorezm/DBlockchain/internals/utils"
 	webutils "github.com/diegorezm/DBlockchain/internals/web_utils"
@@ -27,6 +34,241 @@ func (wh *WalletHandler) GetUTXOsByAddress(w http.ResponseWriter, r *http.Reques
 	webutils.WriteJSON(w, 200, utxos, "Here are the unspent transactions for the given address.")
 }
 
+// GetMultisigUTXOs returns the multisig outputs the key of the address is one of the keys of.
+func (wh *WalletHandler) GetMultisigUTXOs(w http.ResponseWriter, r *http.Request) {
+	address := chi.URLParam(r, "address")
+	utxos := wh.blockchain.MultisigOutputs(address)
+	webutils.WriteJSON(w, 200, utxos, "Here are the multisig outputs the given address is a cosigner of.")
+}
+
+type createMultisigInput struct {
+	Threshold  int    `schema:"threshold"`
+	PublicKeys string `schema:"public_keys"` // One per line
+}
+
+// CreateMultisig returns the address of the outputs a threshold of the given keys can spend,
+// and the script spending them reveals.
+func (wh *WalletHandler) CreateMultisig(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		webutils.WriteTempl(w, http.StatusBadRequest, wallet_page.MultisigAddress("", "", 0, 0, alerts.AlertError("Could not parse your request.")), r.Context())
+		return
+	}
+
+	var input createMultisigInput
+	if err := decoder.Decode(&input, r.PostForm); err != nil {
+		webutils.WriteTempl(w, http.StatusBadRequest, wallet_page.MultisigAddress("", "", 0, 0, alerts.AlertError("Could not parse your request.")), r.Context())
+		return
+	}
+
+	publicKeys := strings.Fields(input.PublicKeys)
+	address, script, err := blockchain.MultisigAddress(input.Threshold, publicKeys)
+	if err != nil {
+		alert := alerts.AlertError(fmt.Sprintf("Failed to create the multisig address: %v", err))
+		webutils.WriteTempl(w, http.StatusBadRequest, wallet_page.MultisigAddress("", "", 0, 0, alert), r.Context())
+		return
+	}
+	multisig := wallet_page.MultisigAddress(address, hex.EncodeToString(script), input.Threshold, len(publicKeys), nil)
+	webutils.WriteTempl(w, http.StatusOK, multisig, r.Context())
+}
+
+type createHTLCInput struct {
+	PrivateKey string            `json:"private_key"`
+	From       string            `json:"from"`
+	Recipient  string            `json:"recipient"`
+	Amount     blockchain.Amount `json:"amount"`
+	Fee        blockchain.Amount `json:"fee"`
+	LockTime   uint32            `json:"lock_time"` // Block height or timestamp the sender can get a refund from
+	Hash       string            `json:"hash"`      // Hex encoded, a new secret is made when empty
+}
+
+type htlcResponse struct {
+	Address string            `json:"address"`
+	Script  blockchain.Script `json:"script"` // Hex encoded, claiming or refunding the contract needs it
+	Hash    string            `json:"hash"`
+	Secret  string            `json:"secret,omitempty"` // Only when the secret was made for the request
+	TxId    string            `json:"tx_id"`
+}
+
+// CreateHTLC locks coins of the sender to a hash-time-locked contract with the recipient.
+// The side starting a swap lets a secret be made, the other side reuses its hash.
+func (wh *WalletHandler) CreateHTLC(w http.ResponseWriter, r *http.Request) {
+	input, err := webutils.ParseJSON[createHTLCInput](r.Body)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	privKey, err := utils.DecodePrivateKey(input.PrivateKey)
+	if err != nil {
+		webutils.WriteBadRequest(w, "Failed to parse private key")
+		return
+	}
+
+	response := htlcResponse{Hash: input.Hash}
+	if input.Hash == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			webutils.WriteInternalServerError(w, "Failed to make a secret")
+			return
+		}
+		hash := sha256.Sum256(secret)
+		response.Secret, response.Hash = hex.EncodeToString(secret), hex.EncodeToString(hash[:])
+	}
+	hash, err := hex.DecodeString(response.Hash)
+	if err != nil {
+		webutils.WriteBadRequest(w, "The hash must be hex encoded")
+		return
+	}
+
+	response.Address, response.Script, err = blockchain.HTLCAddress(hash, input.Recipient, input.From, input.LockTime)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid contract: %v", err))
+		return
+	}
+	txInput, err := wh.blockchain.BuildPayment(blockchain.Payment{From: input.From, To: response.Address, Amount: input.Amount, Fee: input.Fee})
+	if errors.Is(err, blockchain.ErrInsufficientFunds) {
+		webutils.WriteBadRequest(w, "Insufficient funds.")
+		return
+	}
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid payment: %v", err))
+		return
+	}
+	tx, err := wh.blockchain.SignTransaction(txInput, privKey)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Signing failed: %v", err))
+		return
+	}
+	if err := wh.blockchain.AppendTransaction(tx); err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Failed to add transaction: %v", err))
+		return
+	}
+	response.TxId = tx.Id
+	webutils.WriteSuccess(w, response, "Coins locked to the contract.")
+}
+
+type spendHTLCInput struct {
+	PrivateKey string            `json:"private_key"`
+	Script     blockchain.Script `json:"script"` // Hex encoded, the script of the contract CreateHTLC returned
+	Secret     string            `json:"secret"` // Hex encoded, only to claim
+	Fee        blockchain.Amount `json:"fee"`
+}
+
+// ClaimHTLC pays what a contract holds to its recipient, who knows the secret.
+func (wh *WalletHandler) ClaimHTLC(w http.ResponseWriter, r *http.Request) {
+	wh.spendHTLC(w, r, true)
+}
+
+// RefundHTLC pays what a contract holds back to its sender, once its lock time is reached.
+func (wh *WalletHandler) RefundHTLC(w http.ResponseWriter, r *http.Request) {
+	wh.spendHTLC(w, r, false)
+}
+
+func (wh *WalletHandler) spendHTLC(w http.ResponseWriter, r *http.Request, claim bool) {
+	input, err := webutils.ParseJSON[spendHTLCInput](r.Body)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	privKey, err := utils.DecodePrivateKey(input.PrivateKey)
+	if err != nil {
+		webutils.WriteBadRequest(w, "Failed to parse private key")
+		return
+	}
+
+	var tx *blockchain.Transaction
+	if claim {
+		secret, decodeErr := hex.DecodeString(input.Secret)
+		if decodeErr != nil {
+			webutils.WriteBadRequest(w, "The secret must be hex encoded")
+			return
+		}
+		tx, err = wh.blockchain.ClaimHTLC(input.Script, secret, privKey, input.Fee)
+	} else {
+		tx, err = wh.blockchain.RefundHTLC(input.Script, privKey, input.Fee)
+	}
+	if errors.Is(err, blockchain.ErrNonFinal) {
+		webutils.WriteBadRequest(w, fmt.Sprintf("The contract can't be refunded yet: %v", err))
+		return
+	}
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Failed to spend the contract: %v", err))
+		return
+	}
+	webutils.WriteSuccess(w, tx, "Transaction added to pool.")
+}
+
+type hdWalletResponse struct {
+	Seed                string `json:"seed"` // Hex encoded, the only thing to back up
+	AccountPath         string `json:"account_path"`
+	ExtendedPrivateKey  string `json:"extended_private_key"` // Of the account, signs its payments
+	ExtendedPublicKey   string `json:"extended_public_key"`  // Of the account, enough to watch it
+	FirstReceiveAddress string `json:"first_receive_address"`
+}
+
+// GenerateHDWallet creates a hierarchical deterministic wallet from a new seed.
+func (wh *WalletHandler) GenerateHDWallet(w http.ResponseWriter, r *http.Request) {
+	seed, err := utils.GenerateSeed()
+	if err != nil {
+		log.Printf("%v", err)
+		webutils.WriteInternalServerError(w, "Something went wrong while generating your seed")
+		return
+	}
+	master, err := utils.NewMasterKey(seed)
+	if err != nil {
+		log.Printf("%v", err)
+		webutils.WriteInternalServerError(w, "Failed to derive the master key")
+		return
+	}
+	account, err := master.Derive(blockchain.HDAccountPath)
+	if err != nil {
+		log.Printf("%v", err)
+		webutils.WriteInternalServerError(w, "Failed to derive the account key")
+		return
+	}
+	scan, err := wh.blockchain.ScanHDWallet(account.Neuter(), 1)
+	if err != nil {
+		log.Printf("%v", err)
+		webutils.WriteInternalServerError(w, "Failed to derive the first address")
+		return
+	}
+
+	webutils.WriteSuccess(w, hdWalletResponse{
+		Seed:                hex.EncodeToString(seed),
+		AccountPath:         blockchain.HDAccountPath,
+		ExtendedPrivateKey:  account.String(),
+		ExtendedPublicKey:   account.Neuter().String(),
+		FirstReceiveAddress: scan.NextReceive,
+	}, "Here is your new HD wallet, keep the seed safe.")
+}
+
+// ScanHDWallet returns the addresses of an account holding outputs, and the next ones to use,
+// from its extended public key.
+func (wh *WalletHandler) ScanHDWallet(w http.ResponseWriter, r *http.Request) {
+	account, err := utils.ParseExtendedKey(chi.URLParam(r, "xpub"))
+	if err != nil {
+		webutils.WriteBadRequest(w, err.Error())
+		return
+	}
+	gapLimit := blockchain.DefaultGapLimit
+	if v := r.URL.Query().Get("gap_limit"); v != "" {
+		if gapLimit, err = strconv.Atoi(v); err != nil {
+			webutils.WriteBadRequest(w, "The gap limit must be a number")
+			return
+		}
+		if gapLimit > blockchain.MaxGapLimit {
+			webutils.WriteBadRequest(w, fmt.Sprintf("The gap limit can't be over %d", blockchain.MaxGapLimit))
+			return
+		}
+	}
+
+	scan, err := wh.blockchain.ScanHDWallet(account.Neuter(), gapLimit)
+	if err != nil {
+		webutils.WriteBadRequest(w, fmt.Sprintf("Failed to scan the wallet: %v", err))
+		return
+	}
+	webutils.WriteSuccess(w, scan, "Here are the addresses of the wallet.")
+}
+
 func (wh *WalletHandler) Generate(w http.ResponseWriter, r *http.Request) {
 	priv, err := utils.GenerateKeyPair()
 
@@ -43,7 +285,14 @@ func (wh *WalletHandler) Generate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page := wallet_page.PublicAndPrivateKeyGeneration(keypair.PublicKey, keypair.PrivateKey, true)
+	address, err := blockchain.NewAddress(&priv.PublicKey)
+	if err != nil {
+		log.Printf("%v", err)
+		webutils.WriteInternalServerError(w, "Failed to encode address")
+		return
+	}
+
+	page := wallet_page.PublicAndPrivateKeyGeneration(keypair.PublicKey, keypair.PrivateKey, address, true)
 	w.Header().Set("Content-Type", "text/html")
 	page.Render(r.Context(), w)
 }
@@ -90,4 +339,11 @@ func (wh *WalletHandler) Register(r chi.Router) {
 	r.Post("/wallet/save-key", wh.SavePubKey)
 	r.Post("/wallet/forget-key", wh.ForgetPublicKey)
 	r.Get("/wallet/utxos/{address}", wh.GetUTXOsByAddress)
+	r.Get("/wallet/utxos/{address}/multisig", wh.GetMultisigUTXOs)
+	r.Post("/wallet/multisig", wh.CreateMultisig)
+	r.Post("/wallet/hd/generate", wh.GenerateHDWallet)
+	r.Get("/wallet/hd/{xpub}", wh.ScanHDWallet)
+	r.Post("/wallet/htlc", wh.CreateHTLC)
+	r.Post("/wallet/htlc/claim", wh.ClaimHTLC)
+	r.Post("/wallet/htlc/refund", wh.RefundHTLC)
 }
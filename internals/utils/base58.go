@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// The Base58 alphabet leaves out 0, O, I and l, which are easy to mix up.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var ErrChecksumMismatch = errors.New("checksum mismatch, the text was probably mistyped")
+
+// EncodeBase58 writes data in Base58. Each leading zero byte is written as a 1.
+func EncodeBase58(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix, mod := big.NewInt(58), new(big.Int)
+
+	encoded := make([]byte, 0, len(data)*138/100+1)
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+// DecodeBase58 reads text written by EncodeBase58.
+func DecodeBase58(text string) ([]byte, error) {
+	n, radix := new(big.Int), big.NewInt(58)
+	for i, c := range text {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid Base58 character %q at %d", c, i)
+		}
+		n.Mul(n, radix).Add(n, big.NewInt(int64(digit)))
+	}
+
+	zeros := len(text) - len(strings.TrimLeft(text, base58Alphabet[:1]))
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// EncodeBase58Check writes the version byte and the payload in Base58, followed by the first
+// four bytes of the double SHA-256 of both, which catches almost every typo.
+func EncodeBase58Check(version byte, payload []byte) string {
+	data := append([]byte{version}, payload...)
+	return EncodeBase58(append(data, base58Checksum(data)...))
+}
+
+// DecodeBase58Check reads text written by EncodeBase58Check and checks its checksum.
+func DecodeBase58Check(text string) (byte, []byte, error) {
+	data, err := DecodeBase58(text)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 5 {
+		return 0, nil, errors.New("too short for a version and a checksum")
+	}
+	data, checksum := data[:len(data)-4], data[len(data)-4:]
+	if !bytes.Equal(base58Checksum(data), checksum) {
+		return 0, nil, ErrChecksumMismatch
+	}
+	return data[0], data[1:], nil
+}
+
+func base58Checksum(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestBase58_Vectors(t *testing.T) {
+	tests := []struct {
+		hex     string
+		encoded string
+	}{
+		{"", ""},
+		{"00", "1"},
+		{"68656c6c6f20776f726c64", "StV1DL6CwTryKyV"},
+		{"000000287fb4cd", "111233QC4"},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.hex)
+		if got := EncodeBase58(data); got != tt.encoded {
+			t.Errorf("EncodeBase58(%s) = %q, want %q", tt.hex, got, tt.encoded)
+		}
+		decoded, err := DecodeBase58(tt.encoded)
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("DecodeBase58(%q) = %x, %v, want %s", tt.encoded, decoded, err, tt.hex)
+		}
+	}
+
+	if _, err := DecodeBase58("0OIl"); err == nil {
+		t.Error("DecodeBase58() of characters outside the alphabet should fail")
+	}
+}
+
+func TestBase58Check_CatchesTypos(t *testing.T) {
+	payload, _ := hex.DecodeString("010966776006953d5567439e5e39f86a0d273bee")
+	encoded := EncodeBase58Check(0x00, payload)
+	if want := "16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM"; encoded != want {
+		t.Fatalf("EncodeBase58Check() = %q, want %q", encoded, want)
+	}
+
+	version, decoded, err := DecodeBase58Check(encoded)
+	if err != nil || version != 0x00 || !bytes.Equal(decoded, payload) {
+		t.Fatalf("DecodeBase58Check() = %d, %x, %v", version, decoded, err)
+	}
+
+	typo := encoded[:10] + "X" + encoded[11:]
+	if _, _, err := DecodeBase58Check(typo); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("DecodeBase58Check(%q) = %v, want ErrChecksumMismatch", typo, err)
+	}
+	if _, _, err := DecodeBase58Check("1111"); err == nil {
+		t.Error("DecodeBase58Check() of text too short for a checksum should fail")
+	}
+}
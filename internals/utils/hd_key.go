@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Indexes from HardenedKeyStart on derive hardened children, which only the private key can
+// derive. The others can also be derived from the extended public key, so a watch-only
+// wallet can find every address of an account without holding its private key.
+const HardenedKeyStart uint32 = 1 << 31
+
+// Extended keys are Base58Check encoded with these versions, see EncodeBase58Check.
+const (
+	ExtendedPrivateKeyVersion byte = 0x10
+	ExtendedPublicKeyVersion  byte = 0x11
+)
+
+// masterKeySalt is the HMAC key the master key is derived from the seed with, the one SLIP-10
+// uses for P-256.
+var masterKeySalt = []byte("Nist256p1 seed")
+
+var ErrDerivedPrivateKeyFromPublic = errors.New("a hardened child can't be derived from a public key")
+
+// ExtendedKey is a key of a hierarchical deterministic wallet, as in BIP32 over P-256 following
+// SLIP-10: a key and a chain code, from which the keys of its children are derived.
+type ExtendedKey struct {
+	privKey   []byte // 32 byte scalar, nil for an extended public key
+	pubKey    []byte // Compressed point
+	chainCode []byte
+	depth     byte
+	parent    [4]byte // Fingerprint of the parent key
+	index     uint32
+}
+
+// GenerateSeed returns a random seed to derive a wallet from. It is the only thing to back up.
+func GenerateSeed() ([]byte, error) {
+	seed := make([]byte, 32)
+	_, err := rand.Read(seed)
+	return seed, err
+}
+
+// NewMasterKey derives the root of a wallet from its seed, 16 to 64 bytes long.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("seed of %d bytes, it must have 16 to 64", len(seed))
+	}
+
+	// One in 2^32 seeds gives an invalid key, SLIP-10 hashes again until it doesn't.
+	data := seed
+	for {
+		sum := hmacSHA512(masterKeySalt, data)
+		k := new(big.Int).SetBytes(sum[:32])
+		if k.Sign() > 0 && k.Cmp(curveOrder()) < 0 {
+			return newPrivateExtendedKey(sum[:32], sum[32:], 0, [4]byte{}, 0), nil
+		}
+		data = sum
+	}
+}
+
+func newPrivateExtendedKey(privKey, chainCode []byte, depth byte, parent [4]byte, index uint32) *ExtendedKey {
+	x, y := elliptic.P256().ScalarBaseMult(privKey)
+	return &ExtendedKey{
+		privKey:   privKey,
+		pubKey:    elliptic.MarshalCompressed(elliptic.P256(), x, y),
+		chainCode: chainCode,
+		depth:     depth,
+		parent:    parent,
+		index:     index,
+	}
+}
+
+func curveOrder() *big.Int {
+	return elliptic.P256().Params().N
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// IsPrivate reports whether the key holds its private key.
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.privKey != nil
+}
+
+// Depth is how many derivations the key is away from the master key.
+func (k *ExtendedKey) Depth() byte {
+	return k.depth
+}
+
+// Index is the index the key was derived at from its parent.
+func (k *ExtendedKey) Index() uint32 {
+	return k.index
+}
+
+func (k *ExtendedKey) fingerprint() [4]byte {
+	sum := sha256.Sum256(k.pubKey)
+	return [4]byte(sum[:4])
+}
+
+// Child derives the child of the key at index, a hardened one from HardenedKeyStart on.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if k.depth == 0xff {
+		return nil, errors.New("the key is too deep to derive children")
+	}
+	hardened := index >= HardenedKeyStart
+	if hardened && !k.IsPrivate() {
+		return nil, ErrDerivedPrivateKeyFromPublic
+	}
+
+	var data []byte
+	if hardened {
+		data = append([]byte{0x00}, k.privKey...)
+	} else {
+		data = append([]byte(nil), k.pubKey...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	curve := elliptic.P256()
+	for {
+		sum := hmacSHA512(k.chainCode, data)
+		tweak, chainCode := sum[:32], sum[32:]
+		// Like the master key, an invalid child is skipped by hashing again, as SLIP-10 does.
+		data = binary.BigEndian.AppendUint32(append([]byte{0x01}, chainCode...), index)
+
+		t := new(big.Int).SetBytes(tweak)
+		if t.Cmp(curveOrder()) >= 0 {
+			continue
+		}
+
+		if k.IsPrivate() {
+			child := t.Add(t, new(big.Int).SetBytes(k.privKey))
+			child.Mod(child, curveOrder())
+			if child.Sign() == 0 {
+				continue
+			}
+			return newPrivateExtendedKey(child.FillBytes(make([]byte, 32)), chainCode, k.depth+1, k.fingerprint(), index), nil
+		}
+
+		parentX, parentY := elliptic.UnmarshalCompressed(curve, k.pubKey)
+		tx, ty := curve.ScalarBaseMult(tweak)
+		x, y := curve.Add(tx, ty, parentX, parentY)
+		if x.Sign() == 0 && y.Sign() == 0 {
+			continue
+		}
+		return &ExtendedKey{
+			pubKey:    elliptic.MarshalCompressed(curve, x, y),
+			chainCode: chainCode,
+			depth:     k.depth + 1,
+			parent:    k.fingerprint(),
+			index:     index,
+		}, nil
+	}
+}
+
+// Derive follows a path of child indexes such as m/0'/1/5, where ' marks a hardened index.
+// The leading m, the key itself, is optional.
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(path), "m"), "/")
+	key := k
+	if path == "" {
+		return key, nil
+	}
+	for _, step := range strings.Split(path, "/") {
+		offset := uint32(0)
+		if trimmed, ok := strings.CutSuffix(step, "'"); ok {
+			step, offset = trimmed, HardenedKeyStart
+		}
+		index, err := strconv.ParseUint(step, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derivation path step %q", step)
+		}
+		if key, err = key.Child(uint32(index) + offset); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// Neuter returns the extended public key of the key, which derives the same non-hardened
+// public keys but none of the private ones.
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	neutered := *k
+	neutered.privKey = nil
+	return &neutered
+}
+
+// PrivateKey returns the private key, it fails for an extended public key.
+func (k *ExtendedKey) PrivateKey() (*ecdsa.PrivateKey, error) {
+	if !k.IsPrivate() {
+		return nil, errors.New("an extended public key has no private key")
+	}
+	pubKey, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PrivateKey{PublicKey: *pubKey, D: new(big.Int).SetBytes(k.privKey)}, nil
+}
+
+// PublicKey returns the public key.
+func (k *ExtendedKey) PublicKey() (*ecdsa.PublicKey, error) {
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), k.pubKey)
+	if x == nil {
+		return nil, errors.New("invalid public key")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+// String writes the key in Base58Check: its depth, the fingerprint of its parent, its index,
+// its chain code and its key, the private key prefixed by a zero byte or the compressed public key.
+func (k *ExtendedKey) String() string {
+	version, key := ExtendedPublicKeyVersion, k.pubKey
+	if k.IsPrivate() {
+		version, key = ExtendedPrivateKeyVersion, append([]byte{0x00}, k.privKey...)
+	}
+
+	payload := append([]byte{k.depth}, k.parent[:]...)
+	payload = binary.BigEndian.AppendUint32(payload, k.index)
+	payload = append(payload, k.chainCode...)
+	return EncodeBase58Check(version, append(payload, key...))
+}
+
+// ParseExtendedKey reads a key written by String.
+func ParseExtendedKey(text string) (*ExtendedKey, error) {
+	version, payload, err := DecodeBase58Check(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("extended key: %w", err)
+	}
+	if len(payload) != 1+4+4+32+33 {
+		return nil, fmt.Errorf("extended key of %d bytes, want %d", len(payload), 1+4+4+32+33)
+	}
+
+	k := &ExtendedKey{
+		depth:     payload[0],
+		parent:    [4]byte(payload[1:5]),
+		index:     binary.BigEndian.Uint32(payload[5:9]),
+		chainCode: payload[9:41],
+	}
+	key := payload[41:]
+	switch version {
+	case ExtendedPrivateKeyVersion:
+		d := new(big.Int).SetBytes(key[1:])
+		if key[0] != 0x00 || d.Sign() == 0 || d.Cmp(curveOrder()) >= 0 {
+			return nil, errors.New("extended key: invalid private key")
+		}
+		return newPrivateExtendedKey(key[1:], k.chainCode, k.depth, k.parent, k.index), nil
+	case ExtendedPublicKeyVersion:
+		if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), key); x == nil {
+			return nil, errors.New("extended key: invalid public key")
+		}
+		k.pubKey = key
+		return k, nil
+	default:
+		return nil, fmt.Errorf("extended key: unknown version %d", version)
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// The vectors are the first ones of SLIP-10 for P-256.
+func TestExtendedKey_Vectors(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path      string
+		chainCode string
+		privKey   string
+		pubKey    string
+	}{
+		{
+			path:      "m",
+			chainCode: "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			privKey:   "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+			pubKey:    "0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8",
+		},
+		{
+			path:      "m/0'",
+			chainCode: "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			privKey:   "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+			pubKey:    "0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c",
+		},
+	}
+	for _, tt := range tests {
+		key, err := master.Derive(tt.path)
+		if err != nil {
+			t.Fatalf("Derive(%s) = %v", tt.path, err)
+		}
+		if got := hex.EncodeToString(key.chainCode); got != tt.chainCode {
+			t.Errorf("%s: chain code %s, want %s", tt.path, got, tt.chainCode)
+		}
+		if got := hex.EncodeToString(key.privKey); got != tt.privKey {
+			t.Errorf("%s: private key %s, want %s", tt.path, got, tt.privKey)
+		}
+		if got := hex.EncodeToString(key.pubKey); got != tt.pubKey {
+			t.Errorf("%s: public key %s, want %s", tt.path, got, tt.pubKey)
+		}
+	}
+}
+
+func TestExtendedKey_WatchOnlyDerivation(t *testing.T) {
+	seed, err := GenerateSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, _ := NewMasterKey(seed)
+	account, err := master.Derive("m/0'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The extended public key travels as text to the watch-only wallet.
+	xpub, err := ParseExtendedKey(account.Neuter().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xpub.IsPrivate() {
+		t.Fatal("the parsed extended public key holds a private key")
+	}
+	if _, err := xpub.Derive("0'"); !errors.Is(err, ErrDerivedPrivateKeyFromPublic) {
+		t.Errorf("Derive(0') of an extended public key = %v, want ErrDerivedPrivateKeyFromPublic", err)
+	}
+
+	for _, path := range []string{"0/0", "0/7", "1/3"} {
+		private, err := account.Derive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, err := xpub.Derive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(private.pubKey, public.pubKey) || !bytes.Equal(private.chainCode, public.chainCode) {
+			t.Errorf("%s: the public derivation differs from the private one", path)
+		}
+
+		privKey, err := private.PrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKey, _ := public.PublicKey()
+		if !privKey.PublicKey.Equal(pubKey) {
+			t.Errorf("%s: the private key does not match the public key", path)
+		}
+	}
+
+	xprv, err := ParseExtendedKey(account.String())
+	if err != nil || xprv.String() != account.String() {
+		t.Errorf("ParseExtendedKey(String()) = %v, %v, want the same key", xprv, err)
+	}
+	if _, err := master.Derive("m/0''"); err == nil {
+		t.Error("Derive() of an invalid path should fail")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/base64"
+	"errors"
 )
 
 type EncodedKeyPair struct {
@@ -45,7 +46,11 @@ func DecodePublicKey(encoded string) (*ecdsa.PublicKey, error) {
 		return nil, err
 	}
 
-	return pub.(*ecdsa.PublicKey), nil
+	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA public key")
+	}
+	return ecdsaPub, nil
 }
 
 func DecodePrivateKey(encoded string) (*ecdsa.PrivateKey, error) {